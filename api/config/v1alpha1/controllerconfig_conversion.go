@@ -0,0 +1,51 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	ctrlconfigv1alpha1 "sigs.k8s.io/controller-runtime/pkg/config/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+)
+
+var _ conversion.Hub = &ControllerConfig{}
+
+// Hub marks ControllerConfig as the version of the configuration that other
+// versions convert to and from: the stock ControllerManagerConfig, through
+// ConvertFrom and ConvertTo, and later versions of ControllerConfig.
+func (*ControllerConfig) Hub() {}
+
+// ConvertFrom converts in, a ControllerManagerConfig of
+// controller-runtime.sigs.k8s.io/v1alpha1 as given to --config before
+// ControllerConfig existed, into c. The kcp and mirror sections of c are left
+// empty.
+func (c *ControllerConfig) ConvertFrom(in *ctrlconfigv1alpha1.ControllerManagerConfiguration) {
+	*c = ControllerConfig{}
+	c.APIVersion = GroupVersion.String()
+	c.Kind = "ControllerConfig"
+	in.ControllerManagerConfigurationSpec.DeepCopyInto(&c.ControllerManagerConfigurationSpec)
+}
+
+// ConvertTo converts c into out, a ControllerManagerConfig of
+// controller-runtime.sigs.k8s.io/v1alpha1, which the options of the manager
+// are completed from. The settings of c that have no equivalent there, i.e.
+// maxConcurrentReconciles and the kcp and mirror sections, are dropped.
+func (c *ControllerConfig) ConvertTo(out *ctrlconfigv1alpha1.ControllerManagerConfiguration) {
+	*out = ctrlconfigv1alpha1.ControllerManagerConfiguration{}
+	out.APIVersion = ctrlconfigv1alpha1.GroupVersion.String()
+	out.Kind = "ControllerManagerConfig"
+	c.ControllerManagerConfigurationSpec.DeepCopyInto(&out.ControllerManagerConfigurationSpec)
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrlconfigv1alpha1 "sigs.k8s.io/controller-runtime/pkg/config/v1alpha1"
+)
+
+func TestConvertFrom(t *testing.T) {
+	in := &ctrlconfigv1alpha1.ControllerManagerConfiguration{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: ctrlconfigv1alpha1.GroupVersion.String(),
+			Kind:       "ControllerManagerConfig",
+		},
+		ControllerManagerConfigurationSpec: ctrlconfigv1alpha1.ControllerManagerConfigurationSpec{
+			GracefulShutdownTimeout: &metav1.Duration{Duration: time.Minute},
+			Health:                  ctrlconfigv1alpha1.ControllerHealth{HealthProbeBindAddress: ":8081"},
+			Metrics:                 ctrlconfigv1alpha1.ControllerMetrics{BindAddress: "127.0.0.1:8080"},
+		},
+	}
+	c := ControllerConfig{MaxConcurrentReconciles: new(int), KCP: KCPConfig{APIExportName: "stale"}}
+	c.ConvertFrom(in)
+
+	if c.APIVersion != GroupVersion.String() || c.Kind != "ControllerConfig" {
+		t.Errorf("type = %s %s, want %s ControllerConfig", c.APIVersion, c.Kind, GroupVersion)
+	}
+	if !equality.Semantic.DeepEqual(c.ControllerManagerConfigurationSpec, in.ControllerManagerConfigurationSpec) {
+		t.Errorf("spec = %+v, want %+v", c.ControllerManagerConfigurationSpec, in.ControllerManagerConfigurationSpec)
+	}
+	if c.MaxConcurrentReconciles != nil || c.KCP.APIExportName != "" {
+		t.Errorf("settings of the previous config were kept: %+v", c)
+	}
+
+	// The converted config does not share the settings of in.
+	in.GracefulShutdownTimeout.Duration = time.Second
+	if c.GracefulShutdownTimeout.Duration != time.Minute {
+		t.Errorf("gracefulShutdownTimeout = %s, want it not to follow the input", c.GracefulShutdownTimeout.Duration)
+	}
+}
+
+func TestConvertTo(t *testing.T) {
+	reconciles := 2
+	c := &ControllerConfig{
+		ControllerManagerConfigurationSpec: ctrlconfigv1alpha1.ControllerManagerConfigurationSpec{
+			SyncPeriod: &metav1.Duration{Duration: time.Hour},
+			Health:     ctrlconfigv1alpha1.ControllerHealth{HealthProbeBindAddress: ":8081"},
+		},
+		MaxConcurrentReconciles: &reconciles,
+		KCP:                     KCPConfig{APIExportName: "widgets"},
+		Mirror:                  MirrorConfig{Targets: []MirrorTargetConfig{{Name: "east", Kubeconfig: "/etc/mirror/east"}}},
+	}
+	out := ctrlconfigv1alpha1.ControllerManagerConfiguration{
+		ControllerManagerConfigurationSpec: ctrlconfigv1alpha1.ControllerManagerConfigurationSpec{
+			Metrics: ctrlconfigv1alpha1.ControllerMetrics{BindAddress: "stale"},
+		},
+	}
+	c.ConvertTo(&out)
+
+	if out.APIVersion != ctrlconfigv1alpha1.GroupVersion.String() || out.Kind != "ControllerManagerConfig" {
+		t.Errorf("type = %s %s, want %s ControllerManagerConfig", out.APIVersion, out.Kind, ctrlconfigv1alpha1.GroupVersion)
+	}
+	if !equality.Semantic.DeepEqual(out.ControllerManagerConfigurationSpec, c.ControllerManagerConfigurationSpec) {
+		t.Errorf("spec = %+v, want %+v", out.ControllerManagerConfigurationSpec, c.ControllerManagerConfigurationSpec)
+	}
+
+	// Converting back keeps the manager settings only.
+	var back ControllerConfig
+	back.ConvertFrom(&out)
+	if !equality.Semantic.DeepEqual(back.ControllerManagerConfigurationSpec, c.ControllerManagerConfigurationSpec) {
+		t.Errorf("round trip spec = %+v, want %+v", back.ControllerManagerConfigurationSpec, c.ControllerManagerConfigurationSpec)
+	}
+	if back.MaxConcurrentReconciles != nil || back.KCP.APIExportName != "" || len(back.Mirror.Targets) != 0 {
+		t.Errorf("round trip kept settings without a ControllerManagerConfig equivalent: %+v", back)
+	}
+
+	// The converted config does not share the settings of c.
+	c.SyncPeriod.Duration = time.Second
+	if out.SyncPeriod.Duration != time.Hour {
+		t.Errorf("syncPeriod = %s, want it not to follow the input", out.SyncPeriod.Duration)
+	}
+}
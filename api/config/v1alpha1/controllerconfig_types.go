@@ -0,0 +1,207 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrlconfigv1alpha1 "sigs.k8s.io/controller-runtime/pkg/config/v1alpha1"
+
+	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
+)
+
+// KCPConfig holds the settings of the kcp mode.
+type KCPConfig struct {
+	// APIExportName is the name of the APIExport whose virtual workspace is
+	// reconciled. When empty, the only APIExport of the workspace is used.
+	// +optional
+	APIExportName string `json:"apiExportName,omitempty"`
+}
+
+// MirrorTargetConfig is a cluster Widgets are mirrored into.
+type MirrorTargetConfig struct {
+	// Name identifies the target in routes and in the mirrored objects. It
+	// must be a DNS label.
+	Name string `json:"name"`
+
+	// Kubeconfig is the path of the kubeconfig of the cluster. The connection
+	// is reloaded whenever the file changes.
+	Kubeconfig string `json:"kubeconfig"`
+
+	// NamespaceMapping overrides MirrorConfig.NamespaceMapping for the target.
+	// +optional
+	NamespaceMapping string `json:"namespaceMapping,omitempty"`
+
+	// Transforms is the path of a file holding a YAML list of transforms
+	// applied to Widgets mirrored into the target.
+	// +optional
+	Transforms string `json:"transforms,omitempty"`
+
+	// DryRun overrides MirrorConfig.DryRun for the target.
+	// +optional
+	DryRun *bool `json:"dryRun,omitempty"`
+
+	// CreateNamespaces overrides MirrorConfig.CreateNamespaces for the target.
+	// +optional
+	CreateNamespaces *bool `json:"createNamespaces,omitempty"`
+}
+
+// MirrorWorkspaceRoute mirrors the objects of a kcp workspace into some of the
+// targets only.
+type MirrorWorkspaceRoute struct {
+	// Workspace is the logical cluster of the workspace, e.g. root:org:tenant.
+	Workspace string `json:"workspace"`
+
+	// Targets are the names of the targets the objects are mirrored into.
+	Targets []string `json:"targets"`
+}
+
+// MirrorWorkspacesConfig routes mirrored objects to targets by kcp workspace.
+type MirrorWorkspacesConfig struct {
+	// Routes route the objects of single workspaces.
+	// +optional
+	Routes []MirrorWorkspaceRoute `json:"routes,omitempty"`
+
+	// Label routes the objects of a workspace that is not routed by Routes
+	// into the target named by this label on its ClusterWorkspace.
+	// +optional
+	Label string `json:"label,omitempty"`
+
+	// DefaultTargets are the targets of workspaces that are not routed
+	// otherwise. When empty, such workspaces are not mirrored.
+	// +optional
+	DefaultTargets []string `json:"defaultTargets,omitempty"`
+}
+
+// MirrorGCConfig holds the settings of the sweep of the mirror targets for
+// orphaned mirrored objects.
+type MirrorGCConfig struct {
+	// Interval is how often the targets are swept. 0 disables the sweep.
+	// Defaults to the interval of the controller.
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// BatchSize is how many mirrored objects are listed from a target at a
+	// time. Defaults to the batch size of the controller.
+	// +optional
+	BatchSize *int64 `json:"batchSize,omitempty"`
+
+	// ReportOnly only logs and counts the orphaned mirrored objects instead
+	// of releasing them.
+	// +optional
+	ReportOnly *bool `json:"reportOnly,omitempty"`
+}
+
+// MirrorConfig holds the settings of the mirror mode.
+type MirrorConfig struct {
+	// Targets are the clusters Widgets are mirrored into.
+	// +optional
+	Targets []MirrorTargetConfig `json:"targets,omitempty"`
+
+	// Kinds are mirrored in addition to Widgets, given as <apiVersion>/<kind>,
+	// e.g. v1/ConfigMap.
+	// +optional
+	Kinds []string `json:"kinds,omitempty"`
+
+	// DryRun only sends server-side dry-run requests to the targets.
+	// +optional
+	DryRun *bool `json:"dryRun,omitempty"`
+
+	// NamespaceMapping maps reference namespaces to mirror namespaces:
+	// identity, fixed:<namespace>, prefix:<prefix>, suffix:<suffix> or
+	// template:<template>. Defaults to identity.
+	// +optional
+	NamespaceMapping string `json:"namespaceMapping,omitempty"`
+
+	// CreateNamespaces creates missing namespaces in the targets.
+	// +optional
+	CreateNamespaces *bool `json:"createNamespaces,omitempty"`
+
+	// NamespaceLabels are set on the namespaces created in the targets.
+	// +optional
+	NamespaceLabels map[string]string `json:"namespaceLabels,omitempty"`
+
+	// NamespaceAnnotations are set on the namespaces created in the targets.
+	// +optional
+	NamespaceAnnotations map[string]string `json:"namespaceAnnotations,omitempty"`
+
+	// LabelSelector only mirrors the Widgets whose labels match.
+	// +optional
+	LabelSelector string `json:"labelSelector,omitempty"`
+
+	// AnnotationSelector only mirrors the Widgets whose annotations match,
+	// given in label selector syntax.
+	// +optional
+	AnnotationSelector string `json:"annotationSelector,omitempty"`
+
+	// DeletionPolicy is applied to mirrored objects when their reference
+	// object is deleted. Defaults to Delete.
+	// +optional
+	DeletionPolicy tutorialkubebuilderiov1alpha1.DeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// DeletionTimeout bounds how long the deletion or deselection of a
+	// reference object waits for an unreachable target. Defaults to the timeout of the
+	// controller.
+	// +optional
+	DeletionTimeout *metav1.Duration `json:"deletionTimeout,omitempty"`
+
+	// DriftPolicy is applied to mirrored objects modified in the targets.
+	// Defaults to Revert.
+	// +optional
+	DriftPolicy tutorialkubebuilderiov1alpha1.DriftPolicy `json:"driftPolicy,omitempty"`
+
+	// ConflictPolicy is applied when fields of mirrored objects are owned by
+	// other field managers in the targets. Defaults to Force.
+	// +optional
+	ConflictPolicy tutorialkubebuilderiov1alpha1.ConflictPolicy `json:"conflictPolicy,omitempty"`
+
+	// Workspaces routes mirrored objects to targets by kcp workspace.
+	// +optional
+	Workspaces MirrorWorkspacesConfig `json:"workspaces,omitempty"`
+
+	// GC configures the sweep of the targets for orphaned mirrored objects.
+	// +optional
+	GC MirrorGCConfig `json:"gc,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ControllerConfig is the configuration of the controller. It holds the
+// settings of the manager, as a ControllerManagerConfig does, along with the
+// settings of the kcp and mirror modes.
+type ControllerConfig struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// ControllerManagerConfigurationSpec holds the settings of the manager.
+	ctrlconfigv1alpha1.ControllerManagerConfigurationSpec `json:",inline"`
+
+	// MaxConcurrentReconciles is how many objects each controller reconciles
+	// at a time. Defaults to controller.groupKindConcurrency, or 1.
+	// +optional
+	MaxConcurrentReconciles *int `json:"maxConcurrentReconciles,omitempty"`
+
+	// KCP holds the settings of the kcp mode.
+	// +optional
+	KCP KCPConfig `json:"kcp,omitempty"`
+
+	// Mirror holds the settings of the mirror mode.
+	// +optional
+	Mirror MirrorConfig `json:"mirror,omitempty"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ControllerConfig{})
+}
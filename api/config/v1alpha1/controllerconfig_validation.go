@@ -0,0 +1,139 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
+)
+
+// DefaultNamespaceMapping is the namespace mapping of targets that set none.
+const DefaultNamespaceMapping = "identity"
+
+// Default sets the settings of c that are left unset to their defaults.
+// Settings the controller defaults itself, such as durations, are left unset.
+func (c *ControllerConfig) Default() {
+	if c.Mirror.NamespaceMapping == "" {
+		c.Mirror.NamespaceMapping = DefaultNamespaceMapping
+	}
+	if c.Mirror.DeletionPolicy == "" {
+		c.Mirror.DeletionPolicy = tutorialkubebuilderiov1alpha1.DeletionPolicyDelete
+	}
+	if c.Mirror.DriftPolicy == "" {
+		c.Mirror.DriftPolicy = tutorialkubebuilderiov1alpha1.DriftPolicyRevert
+	}
+	if c.Mirror.ConflictPolicy == "" {
+		c.Mirror.ConflictPolicy = tutorialkubebuilderiov1alpha1.ConflictPolicyForce
+	}
+}
+
+// Validate checks the settings of c, whether defaulted or not. The syntax of
+// namespace mappings, selectors and kinds is checked by the controller when
+// it parses them.
+func (c *ControllerConfig) Validate() error {
+	var errs field.ErrorList
+	if c.MaxConcurrentReconciles != nil && *c.MaxConcurrentReconciles < 1 {
+		errs = append(errs, field.Invalid(field.NewPath("maxConcurrentReconciles"), *c.MaxConcurrentReconciles, "must be at least 1"))
+	}
+	errs = append(errs, c.Mirror.validate(field.NewPath("mirror"))...)
+	return errs.ToAggregate()
+}
+
+func (m *MirrorConfig) validate(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	names := map[string]bool{}
+	for i, t := range m.Targets {
+		targetPath := path.Child("targets").Index(i)
+		for _, msg := range validation.IsDNS1123Label(t.Name) {
+			errs = append(errs, field.Invalid(targetPath.Child("name"), t.Name, msg))
+		}
+		if names[t.Name] {
+			errs = append(errs, field.Duplicate(targetPath.Child("name"), t.Name))
+		}
+		names[t.Name] = true
+		if t.Kubeconfig == "" {
+			errs = append(errs, field.Required(targetPath.Child("kubeconfig"), ""))
+		}
+	}
+
+	errs = append(errs, validateKeyValues(path.Child("namespaceLabels"), m.NamespaceLabels, true)...)
+	errs = append(errs, validateKeyValues(path.Child("namespaceAnnotations"), m.NamespaceAnnotations, false)...)
+
+	if m.DeletionPolicy != "" && !m.DeletionPolicy.IsValid() {
+		errs = append(errs, field.NotSupported(path.Child("deletionPolicy"), m.DeletionPolicy, []string{
+			string(tutorialkubebuilderiov1alpha1.DeletionPolicyDelete),
+			string(tutorialkubebuilderiov1alpha1.DeletionPolicyOrphan),
+			string(tutorialkubebuilderiov1alpha1.DeletionPolicyRetain),
+		}))
+	}
+	if m.DriftPolicy != "" && !m.DriftPolicy.IsValid() {
+		errs = append(errs, field.NotSupported(path.Child("driftPolicy"), m.DriftPolicy, []string{
+			string(tutorialkubebuilderiov1alpha1.DriftPolicyRevert),
+			string(tutorialkubebuilderiov1alpha1.DriftPolicyAdopt),
+			string(tutorialkubebuilderiov1alpha1.DriftPolicyIgnore),
+		}))
+	}
+	if m.ConflictPolicy != "" && !m.ConflictPolicy.IsValid() {
+		errs = append(errs, field.NotSupported(path.Child("conflictPolicy"), m.ConflictPolicy, []string{
+			string(tutorialkubebuilderiov1alpha1.ConflictPolicyForce),
+			string(tutorialkubebuilderiov1alpha1.ConflictPolicyReport),
+		}))
+	}
+	if m.DeletionTimeout != nil && m.DeletionTimeout.Duration <= 0 {
+		errs = append(errs, field.Invalid(path.Child("deletionTimeout"), m.DeletionTimeout.Duration.String(), "must be positive"))
+	}
+
+	workspaces := map[string]bool{}
+	for i, r := range m.Workspaces.Routes {
+		routePath := path.Child("workspaces", "routes").Index(i)
+		if r.Workspace == "" {
+			errs = append(errs, field.Required(routePath.Child("workspace"), ""))
+		} else if workspaces[r.Workspace] {
+			errs = append(errs, field.Duplicate(routePath.Child("workspace"), r.Workspace))
+		}
+		workspaces[r.Workspace] = true
+		if len(r.Targets) == 0 {
+			errs = append(errs, field.Required(routePath.Child("targets"), ""))
+		}
+	}
+
+	if m.GC.Interval != nil && m.GC.Interval.Duration < 0 {
+		errs = append(errs, field.Invalid(path.Child("gc", "interval"), m.GC.Interval.Duration.String(), "must not be negative"))
+	}
+	if m.GC.BatchSize != nil && *m.GC.BatchSize < 1 {
+		errs = append(errs, field.Invalid(path.Child("gc", "batchSize"), *m.GC.BatchSize, "must be at least 1"))
+	}
+	return errs
+}
+
+// validateKeyValues checks the labels or annotations set on namespaces.
+func validateKeyValues(path *field.Path, m map[string]string, labelValues bool) field.ErrorList {
+	var errs field.ErrorList
+	for k, v := range m {
+		for _, msg := range validation.IsQualifiedName(k) {
+			errs = append(errs, field.Invalid(path, k, msg))
+		}
+		if labelValues {
+			for _, msg := range validation.IsValidLabelValue(v) {
+				errs = append(errs, field.Invalid(path.Key(k), v, msg))
+			}
+		}
+	}
+	return errs
+}
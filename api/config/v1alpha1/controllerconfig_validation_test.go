@@ -0,0 +1,188 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
+)
+
+func TestDefault(t *testing.T) {
+	var c ControllerConfig
+	c.Default()
+	if c.Mirror.NamespaceMapping != DefaultNamespaceMapping {
+		t.Errorf("namespaceMapping = %q, want %q", c.Mirror.NamespaceMapping, DefaultNamespaceMapping)
+	}
+	if c.Mirror.DeletionPolicy != tutorialkubebuilderiov1alpha1.DeletionPolicyDelete {
+		t.Errorf("deletionPolicy = %q, want Delete", c.Mirror.DeletionPolicy)
+	}
+	if c.Mirror.DriftPolicy != tutorialkubebuilderiov1alpha1.DriftPolicyRevert {
+		t.Errorf("driftPolicy = %q, want Revert", c.Mirror.DriftPolicy)
+	}
+	if c.Mirror.ConflictPolicy != tutorialkubebuilderiov1alpha1.ConflictPolicyForce {
+		t.Errorf("conflictPolicy = %q, want Force", c.Mirror.ConflictPolicy)
+	}
+	if c.Mirror.DeletionTimeout != nil || c.Mirror.GC.Interval != nil {
+		t.Errorf("durations are defaulted by the controller, got %v and %v", c.Mirror.DeletionTimeout, c.Mirror.GC.Interval)
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("defaulted config is invalid: %v", err)
+	}
+
+	set := ControllerConfig{Mirror: MirrorConfig{
+		NamespaceMapping: "prefix:mirror-",
+		DeletionPolicy:   tutorialkubebuilderiov1alpha1.DeletionPolicyRetain,
+		DriftPolicy:      tutorialkubebuilderiov1alpha1.DriftPolicyIgnore,
+		ConflictPolicy:   tutorialkubebuilderiov1alpha1.ConflictPolicyReport,
+	}}
+	want := *set.DeepCopy()
+	set.Default()
+	if set.Mirror.NamespaceMapping != want.Mirror.NamespaceMapping || set.Mirror.DeletionPolicy != want.Mirror.DeletionPolicy ||
+		set.Mirror.DriftPolicy != want.Mirror.DriftPolicy || set.Mirror.ConflictPolicy != want.Mirror.ConflictPolicy {
+		t.Errorf("Default overrode settings: got %+v, want %+v", set.Mirror, want.Mirror)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	zero := 0
+	one := 1
+	var noBatch int64
+	target := func(name string) MirrorTargetConfig {
+		return MirrorTargetConfig{Name: name, Kubeconfig: "/etc/mirror/" + name}
+	}
+	duration := func(d time.Duration) *metav1.Duration {
+		return &metav1.Duration{Duration: d}
+	}
+
+	tests := []struct {
+		name   string
+		config ControllerConfig
+		// errs are substrings of the expected error, none if empty.
+		errs []string
+	}{{
+		name:   "empty",
+		config: ControllerConfig{},
+	}, {
+		name: "valid",
+		config: ControllerConfig{
+			MaxConcurrentReconciles: &one,
+			Mirror: MirrorConfig{
+				Targets:         []MirrorTargetConfig{target("east"), target("west")},
+				NamespaceLabels: map[string]string{"team": "mirror"},
+				DeletionPolicy:  tutorialkubebuilderiov1alpha1.DeletionPolicyOrphan,
+				DeletionTimeout: duration(time.Minute),
+				Workspaces: MirrorWorkspacesConfig{Routes: []MirrorWorkspaceRoute{
+					{Workspace: "root:east", Targets: []string{"east"}},
+					{Workspace: "root:west", Targets: []string{"west"}},
+				}},
+				GC: MirrorGCConfig{Interval: duration(0)},
+			},
+		},
+	}, {
+		name:   "no concurrent reconciles",
+		config: ControllerConfig{MaxConcurrentReconciles: &zero},
+		errs:   []string{"maxConcurrentReconciles: Invalid value: 0"},
+	}, {
+		name:   "invalid target name",
+		config: ControllerConfig{Mirror: MirrorConfig{Targets: []MirrorTargetConfig{target("East_1")}}},
+		errs:   []string{`mirror.targets[0].name: Invalid value: "East_1"`},
+	}, {
+		name:   "duplicate target names",
+		config: ControllerConfig{Mirror: MirrorConfig{Targets: []MirrorTargetConfig{target("east"), target("east")}}},
+		errs:   []string{`mirror.targets[1].name: Duplicate value: "east"`},
+	}, {
+		name:   "target without kubeconfig",
+		config: ControllerConfig{Mirror: MirrorConfig{Targets: []MirrorTargetConfig{{Name: "east"}}}},
+		errs:   []string{"mirror.targets[0].kubeconfig: Required value"},
+	}, {
+		name:   "invalid namespace label",
+		config: ControllerConfig{Mirror: MirrorConfig{NamespaceLabels: map[string]string{"team": "not a value"}}},
+		errs:   []string{"mirror.namespaceLabels[team]: Invalid value"},
+	}, {
+		name:   "invalid namespace annotation key",
+		config: ControllerConfig{Mirror: MirrorConfig{NamespaceAnnotations: map[string]string{"not a key": "any value"}}},
+		errs:   []string{`mirror.namespaceAnnotations: Invalid value: "not a key"`},
+	}, {
+		name: "unsupported policies",
+		config: ControllerConfig{Mirror: MirrorConfig{
+			DeletionPolicy: "Keep",
+			DriftPolicy:    "Merge",
+			ConflictPolicy: "Skip",
+		}},
+		errs: []string{
+			`mirror.deletionPolicy: Unsupported value: "Keep"`,
+			`mirror.driftPolicy: Unsupported value: "Merge"`,
+			`mirror.conflictPolicy: Unsupported value: "Skip"`,
+		},
+	}, {
+		name:   "zero deletion timeout",
+		config: ControllerConfig{Mirror: MirrorConfig{DeletionTimeout: duration(0)}},
+		errs:   []string{"mirror.deletionTimeout: Invalid value: \"0s\": must be positive"},
+	}, {
+		name:   "negative deletion timeout",
+		config: ControllerConfig{Mirror: MirrorConfig{DeletionTimeout: duration(-time.Minute)}},
+		errs:   []string{"mirror.deletionTimeout: Invalid value: \"-1m0s\""},
+	}, {
+		name: "duplicate workspace routes",
+		config: ControllerConfig{Mirror: MirrorConfig{Workspaces: MirrorWorkspacesConfig{Routes: []MirrorWorkspaceRoute{
+			{Workspace: "root:east", Targets: []string{"east"}},
+			{Workspace: "root:east", Targets: []string{"west"}},
+		}}}},
+		errs: []string{`mirror.workspaces.routes[1].workspace: Duplicate value: "root:east"`},
+	}, {
+		name: "incomplete workspace route",
+		config: ControllerConfig{Mirror: MirrorConfig{Workspaces: MirrorWorkspacesConfig{Routes: []MirrorWorkspaceRoute{
+			{},
+		}}}},
+		errs: []string{
+			"mirror.workspaces.routes[0].workspace: Required value",
+			"mirror.workspaces.routes[0].targets: Required value",
+		},
+	}, {
+		name:   "negative gc interval",
+		config: ControllerConfig{Mirror: MirrorConfig{GC: MirrorGCConfig{Interval: duration(-time.Second)}}},
+		errs:   []string{"mirror.gc.interval: Invalid value"},
+	}, {
+		name:   "empty gc batches",
+		config: ControllerConfig{Mirror: MirrorConfig{GC: MirrorGCConfig{BatchSize: &noBatch}}},
+		errs:   []string{"mirror.gc.batchSize: Invalid value: 0: must be at least 1"},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if len(tt.errs) == 0 {
+				if err != nil {
+					t.Errorf("Validate() = %v, want no error", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Validate() = nil, want %q", tt.errs)
+			}
+			for _, want := range tt.errs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Validate() = %v, want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+const (
+	// MirrorAnnotation opts a reference Widget in to ("true") or out of
+	// ("false") mirroring, regardless of the controller's selectors.
+	MirrorAnnotation = "mirror.tutorial.kubebuilder.io/mirror"
+
+	// MirrorFinalizer is added to reference Widgets so that the deletion policy
+	// is applied to their mirrored copy before they are removed.
+	MirrorFinalizer = "mirror.tutorial.kubebuilder.io/finalizer"
+
+	// DeletionPolicyAnnotation overrides the controller wide DeletionPolicy for
+	// a single reference Widget.
+	DeletionPolicyAnnotation = "mirror.tutorial.kubebuilder.io/deletion-policy"
+
+	// DeselectedAnnotation records on a reference Widget that is no longer
+	// selected for mirroring when the release of its mirrored copies first
+	// failed, in RFC 3339 format. The release is retried until the deletion
+	// timeout has passed since then.
+	DeselectedAnnotation = "mirror.tutorial.kubebuilder.io/deselected"
+
+	// RetainedLabel is set on mirrored Widgets that were kept by the Retain
+	// DeletionPolicy after their reference Widget was deleted.
+	RetainedLabel = "mirror.tutorial.kubebuilder.io/retained"
+
+	// ManagedNamespaceLabel is set on namespaces the controller created in a
+	// mirror cluster. Only such namespaces are deleted by the controller.
+	ManagedNamespaceLabel = "mirror.tutorial.kubebuilder.io/managed-namespace"
+
+	// DependentLabel is set on the ConfigMaps and Secrets the controller copied
+	// into a mirror cluster because a mirrored Widget references them. They
+	// are deleted once no Widget in their namespace references them.
+	DependentLabel = "mirror.tutorial.kubebuilder.io/dependent"
+
+	// PausedAnnotation pauses ("true") mirroring of a reference Widget, or of
+	// every reference Widget in a namespace when set on the namespace. Nothing
+	// is written to the mirror clusters on behalf of a paused Widget: when it is
+	// deleted, its finalizer is removed and its mirrored copies are left alone.
+	PausedAnnotation = "mirror.tutorial.kubebuilder.io/paused"
+
+	// DriftPolicyAnnotation overrides the controller wide DriftPolicy for a
+	// single reference Widget.
+	DriftPolicyAnnotation = "mirror.tutorial.kubebuilder.io/drift-policy"
+
+	// ConflictPolicyAnnotation overrides the controller wide ConflictPolicy for
+	// a single reference Widget.
+	ConflictPolicyAnnotation = "mirror.tutorial.kubebuilder.io/conflict-policy"
+
+	// ContentHashAnnotation records on a mirrored object the hash of the
+	// content last written by the controller. A mirrored object whose content
+	// no longer matches the hash was modified in the mirror cluster; one whose
+	// hash matches that of its desired content is not written again.
+	ContentHashAnnotation = "mirror.tutorial.kubebuilder.io/content-hash"
+
+	// SourceClusterAnnotation records on a mirrored Widget the logical cluster
+	// of its reference Widget. It is empty outside of kcp.
+	SourceClusterAnnotation = "mirror.tutorial.kubebuilder.io/source-cluster"
+
+	// SourceNamespaceAnnotation records on a mirrored Widget the namespace of
+	// its reference Widget, which may differ from its own namespace.
+	SourceNamespaceAnnotation = "mirror.tutorial.kubebuilder.io/source-namespace"
+
+	// SourceNameAnnotation records on a mirrored Widget the name of its
+	// reference Widget.
+	SourceNameAnnotation = "mirror.tutorial.kubebuilder.io/source-name"
+
+	// SourceUIDAnnotation records on a mirrored Widget the UID of its reference
+	// Widget. A reference Widget recreated under the same name does not take
+	// over the mirrored Widget of the earlier one.
+	SourceUIDAnnotation = "mirror.tutorial.kubebuilder.io/source-uid"
+
+	// SourceGenerationAnnotation records on a mirrored Widget the generation of
+	// its reference Widget it was last written from.
+	SourceGenerationAnnotation = "mirror.tutorial.kubebuilder.io/source-generation"
+)
+
+const (
+	// ConditionTypeDrifted is True on a reference Widget while its mirrored
+	// Widget differs from it because of changes made in the mirror cluster.
+	ConditionTypeDrifted = "Drifted"
+
+	// ConditionTypePaused is True on a reference Widget while its mirroring is
+	// paused by the PausedAnnotation.
+	ConditionTypePaused = "Paused"
+
+	// ConditionTypeConflicted is True on a reference Widget while fields of its
+	// mirrored Widget are owned by other field managers in the mirror cluster
+	// and the ConflictPolicy is Report.
+	ConditionTypeConflicted = "Conflicted"
+)
+
+// DeletionPolicy describes what happens to a mirrored Widget when its reference
+// Widget is deleted.
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyDelete deletes the mirrored Widget.
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+	// DeletionPolicyOrphan leaves the mirrored Widget untouched.
+	DeletionPolicyOrphan DeletionPolicy = "Orphan"
+	// DeletionPolicyRetain keeps the mirrored Widget and marks it with RetainedLabel.
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+)
+
+// IsValid reports whether p is one of the known deletion policies.
+func (p DeletionPolicy) IsValid() bool {
+	switch p {
+	case DeletionPolicyDelete, DeletionPolicyOrphan, DeletionPolicyRetain:
+		return true
+	}
+	return false
+}
+
+// DriftPolicy describes what happens when a mirrored Widget is modified in the
+// mirror cluster.
+type DriftPolicy string
+
+const (
+	// DriftPolicyRevert overwrites the mirrored Widget with the reference Widget.
+	DriftPolicyRevert DriftPolicy = "Revert"
+	// DriftPolicyAdopt copies the changes made to the mirrored Widget back to
+	// the reference Widget. Widgets mirrored into a target with transforms are
+	// reverted instead.
+	DriftPolicyAdopt DriftPolicy = "Adopt"
+	// DriftPolicyIgnore leaves the mirrored Widget alone for as long as it
+	// differs from the reference Widget.
+	DriftPolicyIgnore DriftPolicy = "Ignore"
+)
+
+// IsValid reports whether p is one of the known drift policies.
+func (p DriftPolicy) IsValid() bool {
+	switch p {
+	case DriftPolicyRevert, DriftPolicyAdopt, DriftPolicyIgnore:
+		return true
+	}
+	return false
+}
+
+// ConflictPolicy describes what happens when fields of a mirrored Widget that
+// the controller applies are owned by other field managers in the mirror
+// cluster.
+type ConflictPolicy string
+
+const (
+	// ConflictPolicyForce takes over the conflicting fields.
+	ConflictPolicyForce ConflictPolicy = "Force"
+	// ConflictPolicyReport leaves the mirrored Widget alone and reports the
+	// conflicting fields.
+	ConflictPolicyReport ConflictPolicy = "Report"
+)
+
+// IsValid reports whether p is one of the known conflict policies.
+func (p ConflictPolicy) IsValid() bool {
+	switch p {
+	case ConflictPolicyForce, ConflictPolicyReport:
+		return true
+	}
+	return false
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -31,16 +32,62 @@ type WidgetSpec struct {
 	// Foo is an example field of Widget. Edit widget_types.go to remove/update
 	Foo   string `json:"foo"`
 	Scott string `json:"scott"`
+
+	// ConfigMapRefs name ConfigMaps in the namespace of the Widget that hold
+	// its configuration. They are mirrored along with the Widget.
+	// +optional
+	ConfigMapRefs []corev1.LocalObjectReference `json:"configMapRefs,omitempty"`
+
+	// SecretRefs name Secrets in the namespace of the Widget that hold its
+	// configuration. They are mirrored along with the Widget.
+	// +optional
+	SecretRefs []corev1.LocalObjectReference `json:"secretRefs,omitempty"`
 }
 
 // WidgetStatus defines the observed state of Widget
 type WidgetStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
+
+	// Conditions represent the latest available observations of the Widget.
+	// Conditions set on a mirrored Widget are copied back to its reference Widget.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Mirrors reports the result of mirroring the Widget into each mirror target.
+	// +optional
+	// +listType=map
+	// +listMapKey=target
+	Mirrors []WidgetMirrorStatus `json:"mirrors,omitempty"`
+}
+
+// WidgetMirrorStatus reports the result of mirroring a Widget into one mirror
+// target.
+type WidgetMirrorStatus struct {
+	// Target is the name of the mirror target.
+	Target string `json:"target"`
+
+	// Synced is true when the Widget was mirrored into the target by the last
+	// reconcile.
+	Synced bool `json:"synced"`
+
+	// ObservedGeneration is the generation of the Widget last mirrored into the
+	// target.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Message describes why the Widget could not be mirrored into the target.
+	// +optional
+	Message string `json:"message,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Paused",type=string,JSONPath=`.status.conditions[?(@.type=="Paused")].status`
+//+kubebuilder:printcolumn:name="Drifted",type=string,JSONPath=`.status.conditions[?(@.type=="Drifted")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // Widget is the Schema for the widgets API
 type Widget struct {
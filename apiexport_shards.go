@@ -0,0 +1,110 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	apisv1alpha1 "github.com/kcp-dev/kcp/pkg/apis/apis/v1alpha1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+
+	"github.com/yourrepo/kb-kcp-tutorial/controllers"
+)
+
+var shardsLog = ctrl.Log.WithName("apiexport-shards")
+
+// errAPIExportDeleted is logged when the APIExport of the shards is deleted.
+var errAPIExportDeleted = errors.New("APIExport was deleted")
+
+// apiExportShards sets the shards of a ShardedCache and a ShardedRESTMapper to
+// the virtual workspaces of an APIExport, as shards are added to and removed
+// from its status.
+type apiExportShards struct {
+	// config reaches the workspace of the APIExport.
+	config *rest.Config
+	name   string
+	shards *controllers.ShardedCache
+	mapper *controllers.ShardedRESTMapper
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. The shards are
+// watched by every replica, as their caches are.
+func (s *apiExportShards) NeedLeaderElection() bool {
+	return false
+}
+
+// Start watches the APIExport until ctx is done.
+func (s *apiExportShards) Start(ctx context.Context) error {
+	scheme := runtime.NewScheme()
+	if err := apisv1alpha1.AddToScheme(scheme); err != nil {
+		return fmt.Errorf("error adding apis.kcp.dev/v1alpha1 to scheme: %w", err)
+	}
+	c, err := cache.New(s.config, cache.Options{
+		Scheme: scheme,
+		SelectorsByObject: cache.SelectorsByObject{
+			&apisv1alpha1.APIExport{}: {Field: fields.OneTermEqualSelector("metadata.name", s.name)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error creating APIExport cache: %w", err)
+	}
+	informer, err := c.GetInformer(ctx, &apisv1alpha1.APIExport{})
+	if err != nil {
+		return fmt.Errorf("error getting APIExport informer: %w", err)
+	}
+	informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    s.update,
+		UpdateFunc: func(_, obj interface{}) { s.update(obj) },
+		DeleteFunc: s.delete,
+	})
+	return c.Start(ctx)
+}
+
+// update sets the shards to the virtual workspaces of the APIExport obj. The
+// last shards are kept while the APIExport lists none, e.g. while its status is
+// being rebuilt.
+func (s *apiExportShards) update(obj interface{}) {
+	apiExport, ok := obj.(*apisv1alpha1.APIExport)
+	if !ok {
+		return
+	}
+	urls, err := virtualWorkspaceURLs(apiExport)
+	if err != nil {
+		shardsLog.Info("APIExport lists no virtual workspaces, keeping the last shards", "apiExport", s.name)
+		return
+	}
+	if err := s.mapper.SetShards(urls); err != nil {
+		shardsLog.Error(err, "unable to set the shards of the REST mapper", "apiExport", s.name, "urls", urls)
+	}
+	if err := s.shards.SetShards(urls); err != nil {
+		shardsLog.Error(err, "unable to set the shards", "apiExport", s.name, "urls", urls)
+	}
+}
+
+// delete keeps the last shards once the APIExport is deleted: its virtual
+// workspaces go away with it, so the shards report errors until the APIExport
+// is recreated or the controller is stopped.
+func (s *apiExportShards) delete(interface{}) {
+	shardsLog.Error(errAPIExportDeleted, "keeping the last shards", "apiExport", s.name)
+}
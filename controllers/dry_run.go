@@ -0,0 +1,214 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/kcp-dev/logicalcluster/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
+)
+
+// Operations counted by dryRunChanges.
+const (
+	dryRunCreate = "create"
+	dryRunUpdate = "update"
+	dryRunDelete = "delete"
+)
+
+// dryRunChanges counts the writes a dry-run target would have received.
+var dryRunChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "mirror_dry_run_changes_total",
+	Help: "Number of changes that would have been written to a mirror target in dry-run mode.",
+}, []string{"target", "kind", "operation"})
+
+func init() {
+	metrics.Registry.MustRegister(dryRunChanges)
+}
+
+// DryRunTarget returns target with its client replaced by one that reads from
+// the target but turns every write into a server-side dry-run request. The
+// change each write would have made is logged as a JSON merge patch, counted in
+// the mirror_dry_run_changes_total metric and recorded through recorder as an
+// event on the reference object the mirrored object was copied from.
+func DryRunTarget(target MirrorTarget, recorder record.EventRecorder) MirrorTarget {
+	target.Client = &dryRunClient{
+		Client:   client.NewDryRunClient(target.Client),
+		target:   target.Name,
+		recorder: recorder,
+	}
+	target.DryRun = true
+	return target
+}
+
+// dryRunClient reports the writes made through the embedded dry-run client.
+type dryRunClient struct {
+	client.Client
+	target   string
+	recorder record.EventRecorder
+}
+
+func (c *dryRunClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if err := c.Client.Create(ctx, obj, opts...); err != nil {
+		return err
+	}
+	c.report(ctx, dryRunCreate, nil, obj)
+	return nil
+}
+
+func (c *dryRunClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	current, err := c.current(ctx, obj)
+	if err != nil {
+		return err
+	}
+	if err := c.Client.Update(ctx, obj, opts...); err != nil {
+		return err
+	}
+	c.report(ctx, dryRunUpdate, current, obj)
+	return nil
+}
+
+func (c *dryRunClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	operation := dryRunUpdate
+	current, err := c.current(ctx, obj)
+	if err != nil {
+		// Server-side apply creates missing objects.
+		if !apierrors.IsNotFound(err) || patch.Type() != types.ApplyPatchType {
+			return err
+		}
+		operation = dryRunCreate
+	}
+	if err := c.Client.Patch(ctx, obj, patch, opts...); err != nil {
+		return err
+	}
+	c.report(ctx, operation, current, obj)
+	return nil
+}
+
+func (c *dryRunClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	if err := c.Client.Delete(ctx, obj, opts...); err != nil {
+		return err
+	}
+	c.report(ctx, dryRunDelete, obj, nil)
+	return nil
+}
+
+// current reads the object that a write of obj would replace.
+func (c *dryRunClient) current(ctx context.Context, obj client.Object) (client.Object, error) {
+	current := obj.DeepCopyObject().(client.Object)
+	if err := c.Client.Get(ctx, client.ObjectKeyFromObject(obj), current); err != nil {
+		return nil, err
+	}
+	return current, nil
+}
+
+// report logs, counts and records the change from before to after, either of
+// which is nil for a creation or deletion.
+func (c *dryRunClient) report(ctx context.Context, operation string, before, after client.Object) {
+	obj := after
+	if obj == nil {
+		obj = before
+	}
+	gvk, err := apiutil.GVKForObject(obj, c.Scheme())
+	if err != nil {
+		gvk = obj.GetObjectKind().GroupVersionKind()
+	}
+	logger := log.FromContext(ctx).WithValues("target", c.target, "kind", gvk.Kind, "mirror", client.ObjectKeyFromObject(obj))
+
+	diff, err := dryRunDiff(before, after)
+	if err != nil {
+		logger.Error(err, "unable to compute dry-run diff", "operation", operation)
+	}
+	if operation == dryRunUpdate && diff == "{}" {
+		// The server normalised the write away; nothing would change.
+		return
+	}
+	logger.Info("Dry run", "operation", operation, "diff", diff)
+	dryRunChanges.WithLabelValues(c.target, gvk.Kind, operation).Inc()
+
+	// Record the event on the reference object named by the origin annotations.
+	annotations := obj.GetAnnotations()
+	name, ok := annotations[tutorialkubebuilderiov1alpha1.SourceNameAnnotation]
+	if !ok || c.recorder == nil {
+		return
+	}
+	reference := &metav1.PartialObjectMetadata{}
+	reference.SetGroupVersionKind(gvk)
+	reference.SetNamespace(annotations[tutorialkubebuilderiov1alpha1.SourceNamespaceAnnotation])
+	reference.SetName(name)
+	reference.SetUID(types.UID(annotations[tutorialkubebuilderiov1alpha1.SourceUIDAnnotation]))
+	if cluster, ok := annotations[tutorialkubebuilderiov1alpha1.SourceClusterAnnotation]; ok {
+		reference.SetAnnotations(map[string]string{logicalcluster.AnnotationKey: cluster})
+	}
+	c.recorder.Eventf(reference, corev1.EventTypeNormal, "MirrorDryRun",
+		"Dry run: would %s mirrored %s %s in target %s: %s", operation, gvk.Kind, client.ObjectKeyFromObject(obj), c.target, diff)
+}
+
+// serverFields are metadata fields set by the API server. They are left out of
+// dry-run diffs.
+var serverFields = []string{"creationTimestamp", "generation", "managedFields", "resourceVersion", "uid"}
+
+// dryRunDiff returns the JSON merge patch from before to after, leaving out
+// status and the metadata set by the API server. A deletion is the null patch.
+func dryRunDiff(before, after client.Object) (string, error) {
+	if after == nil {
+		return "null", nil
+	}
+	original, err := diffJSON(before)
+	if err != nil {
+		return "", err
+	}
+	modified, err := diffJSON(after)
+	if err != nil {
+		return "", err
+	}
+	patch, err := jsonpatch.CreateMergePatch(original, modified)
+	if err != nil {
+		return "", err
+	}
+	return string(patch), nil
+}
+
+func diffJSON(obj client.Object) ([]byte, error) {
+	if obj == nil {
+		return []byte("{}"), nil
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	delete(content, "status")
+	for _, field := range serverFields {
+		unstructured.RemoveNestedField(content, "metadata", field)
+	}
+	return json.Marshal(content)
+}
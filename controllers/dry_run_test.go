@@ -0,0 +1,85 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
+)
+
+var _ = Describe("DryRunTarget", func() {
+	var (
+		ctx      context.Context
+		c        client.Client
+		recorder *record.FakeRecorder
+		target   MirrorTarget
+		key      client.ObjectKey
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		s := runtime.NewScheme()
+		Expect(tutorialkubebuilderiov1alpha1.AddToScheme(s)).To(Succeed())
+		mirror := &tutorialkubebuilderiov1alpha1.Widget{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "widget",
+				Namespace: "default",
+				Annotations: map[string]string{
+					tutorialkubebuilderiov1alpha1.SourceNamespaceAnnotation: "default",
+					tutorialkubebuilderiov1alpha1.SourceNameAnnotation:      "widget",
+				},
+			},
+			Spec: tutorialkubebuilderiov1alpha1.WidgetSpec{Foo: "old"},
+		}
+		key = client.ObjectKeyFromObject(mirror)
+		c = fake.NewClientBuilder().WithScheme(s).WithObjects(mirror).Build()
+		recorder = record.NewFakeRecorder(10)
+		target = DryRunTarget(MirrorTarget{Name: "east", Client: c}, recorder)
+	})
+
+	It("reports updates without writing them", func() {
+		var mirror tutorialkubebuilderiov1alpha1.Widget
+		Expect(target.Client.Get(ctx, key, &mirror)).To(Succeed())
+		mirror.Spec.Foo = "new"
+		Expect(target.Client.Update(ctx, &mirror)).To(Succeed())
+
+		Expect(c.Get(ctx, key, &mirror)).To(Succeed())
+		Expect(mirror.Spec.Foo).To(Equal("old"))
+		Expect(recorder.Events).To(Receive(And(
+			ContainSubstring("MirrorDryRun"),
+			ContainSubstring(`{"spec":{"foo":"new"}}`),
+		)))
+	})
+
+	It("reports deletions without deleting", func() {
+		var mirror tutorialkubebuilderiov1alpha1.Widget
+		Expect(target.Client.Get(ctx, key, &mirror)).To(Succeed())
+		Expect(target.Client.Delete(ctx, &mirror)).To(Succeed())
+
+		Expect(c.Get(ctx, key, &mirror)).To(Succeed())
+		Expect(recorder.Events).To(Receive(ContainSubstring("would delete")))
+	})
+})
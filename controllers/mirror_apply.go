@@ -0,0 +1,158 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
+)
+
+// MirrorFieldManager is the field manager of everything the controller writes
+// to mirror clusters. Its entries in the managedFields of a mirrored object
+// show which fields the controller owns.
+const MirrorFieldManager = "mirror-controller"
+
+// Results counted by mirrorWrites.
+const (
+	writeResultPerformed = "performed"
+	writeResultSkipped   = "skipped"
+)
+
+// mirrorWrites counts the writes of mirrored objects to mirror targets.
+var mirrorWrites = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "mirror_writes_total",
+	Help: "Number of writes of mirrored objects to mirror targets, by whether they were performed or skipped because the mirrored object was up to date.",
+}, []string{"target", "kind", "result"})
+
+func init() {
+	metrics.Registry.MustRegister(mirrorWrites)
+}
+
+// hashUpToDate reports whether mirror was written from desired and not
+// modified since: the ContentHashAnnotation recorded on mirror matches both
+// that of desired and hash, which computes the hash of the current content of
+// mirror. Comparing hashes keeps steady-state resyncs down to cache reads.
+func hashUpToDate(desired, mirror client.Object, hash func() string) bool {
+	recorded, ok := mirror.GetAnnotations()[tutorialkubebuilderiov1alpha1.ContentHashAnnotation]
+	return ok && recorded == desired.GetAnnotations()[tutorialkubebuilderiov1alpha1.ContentHashAnnotation] && recorded == hash()
+}
+
+// applyMirror server-side applies desired through c as MirrorFieldManager, so
+// that fields set by others in the mirror cluster are kept. If other field
+// managers own some of the applied fields, the conflicting fields are returned
+// and desired is only applied if force is set, taking the fields over.
+func applyMirror(ctx context.Context, c client.Client, desired client.Object, force bool) ([]string, error) {
+	err := c.Patch(ctx, desired, client.Apply, client.FieldOwner(MirrorFieldManager))
+	if err == nil {
+		return nil, nil
+	}
+	conflicts := applyConflicts(err)
+	if len(conflicts) == 0 || !force {
+		if len(conflicts) > 0 {
+			err = nil
+		}
+		return conflicts, err
+	}
+	return conflicts, c.Patch(ctx, desired, client.Apply, client.FieldOwner(MirrorFieldManager), client.ForceOwnership)
+}
+
+// applyConflicts returns the conflicting fields reported by a failed apply,
+// each with the field manager owning it.
+func applyConflicts(err error) []string {
+	var status apierrors.APIStatus
+	if !errors.As(err, &status) || status.Status().Reason != metav1.StatusReasonConflict || status.Status().Details == nil {
+		return nil
+	}
+	var conflicts []string
+	for _, cause := range status.Status().Details.Causes {
+		if cause.Type == metav1.CauseTypeFieldManagerConflict {
+			conflicts = append(conflicts, fmt.Sprintf("%s (%s)", cause.Field, cause.Message))
+		}
+	}
+	return conflicts
+}
+
+// appliedMetadata returns the labels and annotations of obj applied by
+// MirrorFieldManager. Labels and annotations set by other field managers do
+// not belong to the mirrored content. Objects the controller has not applied
+// yet, such as desired mirrored objects, are returned whole.
+func appliedMetadata(obj client.Object) (map[string]string, map[string]string) {
+	labels, annotations := obj.GetLabels(), obj.GetAnnotations()
+	for _, entry := range obj.GetManagedFields() {
+		if entry.Manager != MirrorFieldManager || entry.Operation != metav1.ManagedFieldsOperationApply || entry.FieldsV1 == nil {
+			continue
+		}
+		var fields struct {
+			Metadata struct {
+				Labels      map[string]json.RawMessage `json:"f:labels"`
+				Annotations map[string]json.RawMessage `json:"f:annotations"`
+			} `json:"f:metadata"`
+		}
+		if err := json.Unmarshal(entry.FieldsV1.Raw, &fields); err != nil {
+			break
+		}
+		return ownedKeys(labels, fields.Metadata.Labels), ownedKeys(annotations, fields.Metadata.Annotations)
+	}
+	return labels, annotations
+}
+
+// ownedKeys returns the entries of m listed in the managed fields owned.
+func ownedKeys(m map[string]string, owned map[string]json.RawMessage) map[string]string {
+	var out map[string]string
+	for k, v := range m {
+		if _, ok := owned["f:"+k]; !ok {
+			continue
+		}
+		if out == nil {
+			out = map[string]string{}
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// conflictPolicy returns the conflict policy for reference, honouring its
+// ConflictPolicyAnnotation over policy, which defaults to ConflictPolicyForce.
+func conflictPolicy(reference client.Object, policy tutorialkubebuilderiov1alpha1.ConflictPolicy) tutorialkubebuilderiov1alpha1.ConflictPolicy {
+	if p := tutorialkubebuilderiov1alpha1.ConflictPolicy(reference.GetAnnotations()[tutorialkubebuilderiov1alpha1.ConflictPolicyAnnotation]); p.IsValid() {
+		return p
+	}
+	if policy.IsValid() {
+		return policy
+	}
+	return tutorialkubebuilderiov1alpha1.ConflictPolicyForce
+}
+
+// formatConflicts joins the conflicting fields of each target for messages.
+func formatConflicts(conflicts map[string][]string, targets []string) string {
+	parts := make([]string, 0, len(targets))
+	for _, target := range targets {
+		parts = append(parts, fmt.Sprintf("%s: %s", target, strings.Join(conflicts[target], ", ")))
+	}
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
+)
+
+// conflictingClient fails applies that do not force ownership with a conflict
+// on .spec.foo.
+type conflictingClient struct {
+	client.Client
+	applies int
+	forced  bool
+}
+
+func (c *conflictingClient) Patch(_ context.Context, _ client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	c.applies++
+	options := &client.PatchOptions{}
+	options.ApplyOptions(opts)
+	if options.Force != nil && *options.Force {
+		c.forced = true
+		return nil
+	}
+	return &apierrors.StatusError{ErrStatus: metav1.Status{
+		Status: metav1.StatusFailure,
+		Code:   http.StatusConflict,
+		Reason: metav1.StatusReasonConflict,
+		Details: &metav1.StatusDetails{Causes: []metav1.StatusCause{{
+			Type:    metav1.CauseTypeFieldManagerConflict,
+			Message: `conflict with "kubectl-edit"`,
+			Field:   ".spec.foo",
+		}}},
+	}}
+}
+
+var _ = Describe("Server-side apply", func() {
+	var c *conflictingClient
+
+	BeforeEach(func() {
+		c = &conflictingClient{}
+	})
+
+	It("reports conflicts without forcing", func() {
+		conflicts, err := applyMirror(context.Background(), c, &tutorialkubebuilderiov1alpha1.Widget{}, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conflicts).To(Equal([]string{`.spec.foo (conflict with "kubectl-edit")`}))
+		Expect(c.forced).To(BeFalse())
+	})
+
+	It("takes over conflicting fields when forcing", func() {
+		conflicts, err := applyMirror(context.Background(), c, &tutorialkubebuilderiov1alpha1.Widget{}, true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conflicts).To(HaveLen(1))
+		Expect(c.applies).To(Equal(2))
+		Expect(c.forced).To(BeTrue())
+	})
+
+	It("only hashes the labels and annotations applied by the controller", func() {
+		widget := &tutorialkubebuilderiov1alpha1.Widget{ObjectMeta: metav1.ObjectMeta{
+			Labels:      map[string]string{"app": "widget"},
+			Annotations: map[string]string{"note": "mirrored"},
+		}}
+		hash := contentHash(widget)
+
+		widget.Labels["team"] = "other"
+		widget.ManagedFields = []metav1.ManagedFieldsEntry{{
+			Manager:   MirrorFieldManager,
+			Operation: metav1.ManagedFieldsOperationApply,
+			FieldsV1: &metav1.FieldsV1{Raw: []byte(
+				`{"f:metadata":{"f:labels":{"f:app":{}},"f:annotations":{"f:note":{}}},"f:spec":{"f:foo":{}}}`)},
+		}}
+		Expect(contentHash(widget)).To(Equal(hash))
+
+		widget.Labels["app"] = "changed"
+		Expect(contentHash(widget)).NotTo(Equal(hash))
+	})
+
+	It("skips writes of mirrored Widgets that are up to date", func() {
+		target := MirrorTarget{Name: "skip", Client: c}
+		reference := &tutorialkubebuilderiov1alpha1.Widget{
+			ObjectMeta: metav1.ObjectMeta{Name: "widget", Namespace: "default", Labels: map[string]string{"app": "widget"}},
+			Spec:       tutorialkubebuilderiov1alpha1.WidgetSpec{Foo: "bar"},
+		}
+		key := client.ObjectKeyFromObject(reference)
+		desired, err := desiredMirror(reference, target, key)
+		Expect(err).NotTo(HaveOccurred())
+		mirror := desired.DeepCopy()
+
+		_, result, _, err := writeMirror(context.Background(), target, desired, mirror, true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(controllerutil.OperationResultNone))
+		Expect(c.applies).To(BeZero())
+		Expect(testutil.ToFloat64(mirrorWrites.WithLabelValues("skip", "Widget", writeResultSkipped))).To(Equal(1.0))
+
+		mirror.Spec.Foo = "changed"
+		_, result, _, err = writeMirror(context.Background(), target, desired, mirror, true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(controllerutil.OperationResultUpdated))
+		Expect(testutil.ToFloat64(mirrorWrites.WithLabelValues("skip", "Widget", writeResultPerformed))).To(Equal(1.0))
+	})
+
+	It("reports conflicts in the Conflicted condition", func() {
+		widget := &tutorialkubebuilderiov1alpha1.Widget{}
+		conflicts := map[string][]string{"east": {".spec.foo"}}
+		setConflictedCondition(widget, tutorialkubebuilderiov1alpha1.ConflictPolicyReport, conflicts, []string{"east"})
+		Expect(widget.Status.Conditions).To(ContainElement(And(
+			HaveField("Type", tutorialkubebuilderiov1alpha1.ConditionTypeConflicted),
+			HaveField("Status", metav1.ConditionTrue),
+			HaveField("Message", ContainSubstring("east: .spec.foo")),
+		)))
+
+		setConflictedCondition(widget, tutorialkubebuilderiov1alpha1.ConflictPolicyReport, nil, nil)
+		Expect(widget.Status.Conditions).To(ContainElement(HaveField("Status", metav1.ConditionFalse)))
+	})
+})
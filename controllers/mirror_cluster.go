@@ -0,0 +1,458 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/cluster"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// Defaults for a MirrorCluster.
+const (
+	// DefaultSyncTimeout bounds how long a MirrorCluster waits for its cache to
+	// sync before it considers the cluster unreachable.
+	DefaultSyncTimeout = 2 * time.Minute
+
+	// DefaultProbeInterval is the time between reachability probes.
+	DefaultProbeInterval = 10 * time.Second
+
+	// probeTimeout bounds a single reachability probe.
+	probeTimeout = 5 * time.Second
+)
+
+// errUnreachable is returned for mirror clusters that cannot be reached.
+var errUnreachable = errors.New("mirror cluster is unreachable")
+
+// mirrorReachable reports the reachability of each mirror cluster.
+var mirrorReachable = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "mirror_cluster_reachable",
+	Help: "Whether a mirror cluster can be reached (1) or not (0).",
+}, []string{"cluster"})
+
+func init() {
+	metrics.Registry.MustRegister(mirrorReachable)
+}
+
+// MirrorCluster is a connection to a mirror cluster described by a kubeconfig
+// file.
+//
+// The connection is probed periodically. The manager starts even while the
+// mirror cluster is down: watches registered through Watch are only handed to
+// their controllers once the cluster was reached and its cache synced, and
+// Reachable reports false until then and whenever a probe fails afterwards.
+//
+// When the kubeconfig file changes, e.g. because the credentials in it were
+// rotated or the Secret it is mounted from was updated, the connection and its
+// cache are rebuilt, the watches are re-established on the new cache and the
+// client returned by GetClient switches over. Controllers keep their queues
+// across a reload. If the new kubeconfig does not work while the previous one
+// still does, the previous connection is kept.
+type MirrorCluster struct {
+	name    string
+	path    string
+	options []cluster.Option
+	client  *reloadingClient
+
+	// SyncTimeout defaults to DefaultSyncTimeout.
+	SyncTimeout time.Duration
+
+	// ProbeInterval defaults to DefaultProbeInterval.
+	ProbeInterval time.Duration
+
+	mu         sync.Mutex
+	ctx        context.Context
+	kubeconfig []byte
+	conn       *mirrorConnection
+	watches    []mirrorWatch
+	errs       chan clusterError
+
+	// reachable has its own lock so that Reachable does not wait for a probe
+	// or reload in progress.
+	reachableMu sync.RWMutex
+	reachable   bool
+}
+
+// mirrorConnection is one connection of a MirrorCluster.
+type mirrorConnection struct {
+	cluster cluster.Cluster
+	cancel  context.CancelFunc
+	// watching is the number of watches of the MirrorCluster handed to their
+	// controllers on this connection.
+	watching int
+	// synced is set once the watched kinds were synced on this connection.
+	synced bool
+}
+
+// mirrorWatch is a watch registered through MirrorCluster.Watch.
+type mirrorWatch struct {
+	controller controller.Controller
+	obj        client.Object
+	handler    handler.EventHandler
+	predicates []predicate.Predicate
+}
+
+// clusterError is returned by the Start method of a cluster.
+type clusterError struct {
+	cluster cluster.Cluster
+	err     error
+}
+
+// NewMirrorCluster prepares a connection to the cluster described by the
+// kubeconfig file at path, without contacting the cluster. name identifies the
+// cluster in logs and metrics.
+func NewMirrorCluster(name, path string, opts ...cluster.Option) (*MirrorCluster, error) {
+	c := &MirrorCluster{
+		name: name,
+		path: path,
+		// Discover REST mappings lazily so that an unreachable cluster does not
+		// fail the setup.
+		options: append([]cluster.Option{func(o *cluster.Options) {
+			o.MapperProvider = func(cfg *rest.Config) (meta.RESTMapper, error) {
+				return apiutil.NewDynamicRESTMapper(cfg, apiutil.WithLazyDiscovery)
+			}
+		}}, opts...),
+		errs: make(chan clusterError, 1),
+	}
+	kubeconfig, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading kubeconfig: %w", err)
+	}
+	cl, err := c.newCluster(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	c.kubeconfig = kubeconfig
+	c.conn = &mirrorConnection{cluster: cl}
+	c.client = &reloadingClient{client: cl.GetClient()}
+	mirrorReachable.WithLabelValues(name).Set(0)
+	return c, nil
+}
+
+func (c *MirrorCluster) newCluster(kubeconfig []byte) (cluster.Cluster, error) {
+	cfg, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("error loading kubeconfig %q: %w", c.path, err)
+	}
+	return cluster.New(cfg, c.options...)
+}
+
+// GetClient returns a client that reads from the cache of the current
+// connection and writes through it. Reads of kinds that were not synced block
+// until they are, so callers should check Reachable first.
+func (c *MirrorCluster) GetClient() client.Client {
+	return c.client
+}
+
+// Reachable reports whether the mirror cluster answered the last probe and its
+// cache is synced.
+func (c *MirrorCluster) Reachable() bool {
+	c.reachableMu.RLock()
+	defer c.reachableMu.RUnlock()
+	return c.reachable
+}
+
+// ReadyzCheck fails while the mirror cluster is unreachable.
+func (c *MirrorCluster) ReadyzCheck(_ *http.Request) error {
+	if !c.Reachable() {
+		return fmt.Errorf("%s: %w", c.name, errUnreachable)
+	}
+	return nil
+}
+
+// Watch makes ctrl watch objects of the kind of obj in the mirror cluster, on
+// the current connection once it is synced and on every reloaded one.
+func (c *MirrorCluster) Watch(ctrl controller.Controller, obj client.Object, h handler.EventHandler, predicates ...predicate.Predicate) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.watches = append(c.watches, mirrorWatch{controller: ctrl, obj: obj, handler: h, predicates: predicates})
+	if !c.conn.synced {
+		// Handed over by the next successful probe.
+		return nil
+	}
+	return c.startWatches(c.ctx, c.conn)
+}
+
+// NeedLeaderElection lets the connection be established before the controllers
+// using it are elected.
+func (c *MirrorCluster) NeedLeaderElection() bool {
+	return false
+}
+
+// Start runs the connection, probes it and reloads it whenever the kubeconfig
+// file changes, until ctx is done.
+func (c *MirrorCluster) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithValues("mirrorCluster", c.name, "kubeconfig", c.path)
+	ctx = log.IntoContext(ctx, logger)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	// Watch the directory: kubeconfigs mounted from Secrets are replaced by
+	// swapping a symlink, and many tools replace files by renaming.
+	if err := watcher.Add(filepath.Dir(c.path)); err != nil {
+		return fmt.Errorf("error watching kubeconfig: %w", err)
+	}
+
+	c.mu.Lock()
+	c.ctx = ctx
+	c.conn.cancel = c.run(c.conn.cluster)
+	c.mu.Unlock()
+
+	interval := c.ProbeInterval
+	if interval == 0 {
+		interval = DefaultProbeInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	c.probe(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e := <-c.errs:
+			c.mu.Lock()
+			current := e.cluster == c.conn.cluster
+			c.mu.Unlock()
+			if current && ctx.Err() == nil {
+				return fmt.Errorf("mirror cluster %s stopped: %w", c.name, e.err)
+			}
+		case <-ticker.C:
+			c.probe(ctx)
+		case <-watcher.Events:
+			// Any change in the directory may have replaced the file.
+			reloaded, err := c.reload(ctx)
+			if err != nil {
+				logger.Error(err, "unable to reload mirror cluster, keeping the previous connection")
+			} else if reloaded {
+				logger.Info("Reloaded mirror cluster")
+			}
+		case err := <-watcher.Errors:
+			logger.Error(err, "error watching kubeconfig")
+		}
+	}
+}
+
+// run starts cl until the returned function is called.
+func (c *MirrorCluster) run(cl cluster.Cluster) context.CancelFunc {
+	ctx, cancel := context.WithCancel(c.ctx)
+	go func() {
+		if err := cl.Start(ctx); err != nil {
+			select {
+			case c.errs <- clusterError{cluster: cl, err: err}:
+			default:
+			}
+		}
+	}()
+	return cancel
+}
+
+// probe checks that the current connection reaches the cluster, syncing it
+// and handing over the watches the first time it does.
+func (c *MirrorCluster) probe(ctx context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := ping(ctx, c.conn.cluster)
+	if err == nil {
+		err = c.startWatches(ctx, c.conn)
+	}
+	c.setReachable(ctx, err)
+}
+
+// setReachable records the outcome of a probe.
+func (c *MirrorCluster) setReachable(ctx context.Context, err error) {
+	c.reachableMu.Lock()
+	defer c.reachableMu.Unlock()
+	reachable := err == nil
+	if reachable != c.reachable {
+		if reachable {
+			log.FromContext(ctx).Info("Mirror cluster is reachable")
+		} else {
+			log.FromContext(ctx).Error(err, "mirror cluster is unreachable")
+		}
+	}
+	c.reachable = reachable
+	value := 0.0
+	if reachable {
+		value = 1
+	}
+	mirrorReachable.WithLabelValues(c.name).Set(value)
+}
+
+// ping asks the API server of cl for its version.
+func ping(ctx context.Context, cl cluster.Cluster) error {
+	cfg := rest.CopyConfig(cl.GetConfig())
+	cfg.Timeout = probeTimeout
+	d, err := discovery.NewDiscoveryClientForConfig(cfg)
+	if err != nil {
+		return err
+	}
+	return d.RESTClient().Get().AbsPath("/version").Do(ctx).Error()
+}
+
+// startWatches syncs the watched kinds on conn and hands the watches that were
+// not yet handed over on conn to their controllers.
+func (c *MirrorCluster) startWatches(ctx context.Context, conn *mirrorConnection) error {
+	timeout := c.SyncTimeout
+	if timeout == 0 {
+		timeout = DefaultSyncTimeout
+	}
+	syncCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	pending := c.watches[conn.watching:]
+	for _, w := range pending {
+		// Create the informers up front so that the cache sync covers them.
+		if _, err := conn.cluster.GetCache().GetInformer(syncCtx, w.obj); err != nil {
+			return err
+		}
+	}
+	if !conn.cluster.GetCache().WaitForCacheSync(syncCtx) {
+		return fmt.Errorf("timed out waiting for the cache to sync")
+	}
+	conn.synced = true
+	for _, w := range pending {
+		if err := w.controller.Watch(source.NewKindWithCache(w.obj, conn.cluster.GetCache()), w.handler, w.predicates...); err != nil {
+			return err
+		}
+		conn.watching++
+	}
+	return nil
+}
+
+// reload rebuilds the connection if the kubeconfig file changed, and reports
+// whether it did.
+func (c *MirrorCluster) reload(ctx context.Context) (bool, error) {
+	kubeconfig, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		// The file may be in the middle of being replaced; the replacement
+		// triggers another reload.
+		return false, fmt.Errorf("error reading kubeconfig: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if bytes.Equal(kubeconfig, c.kubeconfig) {
+		return false, nil
+	}
+	cl, err := c.newCluster(kubeconfig)
+	if err != nil {
+		return false, err
+	}
+	conn := &mirrorConnection{cluster: cl, cancel: c.run(cl)}
+	err = ping(ctx, cl)
+	if err == nil {
+		err = c.startWatches(ctx, conn)
+	}
+	if err != nil && c.Reachable() {
+		// The watches handed over so far stop with the connection.
+		conn.cancel()
+		return false, err
+	}
+
+	previous := c.conn
+	c.kubeconfig, c.conn = kubeconfig, conn
+	c.client.set(cl.GetClient())
+	previous.cancel()
+	c.setReachable(ctx, err)
+	return true, nil
+}
+
+// reloadingClient delegates to the client of the current connection of a
+// MirrorCluster.
+type reloadingClient struct {
+	mu     sync.RWMutex
+	client client.Client
+}
+
+func (c *reloadingClient) get() client.Client {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.client
+}
+
+func (c *reloadingClient) set(cl client.Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.client = cl
+}
+
+func (c *reloadingClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	return c.get().Get(ctx, key, obj)
+}
+
+func (c *reloadingClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	return c.get().List(ctx, list, opts...)
+}
+
+func (c *reloadingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	return c.get().Create(ctx, obj, opts...)
+}
+
+func (c *reloadingClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	return c.get().Delete(ctx, obj, opts...)
+}
+
+func (c *reloadingClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	return c.get().Update(ctx, obj, opts...)
+}
+
+func (c *reloadingClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	return c.get().Patch(ctx, obj, patch, opts...)
+}
+
+func (c *reloadingClient) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	return c.get().DeleteAllOf(ctx, obj, opts...)
+}
+
+func (c *reloadingClient) Status() client.StatusWriter {
+	return c.get().Status()
+}
+
+func (c *reloadingClient) Scheme() *runtime.Scheme {
+	return c.get().Scheme()
+}
+
+func (c *reloadingClient) RESTMapper() meta.RESTMapper {
+	return c.get().RESTMapper()
+}
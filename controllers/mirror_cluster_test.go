@@ -0,0 +1,87 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: mirror
+  cluster:
+    server: https://127.0.0.1:1
+contexts:
+- name: mirror
+  context:
+    cluster: mirror
+    user: mirror
+current-context: mirror
+users:
+- name: mirror
+  user:
+    token: secret
+`
+
+var _ = Describe("MirrorCluster", func() {
+	var (
+		dir    string
+		path   string
+		mirror *MirrorCluster
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "mirror-cluster")
+		Expect(err).NotTo(HaveOccurred())
+		path = filepath.Join(dir, "kubeconfig")
+		Expect(ioutil.WriteFile(path, []byte(testKubeconfig), 0600)).To(Succeed())
+
+		mirror, err = NewMirrorCluster("east", path)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("is unreachable until a probe succeeds", func() {
+		Expect(mirror.Reachable()).To(BeFalse())
+		mirror.probe(context.Background())
+		Expect(mirror.Reachable()).To(BeFalse())
+		Expect(mirror.ReadyzCheck(nil)).To(MatchError(errUnreachable))
+	})
+
+	It("does not reload an unchanged kubeconfig", func() {
+		Expect(mirror.reload(context.Background())).To(BeFalse())
+	})
+
+	It("keeps the previous connection when the kubeconfig is invalid", func() {
+		previous := mirror.client.get()
+		Expect(ioutil.WriteFile(path, []byte("clusters: ["), 0600)).To(Succeed())
+		_, err := mirror.reload(context.Background())
+		Expect(err).To(HaveOccurred())
+		Expect(mirror.client.get()).To(BeIdenticalTo(previous))
+	})
+})
@@ -0,0 +1,343 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kcp-dev/logicalcluster/v2"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
+)
+
+// MirrorReconciler mirrors objects of any kind, handled as unstructured
+// objects, from the reference cluster into the mirror targets. Unlike the
+// WidgetReconciler it does not know the schema of the kind: every top-level
+// field other than the object metadata and status is mirrored, and the outcome
+// for each target is reported in events rather than in the object status.
+type MirrorReconciler struct {
+	// Client reads objects from the reference cluster.
+	client.Client
+
+	// GVK is the kind of the mirrored objects.
+	GVK schema.GroupVersionKind
+
+	// Targets are the clusters reference objects are copied into.
+	Targets []MirrorTarget
+
+	// Recorder records events on reference objects.
+	Recorder record.EventRecorder
+
+	// DeletionPolicy, DeletionTimeout, Filter, DriftPolicy, ConflictPolicy,
+	// Router and MaxConcurrentReconciles behave as they do for the
+	// WidgetReconciler.
+	DeletionPolicy          tutorialkubebuilderiov1alpha1.DeletionPolicy
+	DeletionTimeout         time.Duration
+	Filter                  MirrorFilter
+	DriftPolicy             tutorialkubebuilderiov1alpha1.DriftPolicy
+	ConflictPolicy          tutorialkubebuilderiov1alpha1.ConflictPolicy
+	Router                  *MirrorRouter
+	MaxConcurrentReconciles int
+}
+
+//+kubebuilder:rbac:groups="",resources=configmaps;secrets,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile copies the reference object named by req into every mirror target,
+// following the same rules as the WidgetReconciler.
+func (r *MirrorReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx).WithValues("clusterName", req.ClusterName, "kind", r.GVK.Kind)
+	logger.V(1).Info("Starting reconcile")
+
+	// The mirror cluster is not logical cluster aware, so keep a context without
+	// the logical cluster for the mirror client.
+	mirrorCtx := ctx
+	ctx = logicalcluster.WithCluster(ctx, logicalcluster.New(req.ClusterName))
+
+	reference := r.newObject()
+	if err := r.Get(ctx, req.NamespacedName, reference); err != nil {
+		if apierrors.IsNotFound(err) {
+			logger.V(1).Info("Object not found in reference cluster")
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	by, err := pausedBy(ctx, r.Client, reference)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if !reference.GetDeletionTimestamp().IsZero() {
+		if by != "" {
+			return r.lifecycle().abandon(ctx, reference, by)
+		}
+		return r.lifecycle().finalize(ctx, mirrorCtx, reference)
+	}
+
+	if by != "" {
+		logger.V(1).Info("Mirroring is paused", "by", by)
+		return ctrl.Result{}, nil
+	}
+
+	if !r.Filter.Selected(reference) {
+		return r.lifecycle().unmirror(ctx, mirrorCtx, reference)
+	}
+
+	// Without targets there is nothing to mirror, but a reference object
+	// tracked before the targets were removed must still be released, so
+	// this is only checked once deletion and deselection are handled.
+	if len(r.Targets) == 0 {
+		logger.V(1).Info("Completed reconcile")
+		return ctrl.Result{}, nil
+	}
+
+	if err := r.lifecycle().track(ctx, reference); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	targets, unknown, err := r.Router.Route(ctx, reference, r.Targets)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	for _, name := range unknown {
+		logger.Info("Workspace label names an unknown mirror target", "target", name)
+		r.Recorder.Eventf(reference, corev1.EventTypeWarning, "UnknownMirrorTarget",
+			"Unknown mirror target %s named by the %s label of the workspace", name, r.Router.Label)
+	}
+
+	var errs []error
+	unreachable := false
+	for _, target := range targets {
+		if !target.reachable() {
+			logger.V(1).Info("Mirror cluster is unreachable, skipping target", "target", target.Name)
+			unreachable = true
+			continue
+		}
+		result, err := r.reconcileTarget(ctx, mirrorCtx, reference, target)
+		if err != nil {
+			logger.Error(err, "unable to mirror object", "target", target.Name)
+			r.Recorder.Eventf(reference, corev1.EventTypeWarning, "MirrorFailed",
+				"Unable to mirror %s into target %s: %v", r.GVK.Kind, target.Name, err)
+			errs = append(errs, fmt.Errorf("target %s: %w", target.Name, err))
+			continue
+		}
+		logger.V(1).Info("Mirrored object", "target", target.Name, "mirror", result)
+	}
+
+	if err := kerrors.NewAggregate(errs); err != nil {
+		return ctrl.Result{}, err
+	}
+	if unreachable {
+		logger.V(1).Info("Completed reconcile, retrying unreachable targets later")
+		return ctrl.Result{RequeueAfter: UnreachableRequeueAfter}, nil
+	}
+	logger.V(1).Info("Completed reconcile")
+	return ctrl.Result{RequeueAfter: r.Router.RequeueAfter()}, nil
+}
+
+// reconcileTarget mirrors reference into target.
+func (r *MirrorReconciler) reconcileTarget(ctx, mirrorCtx context.Context, reference *unstructured.Unstructured, target MirrorTarget) (controllerutil.OperationResult, error) {
+	key, err := mirrorKey(target, reference)
+	if err != nil {
+		return controllerutil.OperationResultNone, err
+	}
+	mirror := r.newObject()
+	if err := target.Client.Get(mirrorCtx, key, mirror); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return controllerutil.OperationResultNone, err
+		}
+		mirror = nil
+	}
+	if mirror != nil && isDependent(mirror) {
+		// Copied along with a Widget referencing it, which owns it until no
+		// Widget does. It is mirrored again once it has been pruned.
+		log.FromContext(ctx).V(1).Info("Mirror is a dependent of a Widget, leaving it alone", "target", target.Name, "mirror", key)
+		return controllerutil.OperationResultNone, nil
+	}
+	if mirror != nil {
+		if err := checkOrigin(mirror, reference); err != nil {
+			return controllerutil.OperationResultNone, err
+		}
+	}
+
+	if mirror != nil && unstructuredDrifted(mirror) {
+		write, err := r.handleDrift(ctx, reference, mirror, target)
+		if err != nil || !write {
+			return controllerutil.OperationResultNone, err
+		}
+	}
+
+	desired, err := r.desiredMirror(reference, target, key)
+	if err != nil {
+		return controllerutil.OperationResultNone, err
+	}
+	if mirror == nil {
+		if err := ensureNamespace(mirrorCtx, target, key.Namespace); err != nil {
+			return controllerutil.OperationResultNone, err
+		}
+	}
+	policy := conflictPolicy(reference, r.ConflictPolicy)
+	result, conflicts, err := writeUnstructuredMirror(mirrorCtx, target, desired, mirror, policy == tutorialkubebuilderiov1alpha1.ConflictPolicyForce)
+	if len(conflicts) > 0 {
+		if policy == tutorialkubebuilderiov1alpha1.ConflictPolicyForce {
+			r.Recorder.Eventf(reference, corev1.EventTypeNormal, "MirrorConflictForced",
+				"Took over fields of mirrored %s in target %s owned by other field managers: %s", r.GVK.Kind, target.Name, strings.Join(conflicts, ", "))
+		} else {
+			r.Recorder.Eventf(reference, corev1.EventTypeWarning, "MirrorConflict",
+				"Fields of mirrored %s in target %s are owned by other field managers: %s", r.GVK.Kind, target.Name, strings.Join(conflicts, ", "))
+		}
+	}
+	return result, err
+}
+
+// desiredMirror returns the copy of reference that should exist in target under
+// key, rewritten by the transforms of target and stamped with the origin of
+// reference and the hash of its content.
+func (r *MirrorReconciler) desiredMirror(reference *unstructured.Unstructured, target MirrorTarget, key client.ObjectKey) (*unstructured.Unstructured, error) {
+	mirror := &unstructured.Unstructured{Object: mirroredContent(reference)}
+	mirror.SetGroupVersionKind(r.GVK)
+	mirror.SetName(key.Name)
+	mirror.SetNamespace(key.Namespace)
+	mirror.SetLabels(copyStringMap(reference.GetLabels()))
+
+	annotations := copyStringMap(reference.GetAnnotations())
+	delete(annotations, logicalcluster.AnnotationKey)
+	for _, annotation := range mirrorAnnotations {
+		delete(annotations, annotation)
+	}
+	mirror.SetAnnotations(annotations)
+
+	if err := applyTransforms(mirror, reference, target, target.Transforms); err != nil {
+		return nil, err
+	}
+	setOrigin(mirror, reference)
+	annotations = mirror.GetAnnotations()
+	annotations[tutorialkubebuilderiov1alpha1.ContentHashAnnotation] = unstructuredContentHash(mirror)
+	mirror.SetAnnotations(annotations)
+	return mirror, nil
+}
+
+// writeUnstructuredMirror server-side applies desired into target unless
+// mirror, the current copy or nil if there is none yet, is up to date
+// according to its ContentHashAnnotation. It returns the fields of the
+// mirrored object owned by other field managers, which are only taken over if
+// force is set.
+func writeUnstructuredMirror(ctx context.Context, target MirrorTarget, desired, mirror *unstructured.Unstructured, force bool) (controllerutil.OperationResult, []string, error) {
+	kind := desired.GetKind()
+	if mirror != nil && hashUpToDate(desired, mirror, func() string { return unstructuredContentHash(mirror) }) {
+		mirrorWrites.WithLabelValues(target.Name, kind, writeResultSkipped).Inc()
+		return controllerutil.OperationResultNone, nil, nil
+	}
+
+	conflicts, err := applyMirror(ctx, target.Client, desired, force)
+	if err != nil || (len(conflicts) > 0 && !force) {
+		return controllerutil.OperationResultNone, conflicts, err
+	}
+	mirrorWrites.WithLabelValues(target.Name, kind, writeResultPerformed).Inc()
+	if mirror == nil {
+		return controllerutil.OperationResultCreated, conflicts, nil
+	}
+	return controllerutil.OperationResultUpdated, conflicts, nil
+}
+
+// handleDrift records that mirror was modified in target and applies the drift
+// policy of reference. It reports whether the mirrored object should still be
+// written.
+func (r *MirrorReconciler) handleDrift(ctx context.Context, reference, mirror *unstructured.Unstructured, target MirrorTarget) (bool, error) {
+	write, _, err := r.lifecycle().handleDrift(ctx, reference, target, false, func() {
+		setMirroredContent(reference, mirror)
+		reference.SetLabels(copyStringMap(mirror.GetLabels()))
+		reference.SetAnnotations(adoptedAnnotations(reference, mirror))
+	})
+	return write, err
+}
+
+// lifecycle returns the handling of reference objects shared with the
+// WidgetReconciler.
+func (r *MirrorReconciler) lifecycle() mirrorLifecycle {
+	return mirrorLifecycle{
+		Client:          r.Client,
+		Recorder:        r.Recorder,
+		Kind:            r.GVK.Kind,
+		DeletionPolicy:  r.DeletionPolicy,
+		DeletionTimeout: r.DeletionTimeout,
+		DriftPolicy:     r.DriftPolicy,
+		Release: func(ctx context.Context, reference client.Object, policy tutorialkubebuilderiov1alpha1.DeletionPolicy) error {
+			return releaseMirrors(ctx, r.Targets, reference, r.newMirror, policy)
+		},
+	}
+}
+
+func (r *MirrorReconciler) newObject() *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(r.GVK)
+	return obj
+}
+
+func (r *MirrorReconciler) newMirror() client.Object {
+	return r.newObject()
+}
+
+// unmirroredFields are the top-level fields of an object that are not copied
+// into its mirror.
+var unmirroredFields = []string{"apiVersion", "kind", "metadata", "status"}
+
+// mirroredContent returns a copy of the top-level fields of obj that are
+// mirrored.
+func mirroredContent(obj *unstructured.Unstructured) map[string]interface{} {
+	content := runtime.DeepCopyJSON(obj.Object)
+	for _, field := range unmirroredFields {
+		delete(content, field)
+	}
+	return content
+}
+
+// setMirroredContent replaces the mirrored top-level fields of obj with those
+// of from.
+func setMirroredContent(obj, from *unstructured.Unstructured) {
+	for field := range mirroredContent(obj) {
+		delete(obj.Object, field)
+	}
+	for field, value := range mirroredContent(from) {
+		obj.Object[field] = value
+	}
+}
+
+// unstructuredContentHash returns a hash over the mirrored content of obj.
+func unstructuredContentHash(obj *unstructured.Unstructured) string {
+	return hashContent(obj, mirroredContent(obj))
+}
+
+// unstructuredDrifted reports whether mirror was modified since the controller
+// last wrote it.
+func unstructuredDrifted(mirror *unstructured.Unstructured) bool {
+	hash, ok := mirror.GetAnnotations()[tutorialkubebuilderiov1alpha1.ContentHashAnnotation]
+	return ok && hash != unstructuredContentHash(mirror)
+}
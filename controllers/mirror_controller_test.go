@@ -0,0 +1,144 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/kcp-dev/logicalcluster/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
+)
+
+var _ = Describe("MirrorReconciler", func() {
+	var (
+		r         *MirrorReconciler
+		reference *unstructured.Unstructured
+		key       types.NamespacedName
+	)
+
+	BeforeEach(func() {
+		r = &MirrorReconciler{GVK: schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}}
+		reference = &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name":            "config",
+				"namespace":       "default",
+				"uid":             "1234",
+				"resourceVersion": "5",
+				"annotations": map[string]interface{}{
+					logicalcluster.AnnotationKey: "root:org",
+					"example.com/note":           "kept",
+				},
+			},
+			"data": map[string]interface{}{"a": "1", "b": "2"},
+		}}
+		key = types.NamespacedName{Namespace: "mirror", Name: "config"}
+	})
+
+	It("copies everything but metadata and status", func() {
+		reference.Object["status"] = map[string]interface{}{"ready": true}
+		mirror, err := r.desiredMirror(reference, MirrorTarget{Name: "east"}, key)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mirror.GetName()).To(Equal("config"))
+		Expect(mirror.GetNamespace()).To(Equal("mirror"))
+		Expect(mirror.GetResourceVersion()).To(BeEmpty())
+		Expect(mirror.Object).NotTo(HaveKey("status"))
+		Expect(mirror.Object["data"]).To(Equal(map[string]interface{}{"a": "1", "b": "2"}))
+		Expect(mirror.GetAnnotations()).To(HaveKeyWithValue("example.com/note", "kept"))
+		Expect(mirror.GetAnnotations()).NotTo(HaveKey(logicalcluster.AnnotationKey))
+		Expect(mirror.GetAnnotations()).To(HaveKeyWithValue(tutorialkubebuilderiov1alpha1.SourceClusterAnnotation, "root:org"))
+		Expect(unstructuredDrifted(mirror)).To(BeFalse())
+	})
+
+	It("applies the transforms of the target", func() {
+		target := MirrorTarget{Name: "east", Transforms: []Transform{
+			{JSONPatch: []JSONPatchOperation{{Op: "remove", Path: "/data/b"}}},
+		}}
+		mirror, err := r.desiredMirror(reference, target, key)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mirror.Object["data"]).To(Equal(map[string]interface{}{"a": "1"}))
+		Expect(mirror.GetKind()).To(Equal("ConfigMap"))
+	})
+
+	It("detects and reverts changes made to the mirror", func() {
+		desired, err := r.desiredMirror(reference, MirrorTarget{Name: "east"}, key)
+		Expect(err).NotTo(HaveOccurred())
+
+		mirror := desired.DeepCopy()
+		mirror.Object["data"] = map[string]interface{}{"a": "changed"}
+		mirror.Object["binaryData"] = map[string]interface{}{"c": "AA=="}
+		Expect(unstructuredDrifted(mirror)).To(BeTrue())
+
+		setMirroredContent(mirror, desired)
+		Expect(mirror.Object).NotTo(HaveKey("binaryData"))
+		Expect(unstructuredDrifted(mirror)).To(BeFalse())
+	})
+	It("removes the finalizer of deleted objects when no targets are configured", func() {
+		ctx := context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		deleted := metav1.Now()
+		reference.SetResourceVersion("")
+		reference.SetFinalizers([]string{tutorialkubebuilderiov1alpha1.MirrorFinalizer})
+		reference.SetDeletionTimestamp(&deleted)
+		r.Client = fake.NewClientBuilder().WithScheme(scheme).WithObjects(reference).Build()
+		r.Recorder = record.NewFakeRecorder(10)
+
+		request := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(reference)}
+		Expect(r.Reconcile(ctx, request)).To(Equal(ctrl.Result{}))
+		err := r.Get(ctx, request.NamespacedName, r.newObject())
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+	It("leaves ConfigMaps copied along with Widgets alone", func() {
+		ctx := context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		source := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "default", UID: "1234", Annotations: map[string]string{logicalcluster.AnnotationKey: "root:org"}},
+			Data:       map[string]string{"a": "dependent"},
+		}
+		dependent := desiredDependent(source, "default")
+		mirror := fake.NewClientBuilder().WithScheme(scheme).WithObjects(dependent).Build()
+		target := MirrorTarget{Name: "east", Client: mirror}
+		r.Recorder = record.NewFakeRecorder(10)
+
+		Expect(r.reconcileTarget(ctx, ctx, reference, target)).To(Equal(controllerutil.OperationResultNone))
+		Expect(releaseMirror(ctx, target, reference, r.newMirror(), tutorialkubebuilderiov1alpha1.DeletionPolicyDelete)).To(Succeed())
+
+		var cm corev1.ConfigMap
+		Expect(mirror.Get(ctx, client.ObjectKeyFromObject(dependent), &cm)).To(Succeed())
+		Expect(cm.Data).To(Equal(map[string]string{"a": "dependent"}))
+		Expect(cm.Labels).To(HaveKeyWithValue(tutorialkubebuilderiov1alpha1.DependentLabel, "true"))
+	})
+})
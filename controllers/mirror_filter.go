@@ -0,0 +1,72 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
+)
+
+// MirrorFilter selects the reference objects that are mirrored. The zero value
+// selects every object.
+type MirrorFilter struct {
+	// LabelSelector must match the labels of a reference object.
+	LabelSelector labels.Selector
+
+	// AnnotationSelector must match the annotations of a reference object.
+	AnnotationSelector labels.Selector
+}
+
+// Selected reports whether obj is mirrored. The MirrorAnnotation of obj takes
+// precedence over the selectors.
+func (f MirrorFilter) Selected(obj client.Object) bool {
+	switch obj.GetAnnotations()[tutorialkubebuilderiov1alpha1.MirrorAnnotation] {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if f.LabelSelector != nil && !f.LabelSelector.Matches(labels.Set(obj.GetLabels())) {
+		return false
+	}
+	if f.AnnotationSelector != nil && !f.AnnotationSelector.Matches(labels.Set(obj.GetAnnotations())) {
+		return false
+	}
+	return true
+}
+
+// ReferencePredicate passes events for reference objects that are selected,
+// and for objects that were mirrored before and still carry the
+// MirrorFinalizer, so that their mirrored copies are released once they stop
+// matching.
+func (f MirrorFilter) ReferencePredicate() predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return f.Selected(obj) || controllerutil.ContainsFinalizer(obj, tutorialkubebuilderiov1alpha1.MirrorFinalizer)
+	})
+}
+
+// MirrorPredicate passes events for mirrored objects written by the controller.
+func MirrorPredicate() predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		_, ok := obj.GetAnnotations()[tutorialkubebuilderiov1alpha1.SourceNameAnnotation]
+		return ok
+	})
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
+)
+
+var _ = Describe("MirrorFilter", func() {
+	widget := func(objectLabels, annotations map[string]string, finalizers ...string) *tutorialkubebuilderiov1alpha1.Widget {
+		return &tutorialkubebuilderiov1alpha1.Widget{ObjectMeta: metav1.ObjectMeta{
+			Name:        "widget",
+			Namespace:   "default",
+			Labels:      objectLabels,
+			Annotations: annotations,
+			Finalizers:  finalizers,
+		}}
+	}
+	optIn := map[string]string{tutorialkubebuilderiov1alpha1.MirrorAnnotation: "true"}
+	optOut := map[string]string{tutorialkubebuilderiov1alpha1.MirrorAnnotation: "false"}
+	filter := func(labelSelector, annotationSelector string) MirrorFilter {
+		var f MirrorFilter
+		if labelSelector != "" {
+			selector, err := labels.Parse(labelSelector)
+			Expect(err).NotTo(HaveOccurred())
+			f.LabelSelector = selector
+		}
+		if annotationSelector != "" {
+			selector, err := labels.Parse(annotationSelector)
+			Expect(err).NotTo(HaveOccurred())
+			f.AnnotationSelector = selector
+		}
+		return f
+	}
+
+	DescribeTable("selects reference objects",
+		func(labelSelector, annotationSelector string, objectLabels, annotations map[string]string, selected bool) {
+			Expect(filter(labelSelector, annotationSelector).Selected(widget(objectLabels, annotations))).To(Equal(selected))
+		},
+		Entry("everything without selectors", "", "", nil, nil, true),
+		Entry("opted out without selectors", "", "", nil, optOut, false),
+		Entry("matching labels", "app=widget", "", map[string]string{"app": "widget"}, nil, true),
+		Entry("other labels", "app=widget", "", map[string]string{"app": "gadget"}, nil, false),
+		Entry("opted in with other labels", "app=widget", "", map[string]string{"app": "gadget"}, optIn, true),
+		Entry("opted out with matching labels", "app=widget", "", map[string]string{"app": "widget"}, optOut, false),
+		Entry("matching annotations", "", "team=east", nil, map[string]string{"team": "east"}, true),
+		Entry("other annotations", "", "team=east", nil, map[string]string{"team": "west"}, false),
+		Entry("matching labels and other annotations", "app=widget", "team=east", map[string]string{"app": "widget"}, map[string]string{"team": "west"}, false),
+		Entry("matching labels and annotations", "app=widget", "team=east", map[string]string{"app": "widget"}, map[string]string{"team": "east"}, true),
+		Entry("an invalid annotation value", "app=widget", "", nil, map[string]string{tutorialkubebuilderiov1alpha1.MirrorAnnotation: "yes"}, false),
+	)
+
+	DescribeTable("passes reference events",
+		func(annotations map[string]string, finalizers []string, passed bool) {
+			p := filter("app=widget", "").ReferencePredicate()
+			Expect(p.Create(event.CreateEvent{Object: widget(nil, annotations, finalizers...)})).To(Equal(passed))
+		},
+		Entry("of selected objects", optIn, nil, true),
+		Entry("of objects that are not selected", nil, nil, false),
+		Entry("of deselected objects still carrying the finalizer", optOut, []string{tutorialkubebuilderiov1alpha1.MirrorFinalizer}, true),
+	)
+
+	DescribeTable("passes mirror events",
+		func(annotations map[string]string, passed bool) {
+			Expect(MirrorPredicate().Create(event.CreateEvent{Object: widget(nil, annotations)})).To(Equal(passed))
+		},
+		Entry("of objects written by the controller", map[string]string{tutorialkubebuilderiov1alpha1.SourceNameAnnotation: "widget"}, true),
+		Entry("of other objects", map[string]string{"team": "east"}, false),
+	)
+})
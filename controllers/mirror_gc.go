@@ -0,0 +1,352 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kcp-dev/logicalcluster/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
+)
+
+// Defaults for the MirrorGarbageCollector.
+const (
+	DefaultGCInterval  = 10 * time.Minute
+	DefaultGCBatchSize = 100
+)
+
+// Actions counted by gcOrphans.
+const (
+	gcActionReported = "reported"
+	gcActionReleased = "released"
+)
+
+// gcOrphans counts the orphaned mirrored objects found by the garbage collector.
+var gcOrphans = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "mirror_gc_orphans_total",
+	Help: "Number of orphaned mirrored objects found by the garbage collector, by the action taken.",
+}, []string{"target", "kind", "action"})
+
+func init() {
+	metrics.Registry.MustRegister(gcOrphans)
+}
+
+// MirrorGarbageCollector periodically sweeps the mirror targets for mirrored
+// objects whose reference object no longer exists, is no longer selected by
+// the Filter, is no longer routed to the target, or now maps to another mirror
+// key. Such orphans are left behind
+// when the controller is not running while the reference object changes.
+// Orphans are released according to their deletion policy, taken from the
+// DeletionPolicyAnnotation copied from their reference object or else the
+// DeletionPolicy, so that they are deleted or labelled as retained.
+// ConfigMaps and Secrets copied along with mirrored Widgets are deleted once no
+// Widget references them, and namespaces the controller created in a target
+// are deleted once they no longer contain mirrored objects.
+type MirrorGarbageCollector struct {
+	// Client reads reference objects from the reference cluster.
+	Client client.Client
+
+	// Kinds are the kinds of mirrored objects that are swept.
+	Kinds []schema.GroupVersionKind
+
+	// Targets are the clusters that are swept.
+	Targets []MirrorTarget
+
+	// Filter, DeletionPolicy and Router must match those of the reconcilers.
+	Filter         MirrorFilter
+	DeletionPolicy tutorialkubebuilderiov1alpha1.DeletionPolicy
+	Router         *MirrorRouter
+
+	// Interval is the time between sweeps. Defaults to DefaultGCInterval.
+	Interval time.Duration
+
+	// BatchSize is the number of mirrored objects listed from a target at a
+	// time. Defaults to DefaultGCBatchSize.
+	BatchSize int64
+
+	// ReportOnly logs and counts orphans without releasing them.
+	ReportOnly bool
+}
+
+// Start sweeps the targets every Interval until ctx is done.
+func (gc *MirrorGarbageCollector) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("mirror-gc")
+	interval := gc.Interval
+	if interval == 0 {
+		interval = DefaultGCInterval
+	}
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := gc.Sweep(log.IntoContext(ctx, logger)); err != nil {
+			logger.Error(err, "unable to sweep mirror targets")
+		}
+	}, interval)
+	return nil
+}
+
+// NeedLeaderElection makes only the leader sweep the targets.
+func (gc *MirrorGarbageCollector) NeedLeaderElection() bool {
+	return true
+}
+
+// Sweep releases the orphaned mirrored objects in every target once.
+// Unreachable targets are skipped until the next sweep.
+func (gc *MirrorGarbageCollector) Sweep(ctx context.Context) error {
+	var errs []error
+	for _, target := range gc.Targets {
+		if !target.reachable() {
+			log.FromContext(ctx).V(1).Info("Mirror cluster is unreachable, skipping target", "target", target.Name)
+			continue
+		}
+		// Namespaces that still hold mirrored objects after the sweep.
+		occupied := map[string]bool{}
+		swept := true
+		for _, gvk := range gc.Kinds {
+			if err := gc.sweep(ctx, target, gvk, occupied); err != nil {
+				errs = append(errs, fmt.Errorf("target %s, kind %s: %w", target.Name, gvk.Kind, err))
+				swept = false
+			}
+		}
+		if !swept {
+			continue
+		}
+		if err := gc.sweepDependents(ctx, target); err != nil {
+			errs = append(errs, fmt.Errorf("target %s, dependents: %w", target.Name, err))
+			continue
+		}
+		if target.Namespaces != nil {
+			if err := gc.sweepNamespaces(ctx, target, occupied); err != nil {
+				errs = append(errs, fmt.Errorf("target %s, namespaces: %w", target.Name, err))
+			}
+		}
+	}
+	return kerrors.NewAggregate(errs)
+}
+
+// sweep releases the orphaned mirrored objects of kind gvk in target, listing
+// them BatchSize at a time. The namespaces of the mirrored objects that remain
+// are added to occupied.
+func (gc *MirrorGarbageCollector) sweep(ctx context.Context, target MirrorTarget, gvk schema.GroupVersionKind, occupied map[string]bool) error {
+	logger := log.FromContext(ctx).WithValues("target", target.Name, "kind", gvk.Kind)
+	batchSize := gc.BatchSize
+	if batchSize == 0 {
+		batchSize = DefaultGCBatchSize
+	}
+
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+	var errs []error
+	for {
+		if err := target.Client.List(ctx, list, client.Limit(batchSize), client.Continue(list.GetContinue())); err != nil {
+			return err
+		}
+		for i := range list.Items {
+			mirror := &list.Items[i]
+			released, err := gc.collect(log.IntoContext(ctx, logger), target, mirror)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			if _, ok := mirror.GetAnnotations()[tutorialkubebuilderiov1alpha1.SourceNameAnnotation]; ok && !released {
+				occupied[mirror.GetNamespace()] = true
+			}
+		}
+		if list.GetContinue() == "" {
+			return kerrors.NewAggregate(errs)
+		}
+	}
+}
+
+// collect releases mirror if it is an orphan, and reports whether mirror was
+// deleted.
+func (gc *MirrorGarbageCollector) collect(ctx context.Context, target MirrorTarget, mirror *unstructured.Unstructured) (bool, error) {
+	if _, ok := mirror.GetAnnotations()[tutorialkubebuilderiov1alpha1.SourceNameAnnotation]; !ok {
+		// Not written by the controller.
+		return false, nil
+	}
+	if isDependent(mirror) {
+		// Released by sweepDependents once no Widget references it.
+		return false, nil
+	}
+	if mirror.GetLabels()[tutorialkubebuilderiov1alpha1.RetainedLabel] == "true" || !mirror.GetDeletionTimestamp().IsZero() {
+		// Already released.
+		return false, nil
+	}
+
+	logger := log.FromContext(ctx).WithValues("mirror", client.ObjectKeyFromObject(mirror), "reference", formatRequest(referenceRequest(mirror)))
+	orphaned, reason, err := gc.orphaned(ctx, target, mirror)
+	if err != nil || !orphaned {
+		return false, err
+	}
+
+	policy := deletionPolicy(mirror, gc.DeletionPolicy)
+	if gc.ReportOnly || policy == tutorialkubebuilderiov1alpha1.DeletionPolicyOrphan {
+		logger.Info("Found orphaned mirrored object", "reason", reason, "policy", policy)
+		gcOrphans.WithLabelValues(target.Name, mirror.GetKind(), gcActionReported).Inc()
+		return false, nil
+	}
+	if err := applyDeletionPolicy(ctx, target.Client, mirror, policy); err != nil {
+		return false, fmt.Errorf("unable to release %s: %w", client.ObjectKeyFromObject(mirror), err)
+	}
+	logger.Info("Released orphaned mirrored object", "reason", reason, "policy", policy)
+	gcOrphans.WithLabelValues(target.Name, mirror.GetKind(), gcActionReleased).Inc()
+	return policy == tutorialkubebuilderiov1alpha1.DeletionPolicyDelete, nil
+}
+
+// orphaned reports whether mirror no longer belongs to its reference object,
+// and why.
+func (gc *MirrorGarbageCollector) orphaned(ctx context.Context, target MirrorTarget, mirror *unstructured.Unstructured) (bool, string, error) {
+	req := referenceRequest(mirror)
+	referenceCtx := ctx
+	if req.ClusterName != "" {
+		referenceCtx = logicalcluster.WithCluster(ctx, logicalcluster.New(req.ClusterName))
+	}
+
+	reference := &unstructured.Unstructured{}
+	reference.SetGroupVersionKind(mirror.GroupVersionKind())
+	if err := gc.Client.Get(referenceCtx, req.NamespacedName, reference); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return false, "", err
+		}
+		// Objects mirrored from a paused namespace are left alone.
+		by, err := namespacePausedBy(referenceCtx, gc.Client, req.Namespace)
+		if err != nil || by != "" {
+			return false, "", err
+		}
+		return true, "reference object not found", nil
+	}
+	if by, err := pausedBy(referenceCtx, gc.Client, reference); err != nil || by != "" {
+		return false, "", err
+	}
+	if recreated(mirror, reference) {
+		return true, "reference object was recreated", nil
+	}
+	if !gc.Filter.Selected(reference) && !controllerutil.ContainsFinalizer(reference, tutorialkubebuilderiov1alpha1.MirrorFinalizer) {
+		// Reference objects that still carry the finalizer are released by the
+		// reconciler.
+		return true, "reference object not selected", nil
+	}
+	routed, err := gc.Router.Targets(referenceCtx, reference, []MirrorTarget{target})
+	if err != nil {
+		return false, "", err
+	}
+	if len(routed) == 0 {
+		return true, "reference object not routed to target", nil
+	}
+	key, err := mirrorKey(target, reference)
+	if err != nil {
+		return false, "", err
+	}
+	if key != client.ObjectKeyFromObject(mirror) {
+		return true, fmt.Sprintf("reference object is mirrored to %s", key), nil
+	}
+	return false, "", nil
+}
+
+// sweepDependents deletes the ConfigMaps and Secrets the controller copied into
+// target that no Widget there references anymore.
+func (gc *MirrorGarbageCollector) sweepDependents(ctx context.Context, target MirrorTarget) error {
+	logger := log.FromContext(ctx).WithValues("target", target.Name)
+	unreferenced, err := unreferencedDependents(ctx, target.Client, "", client.ObjectKey{}, nil)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, obj := range unreferenced {
+		kind := obj.GetObjectKind().GroupVersionKind().Kind
+		if gc.ReportOnly {
+			logger.Info("Found unreferenced mirrored object", "kind", kind, "mirror", client.ObjectKeyFromObject(obj))
+			gcOrphans.WithLabelValues(target.Name, kind, gcActionReported).Inc()
+			continue
+		}
+		if err := deleteDependent(ctx, target.Client, obj); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		logger.Info("Deleted unreferenced mirrored object", "kind", kind, "mirror", client.ObjectKeyFromObject(obj))
+		gcOrphans.WithLabelValues(target.Name, kind, gcActionReleased).Inc()
+	}
+	return kerrors.NewAggregate(errs)
+}
+
+// sweepNamespaces deletes the namespaces the controller created in target that
+// are not occupied by mirrored objects.
+func (gc *MirrorGarbageCollector) sweepNamespaces(ctx context.Context, target MirrorTarget, occupied map[string]bool) error {
+	logger := log.FromContext(ctx).WithValues("target", target.Name)
+	var namespaces corev1.NamespaceList
+	if err := target.Client.List(ctx, &namespaces, client.MatchingLabels{tutorialkubebuilderiov1alpha1.ManagedNamespaceLabel: "true"}); err != nil {
+		return err
+	}
+	var errs []error
+	for i := range namespaces.Items {
+		ns := &namespaces.Items[i]
+		if occupied[ns.Name] || !ns.DeletionTimestamp.IsZero() {
+			continue
+		}
+		// Mirrored objects may have been written since the sweep listed them.
+		empty, err := gc.emptyNamespace(ctx, target, ns.Name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("namespace %s: %w", ns.Name, err))
+			continue
+		}
+		if !empty {
+			continue
+		}
+		if gc.ReportOnly {
+			logger.Info("Found empty mirror namespace", "namespace", ns.Name)
+			gcOrphans.WithLabelValues(target.Name, "Namespace", gcActionReported).Inc()
+			continue
+		}
+		uid := ns.UID
+		if err := target.Client.Delete(ctx, ns, client.Preconditions{UID: &uid}); client.IgnoreNotFound(err) != nil {
+			errs = append(errs, fmt.Errorf("unable to delete namespace %s: %w", ns.Name, err))
+			continue
+		}
+		logger.Info("Deleted empty mirror namespace", "namespace", ns.Name)
+		gcOrphans.WithLabelValues(target.Name, "Namespace", gcActionReleased).Inc()
+	}
+	return kerrors.NewAggregate(errs)
+}
+
+// emptyNamespace reports whether namespace in target holds no mirrored objects.
+func (gc *MirrorGarbageCollector) emptyNamespace(ctx context.Context, target MirrorTarget, namespace string) (bool, error) {
+	for _, gvk := range gc.Kinds {
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		if err := target.Client.List(ctx, list, client.InNamespace(namespace)); err != nil {
+			return false, err
+		}
+		for _, item := range list.Items {
+			if _, ok := item.GetAnnotations()[tutorialkubebuilderiov1alpha1.SourceNameAnnotation]; ok {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
+)
+
+var _ = Describe("MirrorGarbageCollector", func() {
+	var (
+		ctx       context.Context
+		scheme    *runtime.Scheme
+		reference client.Client
+		mirror    client.Client
+		gc        *MirrorGarbageCollector
+	)
+
+	widget := func(name string, annotations map[string]string) *tutorialkubebuilderiov1alpha1.Widget {
+		return &tutorialkubebuilderiov1alpha1.Widget{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Annotations: annotations},
+		}
+	}
+	mirrored := func(name string, annotations map[string]string) *tutorialkubebuilderiov1alpha1.Widget {
+		w := widget(name, map[string]string{
+			tutorialkubebuilderiov1alpha1.SourceNamespaceAnnotation: "default",
+			tutorialkubebuilderiov1alpha1.SourceNameAnnotation:      name,
+		})
+		for k, v := range annotations {
+			w.Annotations[k] = v
+		}
+		return w
+	}
+	exists := func(name string) bool {
+		var w tutorialkubebuilderiov1alpha1.Widget
+		return mirror.Get(ctx, client.ObjectKey{Namespace: "default", Name: name}, &w) == nil
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme = runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(tutorialkubebuilderiov1alpha1.AddToScheme(scheme)).To(Succeed())
+		reference = fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+			widget("live", nil),
+			widget("unselected", map[string]string{tutorialkubebuilderiov1alpha1.MirrorAnnotation: "false"}),
+		).Build()
+		mirror = fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			mirrored("live", nil),
+			mirrored("deleted", nil),
+			mirrored("unselected", nil),
+			mirrored("orphaned", map[string]string{tutorialkubebuilderiov1alpha1.DeletionPolicyAnnotation: "Orphan"}),
+			mirrored("retained", map[string]string{tutorialkubebuilderiov1alpha1.DeletionPolicyAnnotation: "Retain"}),
+			widget("unmanaged", nil),
+		).Build()
+		gc = &MirrorGarbageCollector{
+			Client:    reference,
+			Kinds:     []schema.GroupVersionKind{tutorialkubebuilderiov1alpha1.GroupVersion.WithKind("Widget")},
+			Targets:   []MirrorTarget{{Name: "east", Client: mirror}},
+			BatchSize: 2,
+		}
+	})
+
+	It("releases orphans according to their deletion policy", func() {
+		Expect(gc.Sweep(ctx)).To(Succeed())
+		Expect(exists("live")).To(BeTrue())
+		Expect(exists("deleted")).To(BeFalse())
+		Expect(exists("unselected")).To(BeFalse())
+		Expect(exists("orphaned")).To(BeTrue())
+		Expect(exists("unmanaged")).To(BeTrue())
+
+		var retained tutorialkubebuilderiov1alpha1.Widget
+		Expect(mirror.Get(ctx, client.ObjectKey{Namespace: "default", Name: "retained"}, &retained)).To(Succeed())
+		Expect(retained.Labels).To(HaveKeyWithValue(tutorialkubebuilderiov1alpha1.RetainedLabel, "true"))
+	})
+
+	It("only reports orphans in report-only mode", func() {
+		gc.ReportOnly = true
+		Expect(gc.Sweep(ctx)).To(Succeed())
+		Expect(exists("deleted")).To(BeTrue())
+		Expect(exists("unselected")).To(BeTrue())
+	})
+
+	It("skips orphans whose reference or namespace is paused", func() {
+		paused := map[string]string{tutorialkubebuilderiov1alpha1.PausedAnnotation: "true"}
+		unselected := widget("unselected", map[string]string{tutorialkubebuilderiov1alpha1.MirrorAnnotation: "false"})
+		Expect(reference.Get(ctx, client.ObjectKeyFromObject(unselected), unselected)).To(Succeed())
+		unselected.Annotations[tutorialkubebuilderiov1alpha1.PausedAnnotation] = "true"
+		Expect(reference.Update(ctx, unselected)).To(Succeed())
+
+		Expect(gc.Sweep(ctx)).To(Succeed())
+		Expect(exists("unselected")).To(BeTrue())
+		Expect(exists("deleted")).To(BeFalse())
+
+		Expect(mirror.Create(ctx, mirrored("recreated", nil))).To(Succeed())
+		Expect(reference.Update(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Annotations: paused}})).To(Succeed())
+		Expect(gc.Sweep(ctx)).To(Succeed())
+		Expect(exists("recreated")).To(BeTrue())
+	})
+
+	It("releases mirrors of an earlier reference object of the same name", func() {
+		live := widget("live", nil)
+		Expect(reference.Delete(ctx, live)).To(Succeed())
+		live = widget("live", nil)
+		live.UID = "recreated-uid"
+		Expect(reference.Create(ctx, live)).To(Succeed())
+		stale := mirrored("live", map[string]string{tutorialkubebuilderiov1alpha1.SourceUIDAnnotation: "deleted-uid"})
+		Expect(mirror.Delete(ctx, mirrored("live", nil))).To(Succeed())
+		Expect(mirror.Create(ctx, stale)).To(Succeed())
+
+		Expect(gc.Sweep(ctx)).To(Succeed())
+		Expect(exists("live")).To(BeFalse())
+	})
+
+	It("skips unreachable targets", func() {
+		gc.Targets[0].Reachable = func() bool { return false }
+		Expect(gc.Sweep(ctx)).To(Succeed())
+		Expect(exists("deleted")).To(BeTrue())
+	})
+})
@@ -0,0 +1,70 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
+)
+
+// MirrorNamespaces configures the creation of missing namespaces in a mirror
+// target. Namespaces created by the controller carry the ManagedNamespaceLabel
+// and are deleted by the MirrorGarbageCollector once they no longer contain
+// mirrored objects.
+type MirrorNamespaces struct {
+	// Labels are set on created namespaces.
+	Labels map[string]string
+
+	// Annotations are set on created namespaces.
+	Annotations map[string]string
+}
+
+// ensureNamespace creates namespace in target if target creates missing
+// namespaces and it does not exist yet.
+func ensureNamespace(ctx context.Context, target MirrorTarget, namespace string) error {
+	if target.Namespaces == nil || namespace == "" {
+		return nil
+	}
+	labels := copyStringMap(target.Namespaces.Labels)
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[tutorialkubebuilderiov1alpha1.ManagedNamespaceLabel] = "true"
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        namespace,
+			Labels:      labels,
+			Annotations: copyStringMap(target.Namespaces.Annotations),
+		},
+	}
+	// Create instead of reading first, so that the cache of the target does
+	// not have to watch namespaces.
+	if err := target.Client.Create(ctx, ns); err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			return nil
+		}
+		return err
+	}
+	log.FromContext(ctx).Info("Created mirror namespace", "target", target.Name, "namespace", namespace)
+	return nil
+}
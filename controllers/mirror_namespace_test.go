@@ -0,0 +1,116 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
+)
+
+var _ = Describe("Mirror namespaces", func() {
+	var (
+		ctx    context.Context
+		mirror client.Client
+		target MirrorTarget
+	)
+
+	namespace := func(name string, managed bool) *corev1.Namespace {
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if managed {
+			ns.Labels = map[string]string{tutorialkubebuilderiov1alpha1.ManagedNamespaceLabel: "true"}
+		}
+		return ns
+	}
+	exists := func(name string) bool {
+		var ns corev1.Namespace
+		return mirror.Get(ctx, client.ObjectKey{Name: name}, &ns) == nil
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(tutorialkubebuilderiov1alpha1.AddToScheme(scheme)).To(Succeed())
+		mirror = fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			namespace("existing", false),
+			namespace("occupied", true),
+			namespace("empty", true),
+			&tutorialkubebuilderiov1alpha1.Widget{ObjectMeta: metav1.ObjectMeta{
+				Name:      "live",
+				Namespace: "occupied",
+				Annotations: map[string]string{
+					tutorialkubebuilderiov1alpha1.SourceNamespaceAnnotation: "occupied",
+					tutorialkubebuilderiov1alpha1.SourceNameAnnotation:      "live",
+				},
+			}},
+		).Build()
+		target = MirrorTarget{
+			Name:   "east",
+			Client: mirror,
+			Namespaces: &MirrorNamespaces{
+				Labels:      map[string]string{"team": "mirror"},
+				Annotations: map[string]string{"owner": "mirror-controller"},
+			},
+		}
+	})
+
+	It("creates missing namespaces with the configured metadata", func() {
+		Expect(ensureNamespace(ctx, target, "created")).To(Succeed())
+		var ns corev1.Namespace
+		Expect(mirror.Get(ctx, client.ObjectKey{Name: "created"}, &ns)).To(Succeed())
+		Expect(ns.Labels).To(HaveKeyWithValue("team", "mirror"))
+		Expect(ns.Labels).To(HaveKeyWithValue(tutorialkubebuilderiov1alpha1.ManagedNamespaceLabel, "true"))
+		Expect(ns.Annotations).To(HaveKeyWithValue("owner", "mirror-controller"))
+	})
+
+	It("leaves existing namespaces alone", func() {
+		Expect(ensureNamespace(ctx, target, "existing")).To(Succeed())
+		var ns corev1.Namespace
+		Expect(mirror.Get(ctx, client.ObjectKey{Name: "existing"}, &ns)).To(Succeed())
+		Expect(ns.Labels).NotTo(HaveKey(tutorialkubebuilderiov1alpha1.ManagedNamespaceLabel))
+	})
+
+	It("does not create namespaces unless configured", func() {
+		target.Namespaces = nil
+		Expect(ensureNamespace(ctx, target, "created")).To(Succeed())
+		Expect(exists("created")).To(BeFalse())
+	})
+
+	It("deletes empty namespaces it created", func() {
+		gc := &MirrorGarbageCollector{
+			Client:  fake.NewClientBuilder().WithScheme(mirror.Scheme()).Build(),
+			Kinds:   []schema.GroupVersionKind{tutorialkubebuilderiov1alpha1.GroupVersion.WithKind("Widget")},
+			Targets: []MirrorTarget{target},
+			// Keep the live Widget although its reference object is missing.
+			DeletionPolicy: tutorialkubebuilderiov1alpha1.DeletionPolicyOrphan,
+		}
+		Expect(gc.Sweep(ctx)).To(Succeed())
+		Expect(exists("existing")).To(BeTrue())
+		Expect(exists("occupied")).To(BeTrue())
+		Expect(exists("empty")).To(BeFalse())
+	})
+})
@@ -0,0 +1,135 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kcp-dev/logicalcluster/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
+)
+
+// isPaused reports whether obj carries the PausedAnnotation.
+func isPaused(obj client.Object) bool {
+	return obj.GetAnnotations()[tutorialkubebuilderiov1alpha1.PausedAnnotation] == "true"
+}
+
+// pausedBy reports what pauses the mirroring of reference: the reference object
+// itself or its namespace. It returns an empty string if mirroring is not
+// paused. ctx must carry the logical cluster of reference.
+func pausedBy(ctx context.Context, c client.Reader, reference client.Object) (string, error) {
+	if isPaused(reference) {
+		return "the object", nil
+	}
+	return namespacePausedBy(ctx, c, reference.GetNamespace())
+}
+
+// namespacePausedBy is pausedBy for the reference objects in namespace.
+func namespacePausedBy(ctx context.Context, c client.Reader, namespace string) (string, error) {
+	if namespace == "" {
+		return "", nil
+	}
+	var ns corev1.Namespace
+	if err := c.Get(ctx, client.ObjectKey{Name: namespace}, &ns); err != nil {
+		return "", client.IgnoreNotFound(err)
+	}
+	if isPaused(&ns) {
+		return fmt.Sprintf("namespace %s", namespace), nil
+	}
+	return "", nil
+}
+
+// setPausedCondition records in the Paused condition of widget whether its
+// mirroring is paused, and by what. A Widget that was never paused does not get
+// the condition.
+func setPausedCondition(widget *tutorialkubebuilderiov1alpha1.Widget, by string) {
+	condition := metav1.Condition{
+		Type:               tutorialkubebuilderiov1alpha1.ConditionTypePaused,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: widget.Generation,
+		Reason:             "Paused",
+		Message:            fmt.Sprintf("Mirroring is paused by the %s annotation on %s", tutorialkubebuilderiov1alpha1.PausedAnnotation, by),
+	}
+	if by == "" {
+		if !meta.IsStatusConditionTrue(widget.Status.Conditions, tutorialkubebuilderiov1alpha1.ConditionTypePaused) {
+			return
+		}
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "Resumed"
+		condition.Message = "Mirroring is active"
+	}
+	meta.SetStatusCondition(&widget.Status.Conditions, condition)
+}
+
+// NamespacePauseHandler enqueues the reference objects in a namespace whose
+// PausedAnnotation changed, so that they catch up as soon as the namespace is
+// resumed. newList returns an empty list of the reference kind, read through c.
+func NamespacePauseHandler(c client.Reader, newList func() client.ObjectList) handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(ns client.Object) []reconcile.Request {
+		cluster := logicalcluster.From(ns)
+		ctx := context.Background()
+		if !cluster.Empty() {
+			ctx = logicalcluster.WithCluster(ctx, cluster)
+		}
+		list := newList()
+		if err := c.List(ctx, list, client.InNamespace(ns.GetName())); err != nil {
+			log.FromContext(ctx).Error(err, "unable to list reference objects", "namespace", ns.GetName())
+			return nil
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			return nil
+		}
+		requests := make([]reconcile.Request, 0, len(items))
+		for _, item := range items {
+			obj, ok := item.(client.Object)
+			if !ok {
+				continue
+			}
+			requests = append(requests, reconcile.Request{
+				ClusterName:    cluster.String(),
+				NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()},
+			})
+		}
+		return requests
+	})
+}
+
+// NamespacePausePredicate passes updates of namespaces whose PausedAnnotation
+// changed.
+func NamespacePausePredicate() predicate.Predicate {
+	return predicate.Funcs{
+		CreateFunc:  func(event.CreateEvent) bool { return false },
+		DeleteFunc:  func(event.DeleteEvent) bool { return false },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return isPaused(e.ObjectOld) != isPaused(e.ObjectNew)
+		},
+	}
+}
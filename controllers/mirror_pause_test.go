@@ -0,0 +1,118 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
+)
+
+var _ = Describe("Pausing", func() {
+	var (
+		ctx       context.Context
+		scheme    *runtime.Scheme
+		reference client.Client
+	)
+
+	paused := map[string]string{tutorialkubebuilderiov1alpha1.PausedAnnotation: "true"}
+	widgetIn := func(namespace string, annotations map[string]string) *tutorialkubebuilderiov1alpha1.Widget {
+		return &tutorialkubebuilderiov1alpha1.Widget{ObjectMeta: metav1.ObjectMeta{
+			Name:        "widget",
+			Namespace:   namespace,
+			Annotations: annotations,
+		}}
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme = runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(tutorialkubebuilderiov1alpha1.AddToScheme(scheme)).To(Succeed())
+		reference = fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "active"}},
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "frozen", Annotations: paused}},
+		).Build()
+	})
+
+	It("is paused by the annotation on the object or its namespace", func() {
+		Expect(pausedBy(ctx, reference, widgetIn("active", nil))).To(BeEmpty())
+		Expect(pausedBy(ctx, reference, widgetIn("active", paused))).To(Equal("the object"))
+		Expect(pausedBy(ctx, reference, widgetIn("frozen", nil))).To(Equal("namespace frozen"))
+		Expect(pausedBy(ctx, reference, widgetIn("missing", nil))).To(BeEmpty())
+	})
+
+	It("lets paused Widgets go when they are deleted and leaves their mirrors alone", func() {
+		for _, widget := range []*tutorialkubebuilderiov1alpha1.Widget{widgetIn("active", paused), widgetIn("frozen", nil)} {
+			deleted := metav1.NewTime(time.Now().Add(-time.Hour))
+			widget.Finalizers = []string{tutorialkubebuilderiov1alpha1.MirrorFinalizer}
+			widget.DeletionTimestamp = &deleted
+			Expect(reference.Create(ctx, widget)).To(Succeed())
+			key := client.ObjectKeyFromObject(widget)
+			mirror := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&tutorialkubebuilderiov1alpha1.Widget{
+				ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace},
+			}).Build()
+			recorder := record.NewFakeRecorder(10)
+			r := &WidgetReconciler{
+				Client:   reference,
+				Scheme:   scheme,
+				Targets:  []MirrorTarget{{Name: "east", Client: mirror}},
+				Recorder: recorder,
+			}
+
+			Expect(r.Reconcile(ctx, ctrl.Request{NamespacedName: key})).To(Equal(ctrl.Result{}))
+			Expect(apierrors.IsNotFound(reference.Get(ctx, key, &tutorialkubebuilderiov1alpha1.Widget{}))).To(BeTrue())
+			Expect(mirror.Get(ctx, key, &tutorialkubebuilderiov1alpha1.Widget{})).To(Succeed())
+			Expect(recorder.Events).To(Receive(ContainSubstring("MirrorAbandoned")))
+		}
+	})
+
+	It("reports the Paused condition", func() {
+		widget := widgetIn("active", nil)
+		setPausedCondition(widget, "")
+		Expect(widget.Status.Conditions).To(BeEmpty())
+
+		setPausedCondition(widget, "namespace frozen")
+		Expect(meta.IsStatusConditionTrue(widget.Status.Conditions, tutorialkubebuilderiov1alpha1.ConditionTypePaused)).To(BeTrue())
+
+		setPausedCondition(widget, "")
+		Expect(meta.IsStatusConditionFalse(widget.Status.Conditions, tutorialkubebuilderiov1alpha1.ConditionTypePaused)).To(BeTrue())
+	})
+
+	It("passes namespace updates that pause or resume", func() {
+		p := NamespacePausePredicate()
+		active := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns"}}
+		frozen := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns", Annotations: paused}}
+		Expect(p.Update(event.UpdateEvent{ObjectOld: frozen, ObjectNew: active})).To(BeTrue())
+		Expect(p.Update(event.UpdateEvent{ObjectOld: active, ObjectNew: frozen})).To(BeTrue())
+		Expect(p.Update(event.UpdateEvent{ObjectOld: active, ObjectNew: active})).To(BeFalse())
+	})
+})
@@ -0,0 +1,185 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kcp-dev/logicalcluster/v2"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// clusterWorkspaceGVK is the kind of the objects representing kcp workspaces in
+// their parent workspace.
+var clusterWorkspaceGVK = schema.GroupVersionKind{Group: "tenancy.kcp.dev", Version: "v1alpha1", Kind: "ClusterWorkspace"}
+
+//+kubebuilder:rbac:groups=tenancy.kcp.dev,resources=clusterworkspaces,verbs=get
+
+// WorkspaceLabelRequeueAfter is how often reference objects routed by the label
+// of their ClusterWorkspace are reconciled again, as changes of the label are
+// not watched.
+const WorkspaceLabelRequeueAfter = 5 * time.Minute
+
+// MirrorRouter chooses the mirror targets of a reference object by the kcp
+// workspace (logical cluster) it lives in, so that the tenants bound to an
+// APIExport can each be mirrored into their own cluster.
+//
+// A workspace listed in Routes is mirrored into the targets named there.
+// Otherwise, if Label is set, the workspace is mirrored into the target named
+// by that label on its ClusterWorkspace. Any other workspace is mirrored into
+// the DefaultTargets. A nil MirrorRouter mirrors every reference object into
+// every target.
+//
+// Mirrored objects left behind in a target when the route of their workspace
+// changes are released by the MirrorGarbageCollector. ClusterWorkspaces are not
+// watched, so reference objects routed by Label are reconciled again every
+// WorkspaceLabelRequeueAfter to follow changes of the label.
+type MirrorRouter struct {
+	// Routes maps workspaces to the names of their targets.
+	Routes map[logicalcluster.Name][]string
+
+	// Label is the key of the ClusterWorkspace label naming the target of a
+	// workspace.
+	Label string
+
+	// Workspaces reads ClusterWorkspaces from the parent workspace of a
+	// workspace. It is required when Label is set and must not be restricted
+	// to a virtual workspace.
+	Workspaces client.Reader
+
+	// DefaultTargets are the names of the targets of the other workspaces.
+	DefaultTargets []string
+}
+
+// ParseMirrorRoute parses a route given as <workspace>=<target>[+<target>...].
+func ParseMirrorRoute(value string) (logicalcluster.Name, []string, error) {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return logicalcluster.Name{}, nil, fmt.Errorf("expected <workspace>=<target>, got %q", value)
+	}
+	workspace, ok := logicalcluster.NewValidated(parts[0])
+	if !ok {
+		return logicalcluster.Name{}, nil, fmt.Errorf("invalid workspace %q", parts[0])
+	}
+	return workspace, strings.Split(parts[1], "+"), nil
+}
+
+// Validate checks that the router only names known targets.
+func (r *MirrorRouter) Validate(targets []MirrorTarget) error {
+	if r == nil {
+		return nil
+	}
+	known := map[string]bool{}
+	for _, target := range targets {
+		known[target.Name] = true
+	}
+	check := func(names []string) error {
+		for _, name := range names {
+			if !known[name] {
+				return fmt.Errorf("unknown target %q", name)
+			}
+		}
+		return nil
+	}
+	for workspace, names := range r.Routes {
+		if err := check(names); err != nil {
+			return fmt.Errorf("route for workspace %s: %w", workspace, err)
+		}
+	}
+	if err := check(r.DefaultTargets); err != nil {
+		return fmt.Errorf("default targets: %w", err)
+	}
+	if r.Label != "" && r.Workspaces == nil {
+		return fmt.Errorf("routing by workspace label requires a workspace reader")
+	}
+	return nil
+}
+
+// Targets returns the targets among targets that reference is mirrored into.
+func (r *MirrorRouter) Targets(ctx context.Context, reference client.Object, targets []MirrorTarget) ([]MirrorTarget, error) {
+	routed, _, err := r.Route(ctx, reference, targets)
+	return routed, err
+}
+
+// Route returns the targets among targets that reference is mirrored into,
+// along with the names of its targets that are not among targets. Only the
+// label of a ClusterWorkspace can name such targets, as the other routes are
+// checked by Validate.
+func (r *MirrorRouter) Route(ctx context.Context, reference client.Object, targets []MirrorTarget) ([]MirrorTarget, []string, error) {
+	if r == nil {
+		return targets, nil, nil
+	}
+	names, err := r.route(ctx, logicalcluster.From(reference))
+	if err != nil {
+		return nil, nil, err
+	}
+	routed := make([]MirrorTarget, 0, len(names))
+	known := map[string]bool{}
+	for _, target := range targets {
+		for _, name := range names {
+			if target.Name == name {
+				routed = append(routed, target)
+				known[name] = true
+				break
+			}
+		}
+	}
+	var unknown []string
+	for _, name := range names {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	return routed, unknown, nil
+}
+
+// RequeueAfter returns how long to wait before reconciling a reference object
+// again to pick up changes of its route, or zero if routes only change with
+// the configuration.
+func (r *MirrorRouter) RequeueAfter() time.Duration {
+	if r == nil || r.Label == "" {
+		return 0
+	}
+	return WorkspaceLabelRequeueAfter
+}
+
+// route returns the names of the targets of workspace.
+func (r *MirrorRouter) route(ctx context.Context, workspace logicalcluster.Name) ([]string, error) {
+	if names, ok := r.Routes[workspace]; ok {
+		return names, nil
+	}
+	if r.Label != "" && !workspace.Empty() {
+		if parent, ok := workspace.Parent(); ok {
+			cw := &metav1.PartialObjectMetadata{}
+			cw.SetGroupVersionKind(clusterWorkspaceGVK)
+			err := r.Workspaces.Get(logicalcluster.WithCluster(ctx, parent), client.ObjectKey{Name: workspace.Base()}, cw)
+			if err != nil && !apierrors.IsNotFound(err) {
+				return nil, fmt.Errorf("unable to read workspace %s: %w", workspace, err)
+			}
+			if name, ok := cw.GetLabels()[r.Label]; ok {
+				return []string{name}, nil
+			}
+		}
+	}
+	return r.DefaultTargets, nil
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/kcp-dev/logicalcluster/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
+)
+
+// workspaceReader serves the labels of ClusterWorkspaces by logical cluster.
+type workspaceReader map[logicalcluster.Name]map[string]string
+
+func (r workspaceReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	parent, _ := logicalcluster.ClusterFromContext(ctx)
+	labels, ok := r[parent.Join(key.Name)]
+	if !ok {
+		return apierrors.NewNotFound(schema.GroupResource{Group: "tenancy.kcp.dev", Resource: "clusterworkspaces"}, key.Name)
+	}
+	obj.SetLabels(labels)
+	return nil
+}
+
+func (r workspaceReader) List(context.Context, client.ObjectList, ...client.ListOption) error {
+	return nil
+}
+
+var _ = Describe("MirrorRouter", func() {
+	var (
+		ctx     context.Context
+		targets []MirrorTarget
+		router  *MirrorRouter
+	)
+
+	widgetIn := func(workspace string) *tutorialkubebuilderiov1alpha1.Widget {
+		return &tutorialkubebuilderiov1alpha1.Widget{ObjectMeta: metav1.ObjectMeta{
+			Name:        "widget",
+			Annotations: map[string]string{logicalcluster.AnnotationKey: workspace},
+		}}
+	}
+	routed := func(workspace string) []string {
+		routed, err := router.Targets(ctx, widgetIn(workspace), targets)
+		Expect(err).NotTo(HaveOccurred())
+		names := []string{}
+		for _, target := range routed {
+			names = append(names, target.Name)
+		}
+		return names
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		targets = []MirrorTarget{{Name: "east"}, {Name: "west"}, {Name: "shared"}}
+		router = &MirrorRouter{
+			Routes: map[logicalcluster.Name][]string{
+				logicalcluster.New("root:org:a"): {"east", "west"},
+			},
+			Label: "mirror-target",
+			Workspaces: workspaceReader{
+				logicalcluster.New("root:org:b"): {"mirror-target": "west"},
+				logicalcluster.New("root:org:d"): {"mirror-target": "north"},
+			},
+			DefaultTargets: []string{"shared"},
+		}
+	})
+
+	It("mirrors into every target without a router", func() {
+		router = nil
+		Expect(routed("root:org:a")).To(Equal([]string{"east", "west", "shared"}))
+	})
+
+	It("routes workspaces by the routing table first", func() {
+		Expect(routed("root:org:a")).To(Equal([]string{"east", "west"}))
+	})
+
+	It("routes workspaces by their label", func() {
+		Expect(routed("root:org:b")).To(Equal([]string{"west"}))
+	})
+
+	It("routes other workspaces to the default targets", func() {
+		Expect(routed("root:org:c")).To(Equal([]string{"shared"}))
+	})
+
+	It("returns the unknown targets named by a workspace label", func() {
+		routed, unknown, err := router.Route(ctx, widgetIn("root:org:d"), targets)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(routed).To(BeEmpty())
+		Expect(unknown).To(Equal([]string{"north"}))
+
+		_, unknown, err = router.Route(ctx, widgetIn("root:org:b"), targets)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(unknown).To(BeEmpty())
+	})
+
+	It("reports unknown targets in the Widget status", func() {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(tutorialkubebuilderiov1alpha1.AddToScheme(scheme)).To(Succeed())
+		widget := widgetIn("root:org:d")
+		widget.Namespace = "default"
+		reference := fake.NewClientBuilder().WithScheme(scheme).WithObjects(widget).Build()
+		r := &WidgetReconciler{
+			Client:   reference,
+			Scheme:   scheme,
+			Targets:  targets,
+			Recorder: record.NewFakeRecorder(10),
+			Router:   router,
+		}
+
+		request := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(widget)}
+		Expect(r.Reconcile(ctx, request)).To(Equal(ctrl.Result{RequeueAfter: WorkspaceLabelRequeueAfter}))
+		Expect(reference.Get(ctx, request.NamespacedName, widget)).To(Succeed())
+		Expect(widget.Status.Mirrors).To(ConsistOf(And(
+			HaveField("Target", "north"),
+			HaveField("Synced", false),
+			HaveField("Message", ContainSubstring("Unknown mirror target")),
+		)))
+	})
+
+	It("requeues reference objects only when routing by workspace label", func() {
+		Expect(router.RequeueAfter()).To(Equal(WorkspaceLabelRequeueAfter))
+		router.Label = ""
+		Expect(router.RequeueAfter()).To(BeZero())
+		router = nil
+		Expect(router.RequeueAfter()).To(BeZero())
+	})
+
+	It("rejects unknown targets", func() {
+		router.DefaultTargets = []string{"north"}
+		Expect(router.Validate(targets)).NotTo(Succeed())
+	})
+
+	It("parses routes", func() {
+		workspace, names, err := ParseMirrorRoute("root:org:a=east+west")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(workspace).To(Equal(logicalcluster.New("root:org:a")))
+		Expect(names).To(Equal([]string{"east", "west"}))
+
+		_, _, err = ParseMirrorRoute("root:org:a")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,63 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// UnreachableRequeueAfter is the time after which reconcilers retry a reference
+// object that was not mirrored into an unreachable target.
+const UnreachableRequeueAfter = 30 * time.Second
+
+// MirrorTarget is a cluster that reference objects are mirrored into.
+type MirrorTarget struct {
+	// Name identifies the target in logs, events and the Widget status.
+	Name string
+
+	// Client reads and writes mirrored objects in the target cluster.
+	Client client.Client
+
+	// NamespaceMapping maps reference namespaces to namespaces in the target
+	// cluster.
+	NamespaceMapping NamespaceMapping
+
+	// Transforms rewrite mirrored objects before they are written to the
+	// target cluster.
+	Transforms []Transform
+
+	// Namespaces, if set, makes reconcilers create the namespaces mirrored
+	// objects are written to when they are missing.
+	Namespaces *MirrorNamespaces
+
+	// DryRun is set by DryRunTarget when Client only issues dry-run requests.
+	// Reconcilers then leave the reference cluster untouched on behalf of the
+	// target, e.g. they do not adopt drift or copy back status from it.
+	DryRun bool
+
+	// Reachable reports whether the target cluster can currently be reached,
+	// e.g. MirrorCluster.Reachable. Reconcilers skip unreachable targets and
+	// retry after UnreachableRequeueAfter. A nil Reachable treats the target as
+	// always reachable.
+	Reachable func() bool
+}
+
+func (t MirrorTarget) reachable() bool {
+	return t.Reachable == nil || t.Reachable()
+}
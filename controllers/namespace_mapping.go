@@ -0,0 +1,156 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/kcp-dev/logicalcluster/v2"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// NamespaceMappingMode selects how a NamespaceMapping computes mirror namespaces.
+type NamespaceMappingMode string
+
+const (
+	// NamespaceMappingIdentity keeps the namespace of the reference Widget.
+	NamespaceMappingIdentity NamespaceMappingMode = "identity"
+	// NamespaceMappingFixed puts every mirrored Widget into one namespace.
+	NamespaceMappingFixed NamespaceMappingMode = "fixed"
+	// NamespaceMappingPrefix prepends a string to the reference namespace.
+	NamespaceMappingPrefix NamespaceMappingMode = "prefix"
+	// NamespaceMappingSuffix appends a string to the reference namespace.
+	NamespaceMappingSuffix NamespaceMappingMode = "suffix"
+	// NamespaceMappingTemplate renders a text/template with the Namespace and
+	// Cluster of the reference Widget.
+	NamespaceMappingTemplate NamespaceMappingMode = "template"
+)
+
+// NamespaceMapping maps the namespace of a reference Widget to the namespace of
+// its mirrored copy. The zero value is the identity mapping.
+//
+// Mirrored Widgets record the origin of their reference Widget in annotations,
+// so every mapping can be reversed, including fixed and template mappings that
+// are not injective.
+type NamespaceMapping struct {
+	Mode NamespaceMappingMode
+	// Value is the fixed namespace, prefix, suffix or template text, depending
+	// on Mode.
+	Value string
+
+	tmpl *template.Template
+}
+
+// namespaceTemplateData is passed to namespace templates.
+type namespaceTemplateData struct {
+	// Namespace is the namespace of the reference Widget.
+	Namespace string
+	// Cluster is the kcp logical cluster of the reference Widget, such as
+	// root:org:workspace. It is empty outside of kcp.
+	Cluster string
+}
+
+var invalidNamespaceChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+var namespaceTemplateFuncs = template.FuncMap{
+	// dns lowercases s and replaces every run of characters that are not
+	// allowed in a namespace, such as the colons of a logical cluster name,
+	// with a dash.
+	"dns": func(s string) string {
+		return strings.Trim(invalidNamespaceChars.ReplaceAllString(strings.ToLower(s), "-"), "-")
+	},
+}
+
+// ParseNamespaceMapping parses a mapping given as "identity", "fixed:<namespace>",
+// "prefix:<prefix>", "suffix:<suffix>" or "template:<template>", for example
+// "template:{{dns .Cluster}}-{{.Namespace}}". An empty string is the identity
+// mapping.
+func ParseNamespaceMapping(s string) (NamespaceMapping, error) {
+	if s == "" || s == string(NamespaceMappingIdentity) {
+		return NamespaceMapping{Mode: NamespaceMappingIdentity}, nil
+	}
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return NamespaceMapping{}, fmt.Errorf("invalid namespace mapping %q: expected <mode>:<value>", s)
+	}
+	m := NamespaceMapping{Mode: NamespaceMappingMode(parts[0]), Value: parts[1]}
+	switch m.Mode {
+	case NamespaceMappingFixed:
+		if errs := validation.IsDNS1123Label(m.Value); len(errs) > 0 {
+			return NamespaceMapping{}, fmt.Errorf("invalid fixed namespace %q: %s", m.Value, strings.Join(errs, ", "))
+		}
+	case NamespaceMappingPrefix, NamespaceMappingSuffix:
+	case NamespaceMappingTemplate:
+		tmpl, err := template.New("namespace").Funcs(namespaceTemplateFuncs).Option("missingkey=error").Parse(m.Value)
+		if err != nil {
+			return NamespaceMapping{}, fmt.Errorf("invalid namespace template: %w", err)
+		}
+		m.tmpl = tmpl
+	default:
+		return NamespaceMapping{}, fmt.Errorf("unknown namespace mapping mode %q", parts[0])
+	}
+	return m, nil
+}
+
+// String returns m in the form accepted by ParseNamespaceMapping.
+func (m NamespaceMapping) String() string {
+	if m.Mode == "" || m.Mode == NamespaceMappingIdentity {
+		return string(NamespaceMappingIdentity)
+	}
+	return string(m.Mode) + ":" + m.Value
+}
+
+// Map returns the mirror namespace for a reference Widget in namespace of the
+// logical cluster cluster.
+func (m NamespaceMapping) Map(cluster logicalcluster.Name, namespace string) (string, error) {
+	var mapped string
+	switch m.Mode {
+	case NamespaceMappingFixed:
+		mapped = m.Value
+	case NamespaceMappingPrefix:
+		mapped = m.Value + namespace
+	case NamespaceMappingSuffix:
+		mapped = namespace + m.Value
+	case NamespaceMappingTemplate:
+		var buf bytes.Buffer
+		if err := m.tmpl.Execute(&buf, namespaceTemplateData{Namespace: namespace, Cluster: cluster.String()}); err != nil {
+			return "", fmt.Errorf("error rendering namespace template: %w", err)
+		}
+		mapped = buf.String()
+	default:
+		return namespace, nil
+	}
+	if errs := validation.IsDNS1123Label(mapped); len(errs) > 0 {
+		return "", fmt.Errorf("namespace mapping %s maps %q to invalid namespace %q: %s", m, namespace, mapped, strings.Join(errs, ", "))
+	}
+	return mapped, nil
+}
+
+// mirrorKey returns the key of the copy of reference mirrored into target.
+func mirrorKey(target MirrorTarget, reference client.Object) (client.ObjectKey, error) {
+	namespace, err := target.NamespaceMapping.Map(logicalcluster.From(reference), reference.GetNamespace())
+	if err != nil {
+		return client.ObjectKey{}, err
+	}
+	return types.NamespacedName{Namespace: namespace, Name: reference.GetName()}, nil
+}
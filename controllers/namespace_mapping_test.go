@@ -0,0 +1,57 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"github.com/kcp-dev/logicalcluster/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NamespaceMapping", func() {
+	DescribeTable("maps reference namespaces",
+		func(mapping, cluster, namespace, expected string) {
+			m, err := ParseNamespaceMapping(mapping)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(m.Map(logicalcluster.New(cluster), namespace)).To(Equal(expected))
+		},
+		Entry("empty is identity", "", "", "default", "default"),
+		Entry("identity", "identity", "root:org:ws", "default", "default"),
+		Entry("fixed", "fixed:mirror", "", "default", "mirror"),
+		Entry("prefix", "prefix:team-", "", "default", "team-default"),
+		Entry("suffix", "suffix:-mirror", "", "default", "default-mirror"),
+		Entry("template with logical cluster", "template:{{dns .Cluster}}-{{.Namespace}}", "root:org:ws", "default", "root-org-ws-default"),
+	)
+
+	DescribeTable("rejects invalid mappings",
+		func(mapping string) {
+			_, err := ParseNamespaceMapping(mapping)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("unknown mode", "reverse:x"),
+		Entry("missing value", "prefix:"),
+		Entry("invalid fixed namespace", "fixed:Not_A_Namespace"),
+		Entry("invalid template", "template:{{.Namespace"),
+	)
+
+	It("rejects mapped namespaces that are not valid", func() {
+		m, err := ParseNamespaceMapping("template:{{.Cluster}}-{{.Namespace}}")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = m.Map(logicalcluster.New("root:org"), "default")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,126 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/kcp-dev/logicalcluster/v2"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
+)
+
+// mirrorAnnotations are set by the controller on mirrored Widgets only.
+var mirrorAnnotations = []string{
+	tutorialkubebuilderiov1alpha1.ContentHashAnnotation,
+	tutorialkubebuilderiov1alpha1.SourceClusterAnnotation,
+	tutorialkubebuilderiov1alpha1.SourceNamespaceAnnotation,
+	tutorialkubebuilderiov1alpha1.SourceNameAnnotation,
+	tutorialkubebuilderiov1alpha1.SourceUIDAnnotation,
+	tutorialkubebuilderiov1alpha1.SourceGenerationAnnotation,
+}
+
+// setOrigin records the origin of reference in the annotations of mirror.
+func setOrigin(mirror, reference client.Object) {
+	annotations := mirror.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	if cluster := logicalcluster.From(reference); !cluster.Empty() {
+		annotations[tutorialkubebuilderiov1alpha1.SourceClusterAnnotation] = cluster.String()
+	}
+	annotations[tutorialkubebuilderiov1alpha1.SourceNamespaceAnnotation] = reference.GetNamespace()
+	annotations[tutorialkubebuilderiov1alpha1.SourceNameAnnotation] = reference.GetName()
+	annotations[tutorialkubebuilderiov1alpha1.SourceUIDAnnotation] = string(reference.GetUID())
+	annotations[tutorialkubebuilderiov1alpha1.SourceGenerationAnnotation] = strconv.FormatInt(reference.GetGeneration(), 10)
+	mirror.SetAnnotations(annotations)
+}
+
+// referenceRequest returns the request for the reference object that mirror
+// was copied from. Mirrored objects written before origin annotations were
+// recorded are assumed to share the name and namespace of their reference
+// object.
+func referenceRequest(mirror client.Object) reconcile.Request {
+	annotations := mirror.GetAnnotations()
+	req := reconcile.Request{
+		ClusterName:    annotations[tutorialkubebuilderiov1alpha1.SourceClusterAnnotation],
+		NamespacedName: client.ObjectKeyFromObject(mirror),
+	}
+	if namespace, ok := annotations[tutorialkubebuilderiov1alpha1.SourceNamespaceAnnotation]; ok {
+		req.Namespace = namespace
+	}
+	if name, ok := annotations[tutorialkubebuilderiov1alpha1.SourceNameAnnotation]; ok {
+		req.Name = name
+	}
+	return req
+}
+
+// checkOrigin returns an error if mirror was copied from an object other than
+// reference. A mirror copied from an earlier object with the same cluster,
+// namespace and name as reference, which was since deleted and recreated, does
+// not belong to reference either: it was left behind by the deletion policy
+// or an unreachable target, and is released by the MirrorGarbageCollector.
+// Mirrors written before the UID was recorded belong to reference.
+func checkOrigin(mirror, reference client.Object) error {
+	if _, ok := mirror.GetAnnotations()[tutorialkubebuilderiov1alpha1.SourceNameAnnotation]; !ok {
+		return nil
+	}
+	origin := referenceRequest(mirror)
+	expected := reconcile.Request{
+		ClusterName: logicalcluster.From(reference).String(),
+		NamespacedName: types.NamespacedName{
+			Namespace: reference.GetNamespace(),
+			Name:      reference.GetName(),
+		},
+	}
+	if origin != expected {
+		return fmt.Errorf("%s %s is mirrored from %s", mirror.GetNamespace(), mirror.GetName(), formatRequest(origin))
+	}
+	if recreated(mirror, reference) {
+		return fmt.Errorf("%s %s is mirrored from an earlier %s with UID %s", mirror.GetNamespace(), mirror.GetName(),
+			formatRequest(origin), mirror.GetAnnotations()[tutorialkubebuilderiov1alpha1.SourceUIDAnnotation])
+	}
+	return nil
+}
+
+// recreated reports whether mirror was copied from another object than
+// reference according to their UIDs, i.e. from an earlier object of the same
+// name that was deleted since.
+func recreated(mirror, reference client.Object) bool {
+	uid := types.UID(mirror.GetAnnotations()[tutorialkubebuilderiov1alpha1.SourceUIDAnnotation])
+	return uid != "" && reference.GetUID() != "" && uid != reference.GetUID()
+}
+
+func formatRequest(req reconcile.Request) string {
+	if req.ClusterName == "" {
+		return req.NamespacedName.String()
+	}
+	return req.ClusterName + "|" + req.NamespacedName.String()
+}
+
+// MirrorEventHandler enqueues the reference object of a mirrored object, as
+// recorded in the origin annotations of the mirrored object.
+func MirrorEventHandler() handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+		return []reconcile.Request{referenceRequest(obj)}
+	})
+}
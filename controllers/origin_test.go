@@ -0,0 +1,107 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"github.com/kcp-dev/logicalcluster/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
+)
+
+var _ = Describe("Origin", func() {
+	var reference *tutorialkubebuilderiov1alpha1.Widget
+
+	// mirrorOf returns the mirror of reference under mirror/widget in the
+	// mirror cluster, stamped with the origin of reference.
+	mirrorOf := func(reference client.Object) *tutorialkubebuilderiov1alpha1.Widget {
+		mirror := &tutorialkubebuilderiov1alpha1.Widget{ObjectMeta: metav1.ObjectMeta{Name: "widget", Namespace: "mirror"}}
+		setOrigin(mirror, reference)
+		return mirror
+	}
+
+	BeforeEach(func() {
+		reference = &tutorialkubebuilderiov1alpha1.Widget{ObjectMeta: metav1.ObjectMeta{
+			Name:        "widget",
+			Namespace:   "default",
+			UID:         types.UID("first"),
+			Annotations: map[string]string{logicalcluster.AnnotationKey: "root:east"},
+		}}
+	})
+
+	It("requests the reference object recorded in the origin annotations", func() {
+		Expect(referenceRequest(mirrorOf(reference))).To(Equal(reconcile.Request{
+			ClusterName:    "root:east",
+			NamespacedName: types.NamespacedName{Namespace: "default", Name: "widget"},
+		}))
+	})
+
+	It("requests the object of the same name for mirrors without origin annotations", func() {
+		legacy := &tutorialkubebuilderiov1alpha1.Widget{ObjectMeta: metav1.ObjectMeta{Name: "widget", Namespace: "mirror"}}
+		Expect(referenceRequest(legacy)).To(Equal(reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: "mirror", Name: "widget"},
+		}))
+	})
+
+	It("accepts mirrors of the reference object", func() {
+		Expect(checkOrigin(mirrorOf(reference), reference)).To(Succeed())
+
+		legacy := &tutorialkubebuilderiov1alpha1.Widget{ObjectMeta: metav1.ObjectMeta{Name: "widget", Namespace: "mirror"}}
+		Expect(checkOrigin(legacy, reference)).To(Succeed())
+
+		// Mirrors written before the UID was recorded.
+		mirror := mirrorOf(reference)
+		delete(mirror.Annotations, tutorialkubebuilderiov1alpha1.SourceUIDAnnotation)
+		Expect(checkOrigin(mirror, reference)).To(Succeed())
+	})
+
+	It("rejects mirrors of other objects", func() {
+		other := reference.DeepCopy()
+		other.Name = "other"
+		Expect(checkOrigin(mirrorOf(other), reference)).To(MatchError(ContainSubstring("is mirrored from root:east|default/other")))
+
+		other = reference.DeepCopy()
+		other.Annotations[logicalcluster.AnnotationKey] = "root:west"
+		Expect(checkOrigin(mirrorOf(other), reference)).To(MatchError(ContainSubstring("root:west|default/widget")))
+	})
+
+	It("rejects mirrors of an earlier object of the same name", func() {
+		mirror := mirrorOf(reference)
+		recreated := reference.DeepCopy()
+		recreated.UID = types.UID("second")
+		Expect(checkOrigin(mirror, recreated)).To(MatchError(ContainSubstring("earlier root:east|default/widget with UID first")))
+	})
+
+	It("enqueues the reference object of mirror events", func() {
+		queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+		defer queue.ShutDown()
+		MirrorEventHandler().Create(event.CreateEvent{Object: mirrorOf(reference)}, queue)
+		Expect(queue.Len()).To(Equal(1))
+		item, _ := queue.Get()
+		Expect(item).To(Equal(reconcile.Request{
+			ClusterName:    "root:east",
+			NamespacedName: types.NamespacedName{Namespace: "default", Name: "widget"},
+		}))
+	})
+})
@@ -0,0 +1,628 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kcp-dev/logicalcluster/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// shardedCacheShards counts the shards served by ShardedCaches.
+var shardedCacheShards = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "sharded_cache_shards",
+	Help: "Number of kcp shards whose virtual workspace is cached.",
+})
+
+func init() {
+	metrics.Registry.MustRegister(shardedCacheShards)
+}
+
+// NewShardFunc creates the cache of the shard reached at url, and the client
+// writing to the shard and reading from that cache.
+type NewShardFunc func(url string) (cache.Cache, client.Client, error)
+
+// ShardedCache is a cache.Cache over one cluster-aware cache per kcp shard,
+// each serving the logical clusters on its shard, typically through the
+// virtual workspace of an APIExport on the shard. The informers of the
+// ShardedCache feed the events of every shard, including the shards added
+// later, to their handlers, so that the controllers of a manager using it
+// reconcile the objects of every shard. Reads are served by the shard of the
+// logical cluster in the context, and merged from every shard without one.
+type ShardedCache struct {
+	scheme   *runtime.Scheme
+	mapper   meta.RESTMapper
+	newShard NewShardFunc
+
+	mu sync.Mutex
+	// ctx is set once the ShardedCache is started, and started closed.
+	ctx     context.Context
+	started chan struct{}
+	urls    []string
+	shards  map[string]*shard
+	// clusters are the logical clusters seen on each shard.
+	clusters  map[logicalcluster.Name]*shard
+	informers map[informerKey]*shardedInformer
+	indexes   []fieldIndex
+}
+
+// shard is a shard of a ShardedCache.
+type shard struct {
+	url    string
+	cache  cache.Cache
+	client client.Client
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type informerKey struct {
+	gvk          schema.GroupVersionKind
+	unstructured bool
+}
+
+type fieldIndex struct {
+	obj          client.Object
+	field        string
+	extractValue client.IndexerFunc
+}
+
+// NewShardedCache returns a ShardedCache using the scheme and mapper of opts,
+// creating the cache and client of each shard with newShard. Its shards are
+// set with SetShards.
+func NewShardedCache(opts cache.Options, newShard NewShardFunc) *ShardedCache {
+	return &ShardedCache{
+		scheme:    opts.Scheme,
+		mapper:    opts.Mapper,
+		newShard:  newShard,
+		started:   make(chan struct{}),
+		shards:    map[string]*shard{},
+		clusters:  map[logicalcluster.Name]*shard{},
+		informers: map[informerKey]*shardedInformer{},
+	}
+}
+
+// SetShards sets the URLs of the shards, adding the shards that are new and
+// stopping the others. Before c is started, the shards are only recorded.
+func (c *ShardedCache) SetShards(urls []string) error {
+	if len(urls) == 0 {
+		return errors.New("at least one shard is required")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.urls = append([]string(nil), urls...)
+	if c.ctx == nil {
+		return nil
+	}
+	return c.syncShards()
+}
+
+// syncShards adds and removes shards to match c.urls. c.mu must be held.
+func (c *ShardedCache) syncShards() error {
+	logger := log.FromContext(c.ctx)
+	wanted := map[string]bool{}
+	for _, url := range c.urls {
+		wanted[url] = true
+	}
+	for url, s := range c.shards {
+		if wanted[url] {
+			continue
+		}
+		logger.Info("Removing shard", "url", url)
+		s.cancel()
+		delete(c.shards, url)
+		for _, i := range c.informers {
+			i.removeShard(s)
+		}
+		for cluster, owner := range c.clusters {
+			if owner == s {
+				delete(c.clusters, cluster)
+			}
+		}
+	}
+	var errs []error
+	for _, url := range c.urls {
+		if _, ok := c.shards[url]; ok {
+			continue
+		}
+		logger.Info("Adding shard", "url", url)
+		if err := c.addShard(url); err != nil {
+			errs = append(errs, fmt.Errorf("unable to add shard %s: %w", url, err))
+		}
+	}
+	shardedCacheShards.Set(float64(len(c.shards)))
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
+
+// addShard creates the shard reached at url with the indexes and informers of
+// c, then starts it. c.mu must be held.
+func (c *ShardedCache) addShard(url string) error {
+	shardCache, shardClient, err := c.newShard(url)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(c.ctx)
+	s := &shard{url: url, cache: shardCache, client: shardClient, ctx: ctx, cancel: cancel}
+	// The shard is not started yet, so none of this blocks.
+	for _, index := range c.indexes {
+		if err := shardCache.IndexField(ctx, index.obj, index.field, index.extractValue); err != nil {
+			cancel()
+			return err
+		}
+	}
+	for _, i := range c.informers {
+		informer, err := i.get(ctx, shardCache)
+		if err != nil {
+			cancel()
+			return err
+		}
+		if err := i.addShard(s, informer); err != nil {
+			cancel()
+			return err
+		}
+	}
+	c.shards[url] = s
+	go func() {
+		if err := shardCache.Start(ctx); err != nil {
+			log.FromContext(ctx).Error(err, "Shard stopped", "url", url)
+		}
+	}()
+	return nil
+}
+
+// Start starts the shards and runs them until ctx is done.
+func (c *ShardedCache) Start(ctx context.Context) error {
+	c.mu.Lock()
+	if c.ctx != nil {
+		c.mu.Unlock()
+		return errors.New("sharded cache already started")
+	}
+	c.ctx = ctx
+	err := c.syncShards()
+	close(c.started)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	<-ctx.Done()
+	return nil
+}
+
+// WaitForCacheSync waits for every shard to sync.
+func (c *ShardedCache) WaitForCacheSync(ctx context.Context) bool {
+	select {
+	case <-c.started:
+	case <-ctx.Done():
+		return false
+	}
+	for _, s := range c.shardList() {
+		if !s.cache.WaitForCacheSync(ctx) {
+			return false
+		}
+	}
+	return true
+}
+
+// GetInformer returns an informer fed by the informers of every shard for the
+// kind of obj.
+func (c *ShardedCache) GetInformer(ctx context.Context, obj client.Object) (cache.Informer, error) {
+	gvk, err := apiutil.GVKForObject(obj, c.scheme)
+	if err != nil {
+		return nil, err
+	}
+	_, isUnstructured := obj.(*unstructured.Unstructured)
+	return c.informer(ctx, informerKey{gvk: gvk, unstructured: isUnstructured}, func(ctx context.Context, shardCache cache.Cache) (cache.Informer, error) {
+		return shardCache.GetInformer(ctx, obj)
+	})
+}
+
+// GetInformerForKind returns an informer fed by the informers of every shard
+// for gvk.
+func (c *ShardedCache) GetInformerForKind(ctx context.Context, gvk schema.GroupVersionKind) (cache.Informer, error) {
+	return c.informer(ctx, informerKey{gvk: gvk}, func(ctx context.Context, shardCache cache.Cache) (cache.Informer, error) {
+		return shardCache.GetInformerForKind(ctx, gvk)
+	})
+}
+
+func (c *ShardedCache) informer(ctx context.Context, key informerKey, get func(context.Context, cache.Cache) (cache.Informer, error)) (cache.Informer, error) {
+	c.mu.Lock()
+	i, ok := c.informers[key]
+	if !ok {
+		i = &shardedInformer{get: get, cache: c, informers: map[*shard]cache.Informer{}}
+		// Recorded first, so that shards added from now on get it.
+		c.informers[key] = i
+	}
+	shards := c.shardListLocked()
+	c.mu.Unlock()
+
+	for _, s := range shards {
+		if i.hasShard(s) {
+			continue
+		}
+		// Blocks until the informer of a started shard is synced.
+		informer, err := get(ctx, s.cache)
+		if err != nil {
+			return nil, err
+		}
+		if err := i.addShard(s, informer); err != nil {
+			return nil, err
+		}
+	}
+	return i, nil
+}
+
+// IndexField adds an index to the caches of every shard.
+func (c *ShardedCache) IndexField(ctx context.Context, obj client.Object, field string, extractValue client.IndexerFunc) error {
+	c.mu.Lock()
+	c.indexes = append(c.indexes, fieldIndex{obj: obj, field: field, extractValue: extractValue})
+	shards := c.shardListLocked()
+	c.mu.Unlock()
+	for _, s := range shards {
+		if err := s.cache.IndexField(ctx, obj, field, extractValue); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get reads obj from the shard of the logical cluster in ctx.
+func (c *ShardedCache) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	s, err := c.shardFor(ctx)
+	if err != nil {
+		return err
+	}
+	return s.cache.Get(ctx, key, obj)
+}
+
+// List lists the objects of the logical cluster in ctx from its shard, or the
+// objects of every logical cluster from every shard.
+func (c *ShardedCache) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	return c.list(ctx, list, opts, func(s *shard) client.Reader {
+		return s.cache
+	})
+}
+
+// Client returns a client reading from and writing to the shard of the logical
+// cluster in the context.
+func (c *ShardedCache) Client() client.Client {
+	return &shardedClient{cache: c}
+}
+
+func (c *ShardedCache) list(ctx context.Context, list client.ObjectList, opts []client.ListOption, reader func(*shard) client.Reader) error {
+	if _, ok := contextCluster(ctx); ok || len(c.shardList()) == 1 {
+		s, err := c.shardFor(ctx)
+		if err != nil {
+			return err
+		}
+		return reader(s).List(ctx, list, opts...)
+	}
+
+	listOpts := (&client.ListOptions{}).ApplyOptions(opts)
+	if listOpts.Limit > 0 || listOpts.Continue != "" {
+		return errors.New("paginated lists are not supported across shards")
+	}
+	var items []runtime.Object
+	for _, s := range c.shardList() {
+		shardList, ok := list.DeepCopyObject().(client.ObjectList)
+		if !ok {
+			return fmt.Errorf("unable to copy list %T", list)
+		}
+		if err := reader(s).List(ctx, shardList, opts...); err != nil {
+			return fmt.Errorf("shard %s: %w", s.url, err)
+		}
+		shardItems, err := meta.ExtractList(shardList)
+		if err != nil {
+			return err
+		}
+		items = append(items, shardItems...)
+	}
+	return meta.SetList(list, items)
+}
+
+// shardFor returns the shard of the logical cluster in ctx, which must have
+// been seen by an informer of c, or the only shard.
+func (c *ShardedCache) shardFor(ctx context.Context) (*shard, error) {
+	cluster, ok := contextCluster(ctx)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s := c.clusters[cluster]; ok && s != nil {
+		return s, nil
+	}
+	if len(c.shards) == 1 {
+		for _, s := range c.shards {
+			return s, nil
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("no logical cluster in the context to choose among %d shards", len(c.shards))
+	}
+	return nil, fmt.Errorf("logical cluster %s was not seen on any shard", cluster)
+}
+
+// observe records that obj was seen on s.
+func (c *ShardedCache) observe(s *shard, obj interface{}) {
+	o, ok := obj.(logicalcluster.Object)
+	if !ok {
+		return
+	}
+	cluster := logicalcluster.From(o)
+	if cluster.Empty() {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s.ctx.Err() == nil {
+		c.clusters[cluster] = s
+	}
+}
+
+func (c *ShardedCache) shardList() []*shard {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.shardListLocked()
+}
+
+func (c *ShardedCache) shardListLocked() []*shard {
+	shards := make([]*shard, 0, len(c.shards))
+	for _, s := range c.shards {
+		shards = append(shards, s)
+	}
+	sort.Slice(shards, func(i, j int) bool { return shards[i].url < shards[j].url })
+	return shards
+}
+
+// contextCluster returns the logical cluster of ctx, if any.
+func contextCluster(ctx context.Context) (logicalcluster.Name, bool) {
+	cluster, ok := logicalcluster.ClusterFromContext(ctx)
+	return cluster, ok && !cluster.Empty()
+}
+
+// shardedInformer is an informer of a ShardedCache, fed by an informer per
+// shard.
+type shardedInformer struct {
+	get   func(context.Context, cache.Cache) (cache.Informer, error)
+	cache *ShardedCache
+
+	mu        sync.Mutex
+	handlers  []shardedHandler
+	indexers  []toolscache.Indexers
+	informers map[*shard]cache.Informer
+}
+
+type shardedHandler struct {
+	handler toolscache.ResourceEventHandler
+	// resyncPeriod is used unless zero.
+	resyncPeriod time.Duration
+}
+
+func (i *shardedInformer) AddEventHandler(handler toolscache.ResourceEventHandler) {
+	i.addHandler(shardedHandler{handler: handler})
+}
+
+func (i *shardedInformer) AddEventHandlerWithResyncPeriod(handler toolscache.ResourceEventHandler, resyncPeriod time.Duration) {
+	i.addHandler(shardedHandler{handler: handler, resyncPeriod: resyncPeriod})
+}
+
+func (i *shardedInformer) addHandler(h shardedHandler) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.handlers = append(i.handlers, h)
+	for s, informer := range i.informers {
+		i.register(s, informer, h)
+	}
+}
+
+// register adds h to the informer of s. The handler records the logical
+// cluster of the objects before handling them, so that the reconcilers they
+// trigger find the shard of their logical cluster, and drops the events still
+// delivered once s is removed.
+func (i *shardedInformer) register(s *shard, informer cache.Informer, h shardedHandler) {
+	handler := toolscache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if s.ctx.Err() != nil {
+				return
+			}
+			i.cache.observe(s, obj)
+			h.handler.OnAdd(obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if s.ctx.Err() != nil {
+				return
+			}
+			i.cache.observe(s, newObj)
+			h.handler.OnUpdate(oldObj, newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if s.ctx.Err() != nil {
+				return
+			}
+			h.handler.OnDelete(obj)
+		},
+	}
+	if h.resyncPeriod != 0 {
+		informer.AddEventHandlerWithResyncPeriod(handler, h.resyncPeriod)
+		return
+	}
+	informer.AddEventHandler(handler)
+}
+
+func (i *shardedInformer) AddIndexers(indexers toolscache.Indexers) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.indexers = append(i.indexers, indexers)
+	for _, informer := range i.informers {
+		if err := informer.AddIndexers(indexers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HasSynced reports whether the informers of every shard are synced.
+func (i *shardedInformer) HasSynced() bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for _, informer := range i.informers {
+		if !informer.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+func (i *shardedInformer) hasShard(s *shard) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	_, ok := i.informers[s]
+	return ok
+}
+
+// addShard hands the handlers and indexers of i to the informer of s.
+func (i *shardedInformer) addShard(s *shard, informer cache.Informer) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if _, ok := i.informers[s]; ok || s.ctx.Err() != nil {
+		return nil
+	}
+	for _, indexers := range i.indexers {
+		if err := informer.AddIndexers(indexers); err != nil {
+			return err
+		}
+	}
+	for _, h := range i.handlers {
+		i.register(s, informer, h)
+	}
+	i.informers[s] = informer
+	return nil
+}
+
+func (i *shardedInformer) removeShard(s *shard) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	delete(i.informers, s)
+}
+
+// shardedClient is the client of a ShardedCache.
+type shardedClient struct {
+	cache *ShardedCache
+}
+
+func (c *shardedClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	s, err := c.cache.shardFor(ctx)
+	if err != nil {
+		return err
+	}
+	return s.client.Get(ctx, key, obj)
+}
+
+func (c *shardedClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	return c.cache.list(ctx, list, opts, func(s *shard) client.Reader {
+		return s.client
+	})
+}
+
+func (c *shardedClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	s, err := c.cache.shardFor(ctx)
+	if err != nil {
+		return err
+	}
+	return s.client.Create(ctx, obj, opts...)
+}
+
+func (c *shardedClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	s, err := c.cache.shardFor(ctx)
+	if err != nil {
+		return err
+	}
+	return s.client.Delete(ctx, obj, opts...)
+}
+
+func (c *shardedClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	s, err := c.cache.shardFor(ctx)
+	if err != nil {
+		return err
+	}
+	return s.client.Update(ctx, obj, opts...)
+}
+
+func (c *shardedClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	s, err := c.cache.shardFor(ctx)
+	if err != nil {
+		return err
+	}
+	return s.client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *shardedClient) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	s, err := c.cache.shardFor(ctx)
+	if err != nil {
+		return err
+	}
+	return s.client.DeleteAllOf(ctx, obj, opts...)
+}
+
+func (c *shardedClient) Status() client.StatusWriter {
+	return &shardedStatusWriter{cache: c.cache}
+}
+
+func (c *shardedClient) Scheme() *runtime.Scheme {
+	return c.cache.scheme
+}
+
+func (c *shardedClient) RESTMapper() meta.RESTMapper {
+	return c.cache.mapper
+}
+
+// shardedStatusWriter writes the status of objects to the shard of their
+// logical cluster.
+type shardedStatusWriter struct {
+	cache *ShardedCache
+}
+
+func (w *shardedStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	s, err := w.cache.shardFor(ctx)
+	if err != nil {
+		return err
+	}
+	return s.client.Status().Update(ctx, obj, opts...)
+}
+
+func (w *shardedStatusWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	s, err := w.cache.shardFor(ctx)
+	if err != nil {
+		return err
+	}
+	return s.client.Status().Patch(ctx, obj, patch, opts...)
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sync"
+
+	"github.com/kcp-dev/logicalcluster/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache/informertest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
+)
+
+var _ = Describe("ShardedCache", func() {
+	var (
+		ctx     context.Context
+		cancel  context.CancelFunc
+		scheme  *runtime.Scheme
+		sharded *ShardedCache
+		caches  map[string]*informertest.FakeInformers
+		clients map[string]client.Client
+		done    chan error
+		mu      sync.Mutex
+		added   []string
+	)
+
+	widget := func(cluster, name string) *tutorialkubebuilderiov1alpha1.Widget {
+		return &tutorialkubebuilderiov1alpha1.Widget{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Annotations: map[string]string{logicalcluster.AnnotationKey: cluster},
+		}}
+	}
+	// emit adds obj to the widget informer of the shard reached at url.
+	emit := func(url string, obj *tutorialkubebuilderiov1alpha1.Widget) {
+		informer, err := caches[url].FakeInformerFor(&tutorialkubebuilderiov1alpha1.Widget{})
+		Expect(err).NotTo(HaveOccurred())
+		informer.Add(obj)
+	}
+	addedWidgets := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), added...)
+	}
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		scheme = runtime.NewScheme()
+		Expect(tutorialkubebuilderiov1alpha1.AddToScheme(scheme)).To(Succeed())
+		caches = map[string]*informertest.FakeInformers{}
+		clients = map[string]client.Client{}
+		added = nil
+		sharded = NewShardedCache(cache.Options{Scheme: scheme}, func(url string) (cache.Cache, client.Client, error) {
+			caches[url] = &informertest.FakeInformers{Scheme: scheme}
+			clients[url] = fake.NewClientBuilder().WithScheme(scheme).Build()
+			return caches[url], clients[url], nil
+		})
+		Expect(sharded.SetShards([]string{"https://shard-1"})).To(Succeed())
+
+		informer, err := sharded.GetInformer(ctx, &tutorialkubebuilderiov1alpha1.Widget{})
+		Expect(err).NotTo(HaveOccurred())
+		informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				mu.Lock()
+				defer mu.Unlock()
+				added = append(added, obj.(client.Object).GetName())
+			},
+		})
+
+		done = make(chan error)
+		go func() { done <- sharded.Start(ctx) }()
+		Expect(sharded.WaitForCacheSync(ctx)).To(BeTrue())
+	})
+
+	AfterEach(func() {
+		cancel()
+		Eventually(done).Should(Receive(BeNil()))
+	})
+
+	It("feeds the events of every shard to the handlers", func() {
+		Expect(sharded.SetShards([]string{"https://shard-1", "https://shard-2"})).To(Succeed())
+
+		emit("https://shard-1", widget("root:east", "first"))
+		emit("https://shard-2", widget("root:west", "second"))
+		Expect(addedWidgets()).To(Equal([]string{"first", "second"}))
+	})
+
+	It("stops feeding the events of removed shards", func() {
+		Expect(sharded.SetShards([]string{"https://shard-1", "https://shard-2"})).To(Succeed())
+		Expect(sharded.SetShards([]string{"https://shard-2"})).To(Succeed())
+
+		emit("https://shard-1", widget("root:east", "removed"))
+		emit("https://shard-2", widget("root:west", "kept"))
+		Expect(addedWidgets()).To(Equal([]string{"kept"}))
+	})
+
+	It("rejects an empty list of shards", func() {
+		Expect(sharded.SetShards(nil)).To(HaveOccurred())
+	})
+
+	It("writes to the shard of the logical cluster in the context", func() {
+		Expect(sharded.SetShards([]string{"https://shard-1", "https://shard-2"})).To(Succeed())
+		emit("https://shard-1", widget("root:east", "first"))
+		emit("https://shard-2", widget("root:west", "second"))
+
+		westCtx := logicalcluster.WithCluster(ctx, logicalcluster.New("root:west"))
+		Expect(sharded.Client().Create(westCtx, widget("root:west", "created"))).To(Succeed())
+
+		key := client.ObjectKey{Namespace: "default", Name: "created"}
+		Expect(clients["https://shard-2"].Get(ctx, key, &tutorialkubebuilderiov1alpha1.Widget{})).To(Succeed())
+		Expect(clients["https://shard-1"].Get(ctx, key, &tutorialkubebuilderiov1alpha1.Widget{})).NotTo(Succeed())
+
+		unknownCtx := logicalcluster.WithCluster(ctx, logicalcluster.New("root:north"))
+		Expect(sharded.Client().Create(unknownCtx, widget("root:north", "lost"))).
+			To(MatchError(ContainSubstring("not seen on any shard")))
+	})
+
+	It("lists the objects of every shard without a logical cluster in the context", func() {
+		Expect(sharded.SetShards([]string{"https://shard-1", "https://shard-2"})).To(Succeed())
+		Expect(clients["https://shard-1"].Create(ctx, widget("root:east", "first"))).To(Succeed())
+		Expect(clients["https://shard-2"].Create(ctx, widget("root:west", "second"))).To(Succeed())
+
+		var widgets tutorialkubebuilderiov1alpha1.WidgetList
+		Expect(sharded.Client().List(ctx, &widgets)).To(Succeed())
+		Expect(widgets.Items).To(HaveLen(2))
+
+		Expect(sharded.Client().List(ctx, &widgets, client.Limit(1))).To(HaveOccurred())
+	})
+
+	It("uses the only shard for logical clusters it has not seen", func() {
+		eastCtx := logicalcluster.WithCluster(ctx, logicalcluster.New("root:east"))
+		Expect(sharded.Client().Create(eastCtx, widget("root:east", "created"))).To(Succeed())
+		Expect(clients["https://shard-1"].Get(ctx, client.ObjectKey{Namespace: "default", Name: "created"},
+			&tutorialkubebuilderiov1alpha1.Widget{})).To(Succeed())
+	})
+})
@@ -0,0 +1,121 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/kcp-dev/logicalcluster/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ShardedEventRecorders provides the event recorders of a manager using a
+// ShardedCache. The events about an object are written to its logical cluster,
+// through a client routing them to the shard of the cluster such as that of
+// the ShardedCache, rather than to the config the manager was created with.
+type ShardedEventRecorders struct {
+	scheme      *runtime.Scheme
+	broadcaster record.EventBroadcaster
+}
+
+// NewShardedEventRecorders returns ShardedEventRecorders writing events with c.
+// The recording stops once they are started as a runnable of the manager and
+// the manager stops.
+func NewShardedEventRecorders(c client.Client, scheme *runtime.Scheme) *ShardedEventRecorders {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(shardedEventSink{client: c})
+	return &ShardedEventRecorders{scheme: scheme, broadcaster: broadcaster}
+}
+
+// GetEventRecorderFor returns the event recorder of the component name.
+func (r *ShardedEventRecorders) GetEventRecorderFor(name string) record.EventRecorder {
+	return clusterEventRecorder{recorder: r.broadcaster.NewRecorder(r.scheme, corev1.EventSource{Component: name})}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. Events are
+// recorded by every replica.
+func (r *ShardedEventRecorders) NeedLeaderElection() bool {
+	return false
+}
+
+// Start stops recording events once ctx is done.
+func (r *ShardedEventRecorders) Start(ctx context.Context) error {
+	<-ctx.Done()
+	r.broadcaster.Shutdown()
+	return nil
+}
+
+// clusterEventRecorder records the logical cluster of the objects in the
+// annotations of their events, for shardedEventSink to write them there.
+type clusterEventRecorder struct {
+	recorder record.EventRecorder
+}
+
+func (r clusterEventRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	r.AnnotatedEventf(object, nil, eventtype, reason, "%s", message)
+}
+
+func (r clusterEventRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.AnnotatedEventf(object, nil, eventtype, reason, messageFmt, args...)
+}
+
+func (r clusterEventRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	if o, ok := object.(logicalcluster.Object); ok {
+		if cluster := logicalcluster.From(o); !cluster.Empty() {
+			withCluster := map[string]string{logicalcluster.AnnotationKey: cluster.String()}
+			for k, v := range annotations {
+				withCluster[k] = v
+			}
+			annotations = withCluster
+		}
+	}
+	r.recorder.AnnotatedEventf(object, annotations, eventtype, reason, messageFmt, args...)
+}
+
+// shardedEventSink is a record.EventSink writing events to the logical cluster
+// in their annotations.
+type shardedEventSink struct {
+	client client.Client
+}
+
+func (s shardedEventSink) Create(event *corev1.Event) (*corev1.Event, error) {
+	event = event.DeepCopy()
+	return event, s.client.Create(eventContext(event), event)
+}
+
+func (s shardedEventSink) Update(event *corev1.Event) (*corev1.Event, error) {
+	event = event.DeepCopy()
+	return event, s.client.Update(eventContext(event), event)
+}
+
+func (s shardedEventSink) Patch(event *corev1.Event, data []byte) (*corev1.Event, error) {
+	event = event.DeepCopy()
+	return event, s.client.Patch(eventContext(event), event, client.RawPatch(types.StrategicMergePatchType, data))
+}
+
+// eventContext returns a context holding the logical cluster of event, if any.
+func eventContext(event *corev1.Event) context.Context {
+	ctx := context.Background()
+	if cluster := logicalcluster.From(event); !cluster.Empty() {
+		ctx = logicalcluster.WithCluster(ctx, cluster)
+	}
+	return ctx
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/kcp-dev/logicalcluster/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache/informertest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
+)
+
+var _ = Describe("ShardedEventRecorders", func() {
+	It("writes the events about an object to the shard of its logical cluster", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(tutorialkubebuilderiov1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		caches := map[string]*informertest.FakeInformers{}
+		clients := map[string]client.Client{}
+		sharded := NewShardedCache(cache.Options{Scheme: scheme}, func(url string) (cache.Cache, client.Client, error) {
+			caches[url] = &informertest.FakeInformers{Scheme: scheme}
+			clients[url] = fake.NewClientBuilder().WithScheme(scheme).Build()
+			return caches[url], clients[url], nil
+		})
+		Expect(sharded.SetShards([]string{"https://shard-1", "https://shard-2"})).To(Succeed())
+		// The logical clusters of a shard are seen through the handlers of
+		// the informers, as those of the controllers.
+		informer, err := sharded.GetInformer(ctx, &tutorialkubebuilderiov1alpha1.Widget{})
+		Expect(err).NotTo(HaveOccurred())
+		informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{})
+		go func() { _ = sharded.Start(ctx) }()
+		Expect(sharded.WaitForCacheSync(ctx)).To(BeTrue())
+
+		widgets := map[string]*tutorialkubebuilderiov1alpha1.Widget{}
+		for url, cluster := range map[string]string{"https://shard-1": "root:east", "https://shard-2": "root:west"} {
+			widgets[cluster] = &tutorialkubebuilderiov1alpha1.Widget{ObjectMeta: metav1.ObjectMeta{
+				Name:        "widget",
+				Namespace:   "default",
+				UID:         types.UID("uid-" + cluster),
+				Annotations: map[string]string{logicalcluster.AnnotationKey: cluster},
+			}}
+			informer, err := caches[url].FakeInformerFor(&tutorialkubebuilderiov1alpha1.Widget{})
+			Expect(err).NotTo(HaveOccurred())
+			informer.Add(widgets[cluster])
+		}
+
+		events := NewShardedEventRecorders(sharded.Client(), scheme)
+		go func() { _ = events.Start(ctx) }()
+		events.GetEventRecorderFor("test").Eventf(widgets["root:west"], corev1.EventTypeNormal, "Tested", "Tested %s", "west")
+
+		shardEvents := func(url string) func() []corev1.Event {
+			return func() []corev1.Event {
+				var list corev1.EventList
+				Expect(clients[url].List(ctx, &list)).To(Succeed())
+				return list.Items
+			}
+		}
+		Eventually(shardEvents("https://shard-2")).Should(ConsistOf(And(
+			HaveField("Reason", "Tested"),
+			HaveField("Source.Component", "test"),
+			HaveField("ObjectMeta.Annotations", HaveKeyWithValue(logicalcluster.AnnotationKey, "root:west")),
+		)))
+		Expect(shardEvents("https://shard-1")()).To(BeEmpty())
+	})
+})
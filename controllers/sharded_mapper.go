@@ -0,0 +1,177 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// NewShardMapperFunc creates the RESTMapper discovering the APIs served by the
+// shard reached at url.
+type NewShardMapperFunc func(url string) (meta.RESTMapper, error)
+
+// ShardedRESTMapper is a meta.RESTMapper over a RESTMapper per kcp shard. The
+// virtual workspace of an APIExport serves the same APIs on every shard, so a
+// mapping is served by the first shard, in URL order, able to, and keeps being
+// served as shards are added and removed.
+type ShardedRESTMapper struct {
+	newMapper NewShardMapperFunc
+
+	mu      sync.Mutex
+	urls    []string
+	mappers map[string]meta.RESTMapper
+}
+
+var _ meta.RESTMapper = &ShardedRESTMapper{}
+
+// NewShardedRESTMapper returns a ShardedRESTMapper creating the RESTMapper of
+// each shard with newMapper. Its shards are set with SetShards.
+func NewShardedRESTMapper(newMapper NewShardMapperFunc) *ShardedRESTMapper {
+	return &ShardedRESTMapper{
+		newMapper: newMapper,
+		mappers:   map[string]meta.RESTMapper{},
+	}
+}
+
+// SetShards sets the URLs of the shards, creating the RESTMappers of the shards
+// that are new and dropping the others. The shards whose RESTMapper cannot be
+// created are left out until the next call.
+func (m *ShardedRESTMapper) SetShards(urls []string) error {
+	if len(urls) == 0 {
+		return errors.New("at least one shard is required")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mappers := map[string]meta.RESTMapper{}
+	var errs []error
+	for _, url := range urls {
+		if mapper, ok := m.mappers[url]; ok {
+			mappers[url] = mapper
+			continue
+		}
+		mapper, err := m.newMapper(url)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("unable to create the REST mapper of shard %s: %w", url, err))
+			continue
+		}
+		mappers[url] = mapper
+	}
+	m.urls = make([]string, 0, len(mappers))
+	for url := range mappers {
+		m.urls = append(m.urls, url)
+	}
+	sort.Strings(m.urls)
+	m.mappers = mappers
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
+
+// first calls f with the RESTMapper of each shard until it succeeds, returning
+// the error of the first shard otherwise.
+func (m *ShardedRESTMapper) first(f func(meta.RESTMapper) error) error {
+	m.mu.Lock()
+	mappers := make([]meta.RESTMapper, 0, len(m.urls))
+	for _, url := range m.urls {
+		mappers = append(mappers, m.mappers[url])
+	}
+	m.mu.Unlock()
+	if len(mappers) == 0 {
+		return errors.New("no shard to discover APIs from")
+	}
+	var firstErr error
+	for _, mapper := range mappers {
+		err := f(mapper)
+		if err == nil {
+			return nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// KindFor implements meta.RESTMapper.
+func (m *ShardedRESTMapper) KindFor(resource schema.GroupVersionResource) (gvk schema.GroupVersionKind, err error) {
+	err = m.first(func(mapper meta.RESTMapper) error {
+		gvk, err = mapper.KindFor(resource)
+		return err
+	})
+	return gvk, err
+}
+
+// KindsFor implements meta.RESTMapper.
+func (m *ShardedRESTMapper) KindsFor(resource schema.GroupVersionResource) (gvks []schema.GroupVersionKind, err error) {
+	err = m.first(func(mapper meta.RESTMapper) error {
+		gvks, err = mapper.KindsFor(resource)
+		return err
+	})
+	return gvks, err
+}
+
+// ResourceFor implements meta.RESTMapper.
+func (m *ShardedRESTMapper) ResourceFor(input schema.GroupVersionResource) (gvr schema.GroupVersionResource, err error) {
+	err = m.first(func(mapper meta.RESTMapper) error {
+		gvr, err = mapper.ResourceFor(input)
+		return err
+	})
+	return gvr, err
+}
+
+// ResourcesFor implements meta.RESTMapper.
+func (m *ShardedRESTMapper) ResourcesFor(input schema.GroupVersionResource) (gvrs []schema.GroupVersionResource, err error) {
+	err = m.first(func(mapper meta.RESTMapper) error {
+		gvrs, err = mapper.ResourcesFor(input)
+		return err
+	})
+	return gvrs, err
+}
+
+// RESTMapping implements meta.RESTMapper.
+func (m *ShardedRESTMapper) RESTMapping(gk schema.GroupKind, versions ...string) (mapping *meta.RESTMapping, err error) {
+	err = m.first(func(mapper meta.RESTMapper) error {
+		mapping, err = mapper.RESTMapping(gk, versions...)
+		return err
+	})
+	return mapping, err
+}
+
+// RESTMappings implements meta.RESTMapper.
+func (m *ShardedRESTMapper) RESTMappings(gk schema.GroupKind, versions ...string) (mappings []*meta.RESTMapping, err error) {
+	err = m.first(func(mapper meta.RESTMapper) error {
+		mappings, err = mapper.RESTMappings(gk, versions...)
+		return err
+	})
+	return mappings, err
+}
+
+// ResourceSingularizer implements meta.RESTMapper.
+func (m *ShardedRESTMapper) ResourceSingularizer(resource string) (singular string, err error) {
+	err = m.first(func(mapper meta.RESTMapper) error {
+		singular, err = mapper.ResourceSingularizer(resource)
+		return err
+	})
+	return singular, err
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var _ = Describe("ShardedRESTMapper", func() {
+	widgets := schema.GroupVersionKind{Group: "tutorial.kubebuilder.io", Version: "v1alpha1", Kind: "Widget"}
+	gadgets := schema.GroupVersionKind{Group: "tutorial.kubebuilder.io", Version: "v1alpha1", Kind: "Gadget"}
+
+	var (
+		mapper  *ShardedRESTMapper
+		created []string
+	)
+
+	BeforeEach(func() {
+		created = nil
+		// Each shard knows Widgets; the second one Gadgets as well, as if
+		// it was the first to serve a new schema of the APIExport.
+		mapper = NewShardedRESTMapper(func(url string) (meta.RESTMapper, error) {
+			if url == "https://broken" {
+				return nil, errors.New("discovery failed")
+			}
+			created = append(created, url)
+			m := meta.NewDefaultRESTMapper(nil)
+			m.Add(widgets, meta.RESTScopeNamespace)
+			if url == "https://shard-2" {
+				m.Add(gadgets, meta.RESTScopeNamespace)
+			}
+			return m, nil
+		})
+	})
+
+	It("maps the kinds known to any shard", func() {
+		Expect(mapper.SetShards([]string{"https://shard-1", "https://shard-2"})).To(Succeed())
+
+		mapping, err := mapper.RESTMapping(widgets.GroupKind(), widgets.Version)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mapping.Resource.Resource).To(Equal("widgets"))
+		mapping, err = mapper.RESTMapping(gadgets.GroupKind(), gadgets.Version)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mapping.Resource.Resource).To(Equal("gadgets"))
+	})
+
+	It("follows the shards as they are added and removed", func() {
+		Expect(mapper.SetShards([]string{"https://shard-1"})).To(Succeed())
+		_, err := mapper.RESTMapping(gadgets.GroupKind(), gadgets.Version)
+		Expect(meta.IsNoMatchError(err)).To(BeTrue())
+
+		Expect(mapper.SetShards([]string{"https://shard-1", "https://shard-2"})).To(Succeed())
+		Expect(mapper.SetShards([]string{"https://shard-2"})).To(Succeed())
+		Expect(created).To(Equal([]string{"https://shard-1", "https://shard-2"}))
+		_, err = mapper.RESTMapping(gadgets.GroupKind(), gadgets.Version)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("keeps the shards whose mapper could be created", func() {
+		Expect(mapper.SetShards([]string{"https://broken", "https://shard-1"})).To(MatchError(ContainSubstring("discovery failed")))
+		_, err := mapper.KindFor(schema.GroupVersionResource{Group: widgets.Group, Version: widgets.Version, Resource: "widgets"})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("rejects an empty list of shards", func() {
+		Expect(mapper.SetShards(nil)).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,192 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// DefaultShutdownTimeout is how long the Supervisor waits for each member to
+// stop.
+const DefaultShutdownTimeout = 30 * time.Second
+
+// errStoppedUnexpectedly is the error of a member that stopped on its own.
+var errStoppedUnexpectedly = errors.New("stopped unexpectedly")
+
+// supervisorRunning reports whether the members of the Supervisor are running.
+var supervisorRunning = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "supervisor_member_running",
+	Help: "Whether a manager or cluster run by the supervisor is running (1) or not (0).",
+}, []string{"member"})
+
+func init() {
+	metrics.Registry.MustRegister(supervisorRunning)
+}
+
+// Supervisor runs several managers and clusters, its members, concurrently in
+// one process. The first member to fail, or to stop on its own, stops the
+// others and its error is returned by Start. Members are stopped one after the
+// other in the reverse order they were added, so that the managers running
+// controllers can be added last and stop before the clusters they write to.
+type Supervisor struct {
+	// ShutdownTimeout bounds how long each member is waited for when
+	// stopping. Defaults to DefaultShutdownTimeout.
+	ShutdownTimeout time.Duration
+
+	mu      sync.Mutex
+	members []*supervisedMember
+	started bool
+}
+
+// supervisedMember is a member of a Supervisor.
+type supervisedMember struct {
+	name     string
+	runnable manager.Runnable
+	running  bool
+	cancel   context.CancelFunc
+	// done is closed once the member stopped, err holds the error it
+	// stopped with.
+	done chan struct{}
+	err  error
+}
+
+// Add adds r to the members run by s under name. Members cannot be added once
+// s is started.
+func (s *Supervisor) Add(name string, r manager.Runnable) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return fmt.Errorf("unable to add %s: supervisor already started", name)
+	}
+	for _, m := range s.members {
+		if m.name == name {
+			return fmt.Errorf("duplicate member %s", name)
+		}
+	}
+	s.members = append(s.members, &supervisedMember{name: name, runnable: r})
+	supervisorRunning.WithLabelValues(name).Set(0)
+	return nil
+}
+
+// Start runs every member until ctx is done or a member stops, then stops the
+// members and returns the error of the member that stopped first, if any.
+func (s *Supervisor) Start(ctx context.Context) error {
+	s.mu.Lock()
+	if s.started {
+		s.mu.Unlock()
+		return errors.New("supervisor already started")
+	}
+	s.started = true
+	members := s.members
+	s.mu.Unlock()
+
+	logger := log.FromContext(ctx).WithName("supervisor")
+	stopped := make(chan *supervisedMember, len(members))
+	for _, m := range members {
+		// Members get their own context, so that they are stopped in order
+		// rather than all at once when ctx is done.
+		memberCtx, cancel := context.WithCancel(log.IntoContext(context.Background(), logger.WithValues("member", m.name)))
+		m.cancel = cancel
+		m.done = make(chan struct{})
+		s.setRunning(m, true)
+		go func(m *supervisedMember) {
+			defer close(m.done)
+			err := m.runnable.Start(memberCtx)
+			if err == nil && memberCtx.Err() == nil {
+				err = errStoppedUnexpectedly
+			}
+			m.err = err
+			s.setRunning(m, false)
+			if memberCtx.Err() == nil {
+				stopped <- m
+			}
+		}(m)
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+		logger.Info("Stopping members")
+	case m := <-stopped:
+		err = fmt.Errorf("%s: %w", m.name, m.err)
+		logger.Error(m.err, "Member stopped, stopping the others", "member", m.name)
+	}
+	s.stop(ctx, members)
+	return err
+}
+
+// stop stops members in the reverse order they were added.
+func (s *Supervisor) stop(ctx context.Context, members []*supervisedMember) {
+	logger := log.FromContext(ctx).WithName("supervisor")
+	timeout := s.ShutdownTimeout
+	if timeout == 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	for i := len(members) - 1; i >= 0; i-- {
+		m := members[i]
+		m.cancel()
+		timer := time.NewTimer(timeout)
+		select {
+		case <-m.done:
+			logger.V(1).Info("Member stopped", "member", m.name)
+		case <-timer.C:
+			logger.Info("Timed out waiting for member to stop", "member", m.name, "timeout", timeout)
+		}
+		timer.Stop()
+	}
+}
+
+func (s *Supervisor) setRunning(m *supervisedMember, running bool) {
+	s.mu.Lock()
+	m.running = running
+	s.mu.Unlock()
+	value := 0.0
+	if running {
+		value = 1
+	}
+	supervisorRunning.WithLabelValues(m.name).Set(value)
+}
+
+// Check returns a health check that fails while the member name is not
+// running.
+func (s *Supervisor) Check(name string) healthz.Checker {
+	return func(_ *http.Request) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, m := range s.members {
+			if m.name != name {
+				continue
+			}
+			if !m.running {
+				return fmt.Errorf("%s is not running", name)
+			}
+			return nil
+		}
+		return fmt.Errorf("unknown member %s", name)
+	}
+}
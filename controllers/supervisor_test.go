@@ -0,0 +1,111 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+var _ = Describe("Supervisor", func() {
+	var (
+		supervisor *Supervisor
+		mu         sync.Mutex
+		stopped    []string
+	)
+
+	// member runs until its context is done and records when it stopped.
+	member := func(name string) manager.Runnable {
+		return manager.RunnableFunc(func(ctx context.Context) error {
+			<-ctx.Done()
+			mu.Lock()
+			defer mu.Unlock()
+			stopped = append(stopped, name)
+			return nil
+		})
+	}
+	stoppedMembers := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), stopped...)
+	}
+
+	BeforeEach(func() {
+		supervisor = &Supervisor{ShutdownTimeout: time.Second}
+		stopped = nil
+		Expect(supervisor.Add("cluster", member("cluster"))).To(Succeed())
+		Expect(supervisor.Add("manager", member("manager"))).To(Succeed())
+	})
+
+	It("stops members in the reverse order they were added", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- supervisor.Start(ctx) }()
+		Eventually(func() error { return supervisor.Check("cluster")(nil) }).Should(Succeed())
+
+		cancel()
+		Eventually(done).Should(Receive(BeNil()))
+		Expect(stoppedMembers()).To(Equal([]string{"manager", "cluster"}))
+	})
+
+	It("stops the others when a member fails and returns its error", func() {
+		failure := errors.New("lost connection")
+		Expect(supervisor.Add("failing", manager.RunnableFunc(func(context.Context) error {
+			return failure
+		}))).To(Succeed())
+
+		err := supervisor.Start(context.Background())
+		Expect(err).To(MatchError(ContainSubstring("failing")))
+		Expect(errors.Is(err, failure)).To(BeTrue())
+		Expect(stoppedMembers()).To(Equal([]string{"manager", "cluster"}))
+	})
+
+	It("treats a member stopping on its own as a failure", func() {
+		Expect(supervisor.Add("quitter", manager.RunnableFunc(func(context.Context) error {
+			return nil
+		}))).To(Succeed())
+
+		Expect(supervisor.Start(context.Background())).To(MatchError(errStoppedUnexpectedly))
+	})
+
+	It("reports the health of each member", func() {
+		Expect(supervisor.Check("manager")(nil)).To(HaveOccurred())
+		Expect(supervisor.Check("unknown")(nil)).To(MatchError(ContainSubstring("unknown member")))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- supervisor.Start(ctx) }()
+		Eventually(func() error { return supervisor.Check("manager")(nil) }).Should(Succeed())
+
+		cancel()
+		Eventually(done).Should(Receive())
+		Expect(supervisor.Check("manager")(nil)).To(MatchError(ContainSubstring("not running")))
+	})
+
+	It("rejects members added after it started", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		Expect(supervisor.Start(ctx)).To(Succeed())
+		Expect(supervisor.Add("late", member("late"))).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,263 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"text/template"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/kcp-dev/logicalcluster/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// Transform is one step of the pipeline that rewrites a mirrored object before
+// it is written to its target. Exactly one of the fields must be set.
+//
+// String values in JSON patch operations and added labels and annotations are
+// text/templates, rendered with .Target.Name and .Target.Namespace of the
+// mirror target and .Source.Cluster, .Source.Namespace and .Source.Name of the
+// reference object.
+type Transform struct {
+	// JSONPatch is applied to the mirrored object as an RFC 6902 JSON patch.
+	JSONPatch []JSONPatchOperation `json:"jsonPatch,omitempty"`
+
+	// Labels adds and removes labels of the mirrored object.
+	Labels *MetadataTransform `json:"labels,omitempty"`
+
+	// Annotations adds and removes annotations of the mirrored object.
+	Annotations *MetadataTransform `json:"annotations,omitempty"`
+}
+
+// JSONPatchOperation is a single RFC 6902 JSON patch operation.
+type JSONPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// MetadataTransform adds and removes labels or annotations.
+type MetadataTransform struct {
+	// Add sets these keys, overwriting existing values.
+	Add map[string]string `json:"add,omitempty"`
+
+	// Remove deletes these keys. A key ending in * removes every key with
+	// that prefix.
+	Remove []string `json:"remove,omitempty"`
+}
+
+// transformData is passed to the templates of a Transform.
+type transformData struct {
+	Target struct {
+		Name      string
+		Namespace string
+	}
+	Source struct {
+		Cluster   string
+		Namespace string
+		Name      string
+	}
+}
+
+// LoadTransforms reads a YAML or JSON list of Transforms from path.
+func LoadTransforms(path string) ([]Transform, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading transforms: %w", err)
+	}
+	var transforms []Transform
+	if err := yaml.UnmarshalStrict(data, &transforms); err != nil {
+		return nil, fmt.Errorf("error decoding transforms %q: %w", path, err)
+	}
+	for i, t := range transforms {
+		if err := t.validate(); err != nil {
+			return nil, fmt.Errorf("transform %d in %q: %w", i, path, err)
+		}
+	}
+	return transforms, nil
+}
+
+func (t Transform) validate() error {
+	set := 0
+	if len(t.JSONPatch) > 0 {
+		set++
+	}
+	if t.Labels != nil {
+		set++
+	}
+	if t.Annotations != nil {
+		set++
+	}
+	if set != 1 {
+		return fmt.Errorf("exactly one of jsonPatch, labels or annotations must be set")
+	}
+
+	// Render with empty data to catch template and patch errors early.
+	var data transformData
+	if len(t.JSONPatch) > 0 {
+		if _, err := t.patch(data); err != nil {
+			return err
+		}
+	}
+	for _, m := range []*MetadataTransform{t.Labels, t.Annotations} {
+		if m == nil {
+			continue
+		}
+		for _, v := range m.Add {
+			if _, err := render(v, data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applyTransforms runs transforms over obj, the mirrored copy of reference
+// destined for target. Transforms must not change the name or namespace of obj.
+func applyTransforms(obj client.Object, reference client.Object, target MirrorTarget, transforms []Transform) error {
+	if len(transforms) == 0 {
+		return nil
+	}
+
+	var data transformData
+	data.Target.Name = target.Name
+	data.Target.Namespace = obj.GetNamespace()
+	data.Source.Cluster = logicalcluster.From(reference).String()
+	data.Source.Namespace = reference.GetNamespace()
+	data.Source.Name = reference.GetName()
+
+	name, namespace := obj.GetName(), obj.GetNamespace()
+	for i, t := range transforms {
+		if err := t.apply(obj, data); err != nil {
+			return fmt.Errorf("transform %d: %w", i, err)
+		}
+	}
+	if obj.GetName() != name || obj.GetNamespace() != namespace {
+		return fmt.Errorf("transforms must not change the name or namespace of the mirrored object")
+	}
+	return nil
+}
+
+func (t Transform) apply(obj client.Object, data transformData) error {
+	if t.Labels != nil {
+		labels, err := t.Labels.apply(obj.GetLabels(), data)
+		if err != nil {
+			return err
+		}
+		obj.SetLabels(labels)
+	}
+	if t.Annotations != nil {
+		annotations, err := t.Annotations.apply(obj.GetAnnotations(), data)
+		if err != nil {
+			return err
+		}
+		obj.SetAnnotations(annotations)
+	}
+	if len(t.JSONPatch) > 0 {
+		patch, err := t.patch(data)
+		if err != nil {
+			return err
+		}
+		doc, err := json.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		if doc, err = patch.Apply(doc); err != nil {
+			return fmt.Errorf("error applying JSON patch: %w", err)
+		}
+		// Decode into a zeroed object so that removed map entries stay removed.
+		v := reflect.ValueOf(obj).Elem()
+		v.Set(reflect.Zero(v.Type()))
+		if err := json.Unmarshal(doc, obj); err != nil {
+			return fmt.Errorf("error decoding patched object: %w", err)
+		}
+	}
+	return nil
+}
+
+// patch renders the templated string values of the JSON patch operations.
+func (t Transform) patch(data transformData) (jsonpatch.Patch, error) {
+	ops := make([]JSONPatchOperation, len(t.JSONPatch))
+	for i, op := range t.JSONPatch {
+		ops[i] = op
+		var s string
+		if len(op.Value) == 0 || json.Unmarshal(op.Value, &s) != nil {
+			continue
+		}
+		rendered, err := render(s, data)
+		if err != nil {
+			return nil, err
+		}
+		if ops[i].Value, err = json.Marshal(rendered); err != nil {
+			return nil, err
+		}
+	}
+	raw, err := json.Marshal(ops)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := jsonpatch.DecodePatch(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON patch: %w", err)
+	}
+	return patch, nil
+}
+
+func (m *MetadataTransform) apply(in map[string]string, data transformData) (map[string]string, error) {
+	out := copyStringMap(in)
+	for _, remove := range m.Remove {
+		if prefix := strings.TrimSuffix(remove, "*"); prefix != remove {
+			for k := range out {
+				if strings.HasPrefix(k, prefix) {
+					delete(out, k)
+				}
+			}
+			continue
+		}
+		delete(out, remove)
+	}
+	for k, v := range m.Add {
+		rendered, err := render(v, data)
+		if err != nil {
+			return nil, err
+		}
+		if out == nil {
+			out = map[string]string{}
+		}
+		out[k] = rendered
+	}
+	return out, nil
+}
+
+func render(text string, data transformData) (string, error) {
+	tmpl, err := template.New("transform").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid template %q: %w", text, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("error rendering template %q: %w", text, err)
+	}
+	return buf.String(), nil
+}
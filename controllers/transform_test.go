@@ -0,0 +1,78 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+
+	"github.com/kcp-dev/logicalcluster/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
+)
+
+var _ = Describe("Transform", func() {
+	var reference, mirror *tutorialkubebuilderiov1alpha1.Widget
+
+	BeforeEach(func() {
+		reference = &tutorialkubebuilderiov1alpha1.Widget{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "widget",
+				Namespace:   "default",
+				Annotations: map[string]string{logicalcluster.AnnotationKey: "root:org"},
+			},
+		}
+		mirror = reference.DeepCopy()
+		mirror.Annotations = nil
+		mirror.Labels = map[string]string{"team.example.com/owner": "a", "keep": "x"}
+		mirror.Spec.Foo = "bar"
+	})
+
+	It("applies transforms in order", func() {
+		transforms := []Transform{
+			{Labels: &MetadataTransform{
+				Remove: []string{"team.example.com/*"},
+				Add:    map[string]string{"mirrored-from": "{{.Source.Name}}"},
+			}},
+			{JSONPatch: []JSONPatchOperation{
+				{Op: "replace", Path: "/spec/foo", Value: json.RawMessage(`"{{.Target.Name}}-{{.Source.Namespace}}"`)},
+				{Op: "remove", Path: "/metadata/labels/keep"},
+			}},
+		}
+		Expect(applyTransforms(mirror, reference, MirrorTarget{Name: "east"}, transforms)).To(Succeed())
+		Expect(mirror.Labels).To(Equal(map[string]string{"mirrored-from": "widget"}))
+		Expect(mirror.Spec.Foo).To(Equal("east-default"))
+	})
+
+	It("rejects transforms that rename the mirrored object", func() {
+		transforms := []Transform{
+			{JSONPatch: []JSONPatchOperation{{Op: "replace", Path: "/metadata/name", Value: json.RawMessage(`"other"`)}}},
+		}
+		Expect(applyTransforms(mirror, reference, MirrorTarget{Name: "east"}, transforms)).NotTo(Succeed())
+	})
+
+	DescribeTable("rejects invalid transforms",
+		func(t Transform) {
+			Expect(t.validate()).NotTo(Succeed())
+		},
+		Entry("empty", Transform{}),
+		Entry("more than one step", Transform{Labels: &MetadataTransform{}, Annotations: &MetadataTransform{}}),
+		Entry("unknown template field", Transform{Labels: &MetadataTransform{Add: map[string]string{"a": "{{.Nope}}"}}}),
+	)
+})
@@ -18,11 +18,22 @@ package controllers
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/kcp-dev/logicalcluster/v2"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
@@ -30,20 +41,74 @@ import (
 
 // WidgetReconciler reconciles a Widget object
 type WidgetReconciler struct {
+	// Client reads Widgets from the reference cluster and writes back their
+	// status.
 	client.Client
 	Scheme *runtime.Scheme
+
+	// Targets are the clusters reference Widgets are copied into. Each target
+	// is reconciled independently. When empty, the reconciler only observes
+	// Widgets in the reference cluster.
+	Targets []MirrorTarget
+
+	// Recorder records events on reference Widgets.
+	Recorder record.EventRecorder
+
+	// DeletionPolicy is applied to the mirrored Widget when its reference
+	// Widget is deleted, unless the Widget overrides it with the
+	// DeletionPolicyAnnotation. Defaults to DeletionPolicyDelete.
+	DeletionPolicy tutorialkubebuilderiov1alpha1.DeletionPolicy
+
+	// DeletionTimeout bounds how long the deletion or deselection of a
+	// reference Widget is held up by an unreachable mirror cluster. Defaults to
+	// DefaultDeletionTimeout.
+	DeletionTimeout time.Duration
+
+	// Filter selects the reference Widgets that are mirrored. Widgets that stop
+	// being selected have the DeletionPolicy applied to their mirrored copies.
+	Filter MirrorFilter
+
+	// Router chooses the targets of a reference Widget by its kcp workspace.
+	// When nil, every Widget is mirrored into every target.
+	Router *MirrorRouter
+
+	// DriftPolicy is applied when a mirrored Widget was modified in the mirror
+	// cluster, unless the Widget overrides it with the DriftPolicyAnnotation.
+	// Defaults to DriftPolicyRevert.
+	DriftPolicy tutorialkubebuilderiov1alpha1.DriftPolicy
+
+	// ConflictPolicy is applied when fields of a mirrored Widget are owned by
+	// other field managers in the mirror cluster, unless the Widget overrides
+	// it with the ConflictPolicyAnnotation. Defaults to ConflictPolicyForce.
+	ConflictPolicy tutorialkubebuilderiov1alpha1.ConflictPolicy
+
+	// MaxConcurrentReconciles is how many Widgets are reconciled at a time.
+	// Defaults to the concurrency of the manager for Widgets, or 1.
+	MaxConcurrentReconciles int
 }
 
 //+kubebuilder:rbac:groups=tutorial.kubebuilder.io,resources=widgets,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=tutorial.kubebuilder.io,resources=widgets/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=tutorial.kubebuilder.io,resources=widgets/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=configmaps;secrets,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the Widget object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
+// The Widget is read from the reference cluster and, if it is selected by the
+// Filter, an equivalent Widget carrying the same spec, labels and annotations
+// is created or updated in each mirror target chosen by the Router, together
+// with the ConfigMaps and Secrets the Widget references. A failure in one target does not
+// prevent the others from being reconciled; the outcome for every target is
+// reported in the Widget status. Changes made to a mirrored Widget in its
+// target are handled according to the DriftPolicy. The status of the Widget
+// mirrored into the first target is copied back to the reference Widget.
+// Reference Widgets carry a finalizer so that the DeletionPolicy is applied to
+// the mirrored Widgets before the reference Widget goes away or once it is no
+// longer selected. While the PausedAnnotation is set on the Widget or its
+// namespace, nothing is written to the mirror targets and the Paused condition
+// is True; a paused Widget that is deleted leaves its mirrored Widgets behind.
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.11.2/pkg/reconcile
@@ -55,18 +120,327 @@ func (r *WidgetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 	logger = logger.WithValues("clusterName", req.ClusterName)
 	logger.V(1).Info("Starting reconcile")
 
+	// The mirror cluster is not logical cluster aware, so keep a context without
+	// the logical cluster for the mirror client.
+	mirrorCtx := ctx
+
 	// Add the logical cluster to the context
 	ctx = logicalcluster.WithCluster(ctx, logicalcluster.New(req.ClusterName))
 
+	var widget tutorialkubebuilderiov1alpha1.Widget
+	if err := r.Get(ctx, req.NamespacedName, &widget); err != nil {
+		if apierrors.IsNotFound(err) {
+			logger.V(1).Info("Widget not found in reference cluster")
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	by, err := pausedBy(ctx, r.Client, &widget)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if !widget.DeletionTimestamp.IsZero() {
+		if by != "" {
+			return r.lifecycle().abandon(ctx, &widget, by)
+		}
+		return r.lifecycle().finalize(ctx, mirrorCtx, &widget)
+	}
+
+	if by != "" {
+		return r.pause(ctx, &widget, by)
+	}
+
+	if !r.Filter.Selected(&widget) {
+		return r.lifecycle().unmirror(ctx, mirrorCtx, &widget)
+	}
+
+	// Without targets there is nothing to mirror, but a reference object
+	// tracked before the targets were removed must still be released, so
+	// this is only checked once deletion and deselection are handled.
+	if len(r.Targets) == 0 {
+		logger.V(1).Info("Completed reconcile")
+		return ctrl.Result{}, nil
+	}
+
+	if err := r.lifecycle().track(ctx, &widget); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	targets, unknown, err := r.Router.Route(ctx, &widget, r.Targets)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	status := widget.Status.DeepCopy()
+	mirrors := make([]tutorialkubebuilderiov1alpha1.WidgetMirrorStatus, 0, len(targets))
+	drift := map[string][]string{}
+	conflicts := map[string][]string{}
+	var conflicted []string
+	var errs []error
+	unreachable := false
+	for i, target := range targets {
+		mirrorStatus := tutorialkubebuilderiov1alpha1.WidgetMirrorStatus{Target: target.Name}
+		if previous := findMirrorStatus(status.Mirrors, target.Name); previous != nil {
+			mirrorStatus.ObservedGeneration = previous.ObservedGeneration
+		}
+		if !target.reachable() {
+			logger.V(1).Info("Mirror cluster is unreachable, skipping target", "target", target.Name)
+			mirrorStatus.Message = "Mirror cluster is unreachable"
+			mirrors = append(mirrors, mirrorStatus)
+			unreachable = true
+			continue
+		}
+
+		mirror, reason, fields, result, err := r.reconcileTarget(ctx, mirrorCtx, &widget, target)
+		if len(fields) > 0 {
+			conflicts[target.Name] = fields
+			conflicted = append(conflicted, target.Name)
+		}
+		switch {
+		case err != nil:
+			logger.Error(err, "unable to mirror Widget", "target", target.Name)
+			mirrorStatus.Message = err.Error()
+			errs = append(errs, fmt.Errorf("target %s: %w", target.Name, err))
+		case len(fields) > 0 && r.conflictPolicy(&widget) == tutorialkubebuilderiov1alpha1.ConflictPolicyReport:
+			logger.Info("Mirrored Widget has fields owned by other field managers", "target", target.Name, "conflicts", fields)
+			mirrorStatus.Message = "Fields are owned by other field managers: " + strings.Join(fields, ", ")
+		default:
+			logger.V(1).Info("Mirrored Widget", "target", target.Name, "mirror", result)
+			mirrorStatus.Synced = true
+			mirrorStatus.ObservedGeneration = widget.Generation
+			if target.DryRun {
+				mirrorStatus.Message = "Dry run, changes are not written to the target"
+			}
+		}
+		if reason != "" {
+			drift[reason] = append(drift[reason], target.Name)
+		}
+		// The first target is the source of the reference Widget's status.
+		if i == 0 && mirror != nil && !target.DryRun {
+			mergeMirrorStatus(&widget.Status, &mirror.Status)
+		}
+		mirrors = append(mirrors, mirrorStatus)
+	}
+	for _, name := range unknown {
+		logger.Info("Workspace label names an unknown mirror target", "target", name)
+		mirrors = append(mirrors, tutorialkubebuilderiov1alpha1.WidgetMirrorStatus{
+			Target:  name,
+			Message: fmt.Sprintf("Unknown mirror target named by the %s label of the workspace", r.Router.Label),
+		})
+	}
+	widget.Status.Mirrors = mirrors
+	setDriftedCondition(&widget, drift)
+	setConflictedCondition(&widget, r.conflictPolicy(&widget), conflicts, conflicted)
+	setPausedCondition(&widget, "")
+
+	if !equality.Semantic.DeepEqual(*status, widget.Status) {
+		if err := r.Status().Update(ctx, &widget); err != nil {
+			logger.Error(err, "unable to update Widget status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	if err := kerrors.NewAggregate(errs); err != nil {
+		return ctrl.Result{}, err
+	}
+	if unreachable {
+		logger.V(1).Info("Completed reconcile, retrying unreachable targets later")
+		return ctrl.Result{RequeueAfter: UnreachableRequeueAfter}, nil
+	}
 	logger.V(1).Info("Completed reconcile")
+	return ctrl.Result{RequeueAfter: r.Router.RequeueAfter()}, nil
+}
+
+// pause records in the status of widget that its mirroring is paused by the
+// PausedAnnotation on by, and leaves the mirror clusters alone.
+func (r *WidgetReconciler) pause(ctx context.Context, widget *tutorialkubebuilderiov1alpha1.Widget, by string) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	logger.V(1).Info("Mirroring is paused", "by", by)
+	status := widget.Status.DeepCopy()
+	setPausedCondition(widget, by)
+	if !equality.Semantic.DeepEqual(*status, widget.Status) {
+		if err := r.Status().Update(ctx, widget); err != nil {
+			logger.Error(err, "unable to update Widget status")
+			return ctrl.Result{}, err
+		}
+	}
 	return ctrl.Result{}, nil
 }
 
+// reconcileTarget mirrors widget into target, after the ConfigMaps and Secrets
+// it references, and deletes the copies of those no mirrored Widget
+// references anymore. It returns the mirrored Widget as
+// last seen in the target, the reason reported by the drift policy if the
+// mirrored Widget had drifted, and the fields of the mirrored objects owned by
+// other field managers.
+func (r *WidgetReconciler) reconcileTarget(ctx, mirrorCtx context.Context, widget *tutorialkubebuilderiov1alpha1.Widget, target MirrorTarget) (*tutorialkubebuilderiov1alpha1.Widget, string, []string, controllerutil.OperationResult, error) {
+	key, err := mirrorKey(target, widget)
+	if err != nil {
+		return nil, "", nil, controllerutil.OperationResultNone, err
+	}
+	mirror, err := getMirror(mirrorCtx, target.Client, key)
+	if err != nil {
+		return nil, "", nil, controllerutil.OperationResultNone, err
+	}
+	if mirror != nil {
+		if err := checkOrigin(mirror, widget); err != nil {
+			return nil, "", nil, controllerutil.OperationResultNone, err
+		}
+	}
+
+	if mirror == nil {
+		if err := ensureNamespace(mirrorCtx, target, key.Namespace); err != nil {
+			return mirror, "", nil, controllerutil.OperationResultNone, err
+		}
+	}
+	force := r.conflictPolicy(widget) == tutorialkubebuilderiov1alpha1.ConflictPolicyForce
+	dependentConflicts, err := mirrorDependents(ctx, mirrorCtx, r.Client, widget, target, key.Namespace, force)
+	if err != nil {
+		return mirror, "", dependentConflicts, controllerutil.OperationResultNone, err
+	}
+
+	var reason string
+	if mirror != nil && mirrorDrifted(mirror) {
+		var write bool
+		if write, reason, err = r.handleDrift(ctx, widget, mirror, target); err != nil || !write {
+			return mirror, reason, dependentConflicts, controllerutil.OperationResultNone, err
+		}
+	}
+
+	desired, err := desiredMirror(widget, target, key)
+	if err != nil {
+		return mirror, reason, dependentConflicts, controllerutil.OperationResultNone, err
+	}
+	mirror, result, conflicts, err := writeMirror(mirrorCtx, target, desired, mirror, force)
+	if err == nil && (force || len(conflicts) == 0) {
+		// The mirrored Widget now references the dependents of widget only.
+		err = pruneDependents(mirrorCtx, target, key.Namespace, key, widgetDependents(widget, key.Namespace))
+	}
+	return mirror, reason, append(dependentConflicts, conflicts...), result, err
+}
+
+// getMirror returns the mirrored Widget with the given key read through c, or
+// nil if there is none.
+func getMirror(ctx context.Context, c client.Client, key client.ObjectKey) (*tutorialkubebuilderiov1alpha1.Widget, error) {
+	var mirror tutorialkubebuilderiov1alpha1.Widget
+	if err := c.Get(ctx, key, &mirror); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &mirror, nil
+}
+
+// desiredMirror returns the copy of widget that should exist in target under
+// key, rewritten by the transforms of target and stamped with the origin of
+// widget and the hash of its content.
+func desiredMirror(widget *tutorialkubebuilderiov1alpha1.Widget, target MirrorTarget, key client.ObjectKey) (*tutorialkubebuilderiov1alpha1.Widget, error) {
+	mirror := &tutorialkubebuilderiov1alpha1.Widget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        key.Name,
+			Namespace:   key.Namespace,
+			Labels:      copyStringMap(widget.Labels),
+			Annotations: copyStringMap(widget.Annotations),
+		},
+		Spec: *widget.Spec.DeepCopy(),
+	}
+	// The logical cluster annotation belongs to the reference cluster.
+	delete(mirror.Annotations, logicalcluster.AnnotationKey)
+	// Annotations of a reference Widget that is itself a mirror are not passed on.
+	for _, annotation := range mirrorAnnotations {
+		delete(mirror.Annotations, annotation)
+	}
+	if err := applyTransforms(mirror, widget, target, target.Transforms); err != nil {
+		return nil, err
+	}
+	setOrigin(mirror, widget)
+	mirror.Annotations[tutorialkubebuilderiov1alpha1.ContentHashAnnotation] = contentHash(mirror)
+	return mirror, nil
+}
+
+// writeMirror server-side applies desired into target unless mirror, the
+// current copy or nil if there is none yet, is up to date according to its
+// ContentHashAnnotation. It returns the mirrored Widget as last seen by the
+// mirror API server and the fields of it owned by other field managers, which
+// are only taken over if force is set.
+func writeMirror(ctx context.Context, target MirrorTarget, desired, mirror *tutorialkubebuilderiov1alpha1.Widget, force bool) (*tutorialkubebuilderiov1alpha1.Widget, controllerutil.OperationResult, []string, error) {
+	if mirror != nil && hashUpToDate(desired, mirror, func() string { return contentHash(mirror) }) {
+		mirrorWrites.WithLabelValues(target.Name, "Widget", writeResultSkipped).Inc()
+		return mirror, controllerutil.OperationResultNone, nil, nil
+	}
+
+	desired.SetGroupVersionKind(tutorialkubebuilderiov1alpha1.GroupVersion.WithKind("Widget"))
+	conflicts, err := applyMirror(ctx, target.Client, desired, force)
+	if err != nil || (len(conflicts) > 0 && !force) {
+		return mirror, controllerutil.OperationResultNone, conflicts, err
+	}
+	mirrorWrites.WithLabelValues(target.Name, "Widget", writeResultPerformed).Inc()
+	if mirror == nil {
+		return desired, controllerutil.OperationResultCreated, conflicts, nil
+	}
+	return desired, controllerutil.OperationResultUpdated, conflicts, nil
+}
+
+// conflictPolicy returns the conflict policy for widget, honouring the
+// per-Widget annotation over the reconciler wide setting.
+func (r *WidgetReconciler) conflictPolicy(widget *tutorialkubebuilderiov1alpha1.Widget) tutorialkubebuilderiov1alpha1.ConflictPolicy {
+	return conflictPolicy(widget, r.ConflictPolicy)
+}
+
+// ownedConditionTypes are the conditions this controller sets on reference
+// Widgets. They are never copied from the mirrored Widget.
+var ownedConditionTypes = []string{
+	tutorialkubebuilderiov1alpha1.ConditionTypeDrifted,
+	tutorialkubebuilderiov1alpha1.ConditionTypePaused,
+	tutorialkubebuilderiov1alpha1.ConditionTypeConflicted,
+}
+
+// mergeMirrorStatus copies the status of the mirrored Widget into status,
+// keeping the conditions and fields owned by this controller.
+func mergeMirrorStatus(status, mirror *tutorialkubebuilderiov1alpha1.WidgetStatus) {
+	mirrors := status.Mirrors
+	var owned []metav1.Condition
+	for _, t := range ownedConditionTypes {
+		if c := meta.FindStatusCondition(status.Conditions, t); c != nil {
+			owned = append(owned, *c)
+		}
+	}
+	mirror.DeepCopyInto(status)
+	for _, t := range ownedConditionTypes {
+		meta.RemoveStatusCondition(&status.Conditions, t)
+	}
+	status.Conditions = append(status.Conditions, owned...)
+	status.Mirrors = mirrors
+}
+
+func findMirrorStatus(mirrors []tutorialkubebuilderiov1alpha1.WidgetMirrorStatus, target string) *tutorialkubebuilderiov1alpha1.WidgetMirrorStatus {
+	for i := range mirrors {
+		if mirrors[i].Target == target {
+			return &mirrors[i]
+		}
+	}
+	return nil
+}
+
+func copyStringMap(in map[string]string) map[string]string {
+	if in == nil {
+		return nil
+	}
+	out := make(map[string]string, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *WidgetReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	var setupLog = ctrl.Log.WithName("setup-manager")
 	setupLog.Info("here5")
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&tutorialkubebuilderiov1alpha1.Widget{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
 		Complete(r)
 }
@@ -0,0 +1,209 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+
+	"github.com/kcp-dev/logicalcluster/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
+)
+
+// applyClient stands in for the server-side apply of a mirror API server,
+// which the fake client lacks: applied objects are created, or replace the
+// stored ones but for their status.
+type applyClient struct {
+	client.Client
+}
+
+func (c applyClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if patch.Type() != types.ApplyPatchType {
+		return c.Client.Patch(ctx, obj, patch, opts...)
+	}
+	current := obj.DeepCopyObject().(client.Object)
+	if err := c.Client.Get(ctx, client.ObjectKeyFromObject(obj), current); err != nil {
+		if apierrors.IsNotFound(err) {
+			return c.Client.Create(ctx, obj)
+		}
+		return err
+	}
+	obj.SetResourceVersion(current.GetResourceVersion())
+	if widget, ok := obj.(*tutorialkubebuilderiov1alpha1.Widget); ok {
+		widget.Status = current.(*tutorialkubebuilderiov1alpha1.Widget).Status
+	}
+	return c.Client.Update(ctx, obj)
+}
+
+// failingClient fails every read of the mirror cluster it stands for.
+type failingClient struct {
+	client.Client
+	err error
+}
+
+func (c failingClient) Get(context.Context, client.ObjectKey, client.Object) error {
+	return c.err
+}
+
+var _ = Describe("WidgetReconciler", func() {
+	var (
+		ctx       context.Context
+		scheme    *runtime.Scheme
+		reference client.Client
+		east      client.Client
+		west      client.Client
+		r         *WidgetReconciler
+	)
+
+	key := client.ObjectKey{Namespace: "default", Name: "widget"}
+	request := ctrl.Request{NamespacedName: key, ClusterName: "root:org"}
+	stored := func() *tutorialkubebuilderiov1alpha1.Widget {
+		var w tutorialkubebuilderiov1alpha1.Widget
+		Expect(reference.Get(ctx, key, &w)).To(Succeed())
+		return &w
+	}
+	mirrored := func(c client.Client) *tutorialkubebuilderiov1alpha1.Widget {
+		var w tutorialkubebuilderiov1alpha1.Widget
+		Expect(c.Get(ctx, key, &w)).To(Succeed())
+		return &w
+	}
+	setup := func(widget *tutorialkubebuilderiov1alpha1.Widget) {
+		reference = fake.NewClientBuilder().WithScheme(scheme).WithObjects(widget).Build()
+		r.Client = reference
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme = runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(tutorialkubebuilderiov1alpha1.AddToScheme(scheme)).To(Succeed())
+		east = applyClient{fake.NewClientBuilder().WithScheme(scheme).Build()}
+		west = applyClient{fake.NewClientBuilder().WithScheme(scheme).Build()}
+		r = &WidgetReconciler{
+			Scheme:   scheme,
+			Targets:  []MirrorTarget{{Name: "east", Client: east}, {Name: "west", Client: west}},
+			Recorder: record.NewFakeRecorder(10),
+		}
+		setup(&tutorialkubebuilderiov1alpha1.Widget{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        key.Name,
+				Namespace:   key.Namespace,
+				Labels:      map[string]string{"app": "widget"},
+				Annotations: map[string]string{logicalcluster.AnnotationKey: "root:org"},
+			},
+			Spec: tutorialkubebuilderiov1alpha1.WidgetSpec{Foo: "reference"},
+		})
+	})
+
+	It("creates and updates the mirrored Widget in every target", func() {
+		_, err := r.Reconcile(ctx, request)
+		Expect(err).NotTo(HaveOccurred())
+		for _, c := range []client.Client{east, west} {
+			mirror := mirrored(c)
+			Expect(mirror.Spec.Foo).To(Equal("reference"))
+			Expect(mirror.Labels).To(HaveKeyWithValue("app", "widget"))
+			Expect(mirror.Annotations).To(HaveKeyWithValue(tutorialkubebuilderiov1alpha1.SourceClusterAnnotation, "root:org"))
+			Expect(mirror.Annotations).NotTo(HaveKey(logicalcluster.AnnotationKey))
+		}
+		widget := stored()
+		Expect(widget.Finalizers).To(ContainElement(tutorialkubebuilderiov1alpha1.MirrorFinalizer))
+		Expect(widget.Status.Mirrors).To(ConsistOf(
+			HaveField("Synced", true),
+			HaveField("Synced", true),
+		))
+
+		widget.Spec.Foo = "changed"
+		Expect(reference.Update(ctx, widget)).To(Succeed())
+		_, err = r.Reconcile(ctx, request)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mirrored(east).Spec.Foo).To(Equal("changed"))
+		Expect(mirrored(west).Spec.Foo).To(Equal("changed"))
+	})
+
+	It("copies the status of the first target back and keeps its own conditions", func() {
+		widget := stored()
+		widget.Status.Conditions = []metav1.Condition{{
+			Type:               tutorialkubebuilderiov1alpha1.ConditionTypeConflicted,
+			Status:             metav1.ConditionFalse,
+			Reason:             conflictReasonResolved,
+			LastTransitionTime: metav1.Now(),
+		}}
+		Expect(reference.Status().Update(ctx, widget)).To(Succeed())
+		_, err := r.Reconcile(ctx, request)
+		Expect(err).NotTo(HaveOccurred())
+
+		// The controller of the mirror cluster reports on the mirrored
+		// Widget, including a condition type owned by this controller.
+		for name, c := range map[string]client.Client{"east": east, "west": west} {
+			mirror := mirrored(c)
+			mirror.Status.Conditions = []metav1.Condition{
+				{Type: "Ready", Status: metav1.ConditionTrue, Reason: "Up", Message: "Ready in " + name, LastTransitionTime: metav1.Now()},
+				{Type: tutorialkubebuilderiov1alpha1.ConditionTypeConflicted, Status: metav1.ConditionTrue, Reason: "Remote", LastTransitionTime: metav1.Now()},
+			}
+			Expect(c.Status().Update(ctx, mirror)).To(Succeed())
+		}
+		_, err = r.Reconcile(ctx, request)
+		Expect(err).NotTo(HaveOccurred())
+
+		conditions := stored().Status.Conditions
+		ready := meta.FindStatusCondition(conditions, "Ready")
+		Expect(ready).NotTo(BeNil())
+		Expect(ready.Message).To(Equal("Ready in east"))
+		conflicted := meta.FindStatusCondition(conditions, tutorialkubebuilderiov1alpha1.ConditionTypeConflicted)
+		Expect(conflicted).NotTo(BeNil())
+		Expect(conflicted.Status).To(Equal(metav1.ConditionFalse))
+		Expect(conflicted.Reason).To(Equal(conflictReasonResolved))
+		Expect(stored().Status.Mirrors).To(HaveLen(2))
+	})
+
+	It("mirrors into the other targets when one fails and reports each", func() {
+		r.Targets[0].Client = failingClient{Client: east, err: errors.New("connection refused")}
+		_, err := r.Reconcile(ctx, request)
+		Expect(err).To(MatchError(ContainSubstring("target east: connection refused")))
+
+		Expect(mirrored(west).Spec.Foo).To(Equal("reference"))
+		Expect(stored().Status.Mirrors).To(ConsistOf(
+			And(HaveField("Target", "east"), HaveField("Synced", false), HaveField("Message", "connection refused")),
+			And(HaveField("Target", "west"), HaveField("Synced", true)),
+		))
+	})
+
+	It("mirrors into the other targets when one is unreachable and retries it later", func() {
+		r.Targets[0].Reachable = func() bool { return false }
+		Expect(r.Reconcile(ctx, request)).To(Equal(ctrl.Result{RequeueAfter: UnreachableRequeueAfter}))
+
+		Expect(mirrored(west).Spec.Foo).To(Equal("reference"))
+		Expect(east.Get(ctx, key, &tutorialkubebuilderiov1alpha1.Widget{})).NotTo(Succeed())
+		Expect(stored().Status.Mirrors).To(ConsistOf(
+			And(HaveField("Target", "east"), HaveField("Synced", false), HaveField("Message", "Mirror cluster is unreachable")),
+			And(HaveField("Target", "west"), HaveField("Synced", true)),
+		))
+	})
+})
@@ -0,0 +1,336 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
+)
+
+// DefaultDeletionTimeout is how long the finalizer waits for an unreachable
+// mirror cluster before giving up on the mirrored Widget.
+const DefaultDeletionTimeout = 10 * time.Minute
+
+// mirrorLifecycle is the handling of reference objects shared by the
+// reconcilers: releasing their mirrored copies when they are deleted or no
+// longer selected, and applying the drift policy to copies modified in a
+// target.
+type mirrorLifecycle struct {
+	// Client reads and updates reference objects.
+	Client client.Client
+
+	// Recorder records events on reference objects.
+	Recorder record.EventRecorder
+
+	// Kind names the mirrored kind in events.
+	Kind string
+
+	DeletionPolicy  tutorialkubebuilderiov1alpha1.DeletionPolicy
+	DeletionTimeout time.Duration
+	DriftPolicy     tutorialkubebuilderiov1alpha1.DriftPolicy
+
+	// Release applies policy to the mirrored copies of reference in every
+	// target.
+	Release func(ctx context.Context, reference client.Object, policy tutorialkubebuilderiov1alpha1.DeletionPolicy) error
+
+	// Unmirrored, if set, is called before the finalizer is removed from a
+	// reference object that is no longer selected, e.g. to clear its
+	// per-target status.
+	Unmirrored func(ctx context.Context, reference client.Object) error
+}
+
+// finalize applies the deletion policy to the mirrored copies of reference in
+// every target and then removes the finalizer from reference. If a target
+// cannot be reached the request is retried until the deletion timeout has
+// passed, after which the finalizer is removed anyway and a warning event is
+// recorded.
+func (l mirrorLifecycle) finalize(ctx, mirrorCtx context.Context, reference client.Object) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(reference, tutorialkubebuilderiov1alpha1.MirrorFinalizer) {
+		return ctrl.Result{}, nil
+	}
+	logger := log.FromContext(ctx)
+
+	policy := deletionPolicy(reference, l.DeletionPolicy)
+	if err := l.Release(mirrorCtx, reference, policy); err != nil {
+		timeout := l.deletionTimeout()
+		if time.Since(reference.GetDeletionTimestamp().Time) < timeout {
+			logger.Error(err, "unable to release mirrored object, retrying", "policy", policy)
+			return ctrl.Result{}, err
+		}
+		logger.Error(err, "giving up on mirrored object", "policy", policy, "timeout", timeout)
+		l.Recorder.Eventf(reference, corev1.EventTypeWarning, "MirrorCleanupFailed",
+			"Unable to apply deletion policy %s to mirrored %s within %s: %v", policy, l.Kind, timeout, err)
+	}
+
+	controllerutil.RemoveFinalizer(reference, tutorialkubebuilderiov1alpha1.MirrorFinalizer)
+	if err := l.Client.Update(ctx, reference); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	logger.V(1).Info("Released mirrored object", "policy", policy)
+	return ctrl.Result{}, nil
+}
+
+// abandon removes the finalizer from a deleted reference object whose
+// mirroring is paused by the PausedAnnotation on by, leaving its mirrored copies
+// in the targets alone. The deletion of a paused object does not wait for it to
+// be resumed.
+func (l mirrorLifecycle) abandon(ctx context.Context, reference client.Object, by string) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(reference, tutorialkubebuilderiov1alpha1.MirrorFinalizer) {
+		return ctrl.Result{}, nil
+	}
+	controllerutil.RemoveFinalizer(reference, tutorialkubebuilderiov1alpha1.MirrorFinalizer)
+	if err := l.Client.Update(ctx, reference); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	log.FromContext(ctx).V(1).Info("Mirroring is paused, left mirrored object alone", "by", by)
+	l.Recorder.Eventf(reference, corev1.EventTypeNormal, "MirrorAbandoned",
+		"Mirroring is paused by the %s annotation on %s, mirrored %s was left in the targets",
+		tutorialkubebuilderiov1alpha1.PausedAnnotation, by, l.Kind)
+	return ctrl.Result{}, nil
+}
+
+// unmirror applies the deletion policy to the mirrored copies of a reference
+// object that is no longer selected for mirroring, then removes the finalizer
+// from it. If a target cannot be reached the request is retried until the
+// deletion timeout has passed since the first failure, recorded in the
+// DeselectedAnnotation, after which the finalizer is removed anyway and a
+// warning event is recorded.
+func (l mirrorLifecycle) unmirror(ctx, mirrorCtx context.Context, reference client.Object) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(reference, tutorialkubebuilderiov1alpha1.MirrorFinalizer) {
+		return ctrl.Result{}, nil
+	}
+	logger := log.FromContext(ctx)
+
+	policy := deletionPolicy(reference, l.DeletionPolicy)
+	if err := l.Release(mirrorCtx, reference, policy); err != nil {
+		timeout := l.deletionTimeout()
+		deselected, ok := deselectedAt(reference)
+		if !ok {
+			setAnnotation(reference, tutorialkubebuilderiov1alpha1.DeselectedAnnotation, time.Now().UTC().Format(time.RFC3339))
+			if err := l.Client.Update(ctx, reference); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		if !ok || time.Since(deselected) < timeout {
+			logger.Error(err, "unable to release mirrored object, retrying", "policy", policy)
+			return ctrl.Result{}, err
+		}
+		logger.Error(err, "giving up on mirrored object", "policy", policy, "timeout", timeout)
+		l.Recorder.Eventf(reference, corev1.EventTypeWarning, "MirrorCleanupFailed",
+			"Unable to apply deletion policy %s to mirrored %s within %s: %v", policy, l.Kind, timeout, err)
+	}
+
+	if l.Unmirrored != nil {
+		if err := l.Unmirrored(ctx, reference); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	controllerutil.RemoveFinalizer(reference, tutorialkubebuilderiov1alpha1.MirrorFinalizer)
+	removeAnnotation(reference, tutorialkubebuilderiov1alpha1.DeselectedAnnotation)
+	if err := l.Client.Update(ctx, reference); err != nil {
+		return ctrl.Result{}, err
+	}
+	logger.V(1).Info("Object no longer selected, released mirrored object", "policy", policy)
+	return ctrl.Result{}, nil
+}
+
+// track adds the finalizer to a reference object selected for mirroring and
+// drops the DeselectedAnnotation left by an earlier deselection.
+func (l mirrorLifecycle) track(ctx context.Context, reference client.Object) error {
+	added := controllerutil.AddFinalizer(reference, tutorialkubebuilderiov1alpha1.MirrorFinalizer)
+	if !removeAnnotation(reference, tutorialkubebuilderiov1alpha1.DeselectedAnnotation) && !added {
+		return nil
+	}
+	return l.Client.Update(ctx, reference)
+}
+
+// deselectedAt returns the time recorded in the DeselectedAnnotation of
+// reference, if any. An unparsable time counts as none.
+func deselectedAt(reference client.Object) (time.Time, bool) {
+	value, ok := reference.GetAnnotations()[tutorialkubebuilderiov1alpha1.DeselectedAnnotation]
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	return t, err == nil
+}
+
+func setAnnotation(obj client.Object, key, value string) {
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[key] = value
+	obj.SetAnnotations(annotations)
+}
+
+// removeAnnotation removes the annotation key from obj and reports whether it
+// was set.
+func removeAnnotation(obj client.Object, key string) bool {
+	annotations := obj.GetAnnotations()
+	if _, ok := annotations[key]; !ok {
+		return false
+	}
+	delete(annotations, key)
+	obj.SetAnnotations(annotations)
+	return true
+}
+
+func (l mirrorLifecycle) deletionTimeout() time.Duration {
+	if l.DeletionTimeout == 0 {
+		return DefaultDeletionTimeout
+	}
+	return l.DeletionTimeout
+}
+
+// lifecycle returns the handling of reference Widgets shared with the
+// MirrorReconciler.
+func (r *WidgetReconciler) lifecycle() mirrorLifecycle {
+	return mirrorLifecycle{
+		Client:          r.Client,
+		Recorder:        r.Recorder,
+		Kind:            "Widget",
+		DeletionPolicy:  r.DeletionPolicy,
+		DeletionTimeout: r.DeletionTimeout,
+		DriftPolicy:     r.DriftPolicy,
+		Release: func(ctx context.Context, reference client.Object, policy tutorialkubebuilderiov1alpha1.DeletionPolicy) error {
+			return r.releaseMirrors(ctx, reference.(*tutorialkubebuilderiov1alpha1.Widget), policy)
+		},
+		Unmirrored: func(ctx context.Context, reference client.Object) error {
+			widget := reference.(*tutorialkubebuilderiov1alpha1.Widget)
+			if len(widget.Status.Mirrors) == 0 {
+				return nil
+			}
+			widget.Status.Mirrors = nil
+			return r.Status().Update(ctx, widget)
+		},
+	}
+}
+
+// releaseMirrors applies policy to the mirrored copies of widget in every
+// target. Once they are deleted, the ConfigMaps and Secrets they referenced are
+// deleted as well unless other Widgets reference them.
+func (r *WidgetReconciler) releaseMirrors(ctx context.Context, widget *tutorialkubebuilderiov1alpha1.Widget, policy tutorialkubebuilderiov1alpha1.DeletionPolicy) error {
+	if err := releaseMirrors(ctx, r.Targets, widget, func() client.Object {
+		return &tutorialkubebuilderiov1alpha1.Widget{}
+	}, policy); err != nil || policy != tutorialkubebuilderiov1alpha1.DeletionPolicyDelete {
+		return err
+	}
+	var errs []error
+	for _, target := range r.Targets {
+		key, err := mirrorKey(target, widget)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("target %s: %w", target.Name, err))
+			continue
+		}
+		if err := pruneDependents(ctx, target, key.Namespace, key, nil); err != nil {
+			errs = append(errs, fmt.Errorf("target %s: %w", target.Name, err))
+		}
+	}
+	return kerrors.NewAggregate(errs)
+}
+
+// releaseMirrors applies policy to the mirrored copies of reference in every
+// target. newMirror returns an empty object of the mirrored kind.
+func releaseMirrors(ctx context.Context, targets []MirrorTarget, reference client.Object, newMirror func() client.Object, policy tutorialkubebuilderiov1alpha1.DeletionPolicy) error {
+	var errs []error
+	for _, target := range targets {
+		if err := releaseMirror(ctx, target, reference, newMirror(), policy); err != nil {
+			errs = append(errs, fmt.Errorf("target %s: %w", target.Name, err))
+		}
+	}
+	return kerrors.NewAggregate(errs)
+}
+
+// deletionPolicy returns the deletion policy for widget, honouring the
+// per-Widget annotation over the reconciler wide setting.
+func (r *WidgetReconciler) deletionPolicy(widget *tutorialkubebuilderiov1alpha1.Widget) tutorialkubebuilderiov1alpha1.DeletionPolicy {
+	return deletionPolicy(widget, r.DeletionPolicy)
+}
+
+// deletionPolicy returns the deletion policy for reference, honouring its
+// DeletionPolicyAnnotation over policy, which defaults to DeletionPolicyDelete.
+func deletionPolicy(reference client.Object, policy tutorialkubebuilderiov1alpha1.DeletionPolicy) tutorialkubebuilderiov1alpha1.DeletionPolicy {
+	if p := tutorialkubebuilderiov1alpha1.DeletionPolicy(reference.GetAnnotations()[tutorialkubebuilderiov1alpha1.DeletionPolicyAnnotation]); p.IsValid() {
+		return p
+	}
+	if policy.IsValid() {
+		return policy
+	}
+	return tutorialkubebuilderiov1alpha1.DeletionPolicyDelete
+}
+
+// releaseMirror applies policy to the copy of reference mirrored into target,
+// reading it into mirror, an empty object of the mirrored kind. A mirrored
+// object that no longer exists, that was copied from another reference object,
+// or that is a dependent of Widgets, is left alone.
+func releaseMirror(ctx context.Context, target MirrorTarget, reference, mirror client.Object, policy tutorialkubebuilderiov1alpha1.DeletionPolicy) error {
+	if policy == tutorialkubebuilderiov1alpha1.DeletionPolicyOrphan {
+		return nil
+	}
+	if !target.reachable() {
+		// Retried until the target is back or the deletion timeout passes.
+		return errUnreachable
+	}
+	key, err := mirrorKey(target, reference)
+	if err != nil {
+		return err
+	}
+	if err := target.Client.Get(ctx, key, mirror); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if err := checkOrigin(mirror, reference); err != nil {
+		// Someone else's object occupies the mirror key; leave it alone.
+		return nil
+	}
+	if isDependent(mirror) {
+		// Released by pruneDependents once no Widget references it.
+		return nil
+	}
+	return applyDeletionPolicy(ctx, target.Client, mirror, policy)
+}
+
+// applyDeletionPolicy deletes mirror through c, or labels it as retained,
+// according to policy.
+func applyDeletionPolicy(ctx context.Context, c client.Client, mirror client.Object, policy tutorialkubebuilderiov1alpha1.DeletionPolicy) error {
+	switch policy {
+	case tutorialkubebuilderiov1alpha1.DeletionPolicyOrphan:
+		return nil
+	case tutorialkubebuilderiov1alpha1.DeletionPolicyRetain:
+		patch := client.MergeFrom(mirror.DeepCopyObject().(client.Object))
+		labels := mirror.GetLabels()
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[tutorialkubebuilderiov1alpha1.RetainedLabel] = "true"
+		mirror.SetLabels(labels)
+		return client.IgnoreNotFound(c.Patch(ctx, mirror, patch))
+	}
+	uid := mirror.GetUID()
+	return client.IgnoreNotFound(c.Delete(ctx, mirror, client.Preconditions{UID: &uid}))
+}
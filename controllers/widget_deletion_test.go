@@ -0,0 +1,226 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
+)
+
+var _ = Describe("Widget deletion", func() {
+	var (
+		ctx       context.Context
+		scheme    *runtime.Scheme
+		reference client.Client
+		mirror    client.Client
+		recorder  *record.FakeRecorder
+		r         *WidgetReconciler
+	)
+
+	key := client.ObjectKey{Namespace: "default", Name: "widget"}
+	request := ctrl.Request{NamespacedName: key}
+	// deleting returns a reference Widget with the finalizer, deleted since.
+	deleting := func(since time.Duration, annotations map[string]string) *tutorialkubebuilderiov1alpha1.Widget {
+		deleted := metav1.NewTime(time.Now().Add(-since))
+		return &tutorialkubebuilderiov1alpha1.Widget{ObjectMeta: metav1.ObjectMeta{
+			Name:              key.Name,
+			Namespace:         key.Namespace,
+			Annotations:       annotations,
+			Finalizers:        []string{tutorialkubebuilderiov1alpha1.MirrorFinalizer},
+			DeletionTimestamp: &deleted,
+		}}
+	}
+	// deselected returns a reference Widget with the finalizer that opted out
+	// of mirroring.
+	deselected := func(annotations map[string]string) *tutorialkubebuilderiov1alpha1.Widget {
+		annotations[tutorialkubebuilderiov1alpha1.MirrorAnnotation] = "false"
+		return &tutorialkubebuilderiov1alpha1.Widget{ObjectMeta: metav1.ObjectMeta{
+			Name:        key.Name,
+			Namespace:   key.Namespace,
+			Annotations: annotations,
+			Finalizers:  []string{tutorialkubebuilderiov1alpha1.MirrorFinalizer},
+		}}
+	}
+	stored := func() *tutorialkubebuilderiov1alpha1.Widget {
+		var w tutorialkubebuilderiov1alpha1.Widget
+		Expect(reference.Get(ctx, key, &w)).To(Succeed())
+		return &w
+	}
+	setup := func(widget *tutorialkubebuilderiov1alpha1.Widget) {
+		reference = fake.NewClientBuilder().WithScheme(scheme).WithObjects(widget).Build()
+		r.Client = reference
+	}
+	referenceGone := func() bool {
+		return apierrors.IsNotFound(reference.Get(ctx, key, &tutorialkubebuilderiov1alpha1.Widget{}))
+	}
+	getMirrored := func() (*tutorialkubebuilderiov1alpha1.Widget, error) {
+		var w tutorialkubebuilderiov1alpha1.Widget
+		err := mirror.Get(ctx, key, &w)
+		return &w, err
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme = runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(tutorialkubebuilderiov1alpha1.AddToScheme(scheme)).To(Succeed())
+		mirror = fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			&tutorialkubebuilderiov1alpha1.Widget{ObjectMeta: metav1.ObjectMeta{
+				Name:      key.Name,
+				Namespace: key.Namespace,
+				Annotations: map[string]string{
+					tutorialkubebuilderiov1alpha1.SourceNamespaceAnnotation: key.Namespace,
+					tutorialkubebuilderiov1alpha1.SourceNameAnnotation:      key.Name,
+				},
+			}},
+		).Build()
+		recorder = record.NewFakeRecorder(10)
+		r = &WidgetReconciler{
+			Scheme:          scheme,
+			Targets:         []MirrorTarget{{Name: "east", Client: mirror}},
+			Recorder:        recorder,
+			DeletionTimeout: time.Minute,
+		}
+	})
+
+	It("deletes the mirrored Widget with the Delete policy", func() {
+		setup(deleting(0, nil))
+		Expect(r.Reconcile(ctx, request)).To(Equal(ctrl.Result{}))
+		_, err := getMirrored()
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		Expect(referenceGone()).To(BeTrue())
+	})
+
+	It("leaves the mirrored Widget alone with the Orphan policy", func() {
+		r.DeletionPolicy = tutorialkubebuilderiov1alpha1.DeletionPolicyOrphan
+		setup(deleting(0, nil))
+		Expect(r.Reconcile(ctx, request)).To(Equal(ctrl.Result{}))
+		mirrored, err := getMirrored()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mirrored.Labels).NotTo(HaveKey(tutorialkubebuilderiov1alpha1.RetainedLabel))
+		Expect(referenceGone()).To(BeTrue())
+	})
+
+	It("labels the mirrored Widget as retained with the Retain policy", func() {
+		r.DeletionPolicy = tutorialkubebuilderiov1alpha1.DeletionPolicyRetain
+		setup(deleting(0, nil))
+		Expect(r.Reconcile(ctx, request)).To(Equal(ctrl.Result{}))
+		mirrored, err := getMirrored()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mirrored.Labels).To(HaveKeyWithValue(tutorialkubebuilderiov1alpha1.RetainedLabel, "true"))
+		Expect(referenceGone()).To(BeTrue())
+	})
+
+	It("honours the deletion policy annotation over the reconciler policy", func() {
+		setup(deleting(0, map[string]string{
+			tutorialkubebuilderiov1alpha1.DeletionPolicyAnnotation: string(tutorialkubebuilderiov1alpha1.DeletionPolicyRetain),
+		}))
+		Expect(r.Reconcile(ctx, request)).To(Equal(ctrl.Result{}))
+		mirrored, err := getMirrored()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mirrored.Labels).To(HaveKeyWithValue(tutorialkubebuilderiov1alpha1.RetainedLabel, "true"))
+	})
+
+	It("removes the finalizer when no targets are configured", func() {
+		r.Targets = nil
+		setup(deleting(0, nil))
+		Expect(r.Reconcile(ctx, request)).To(Equal(ctrl.Result{}))
+		Expect(referenceGone()).To(BeTrue())
+		_, err := getMirrored()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("keeps the finalizer while an unreachable target is within the deletion timeout", func() {
+		r.Targets[0].Reachable = func() bool { return false }
+		setup(deleting(0, nil))
+		_, err := r.Reconcile(ctx, request)
+		Expect(err).To(MatchError(ContainSubstring("target east")))
+		Expect(referenceGone()).To(BeFalse())
+		Expect(recorder.Events).To(BeEmpty())
+	})
+
+	It("gives up on an unreachable target after the deletion timeout", func() {
+		r.Targets[0].Reachable = func() bool { return false }
+		setup(deleting(2*time.Minute, nil))
+		Expect(r.Reconcile(ctx, request)).To(Equal(ctrl.Result{}))
+		Expect(referenceGone()).To(BeTrue())
+		Expect(recorder.Events).To(Receive(ContainSubstring("MirrorCleanupFailed")))
+		_, err := getMirrored()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("releases the mirrored Widget once the Widget is no longer selected", func() {
+		setup(deselected(map[string]string{}))
+		Expect(r.Reconcile(ctx, request)).To(Equal(ctrl.Result{}))
+		_, err := getMirrored()
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		Expect(stored().Finalizers).To(BeEmpty())
+	})
+
+	It("records when the release of a deselected Widget started failing", func() {
+		r.Targets[0].Reachable = func() bool { return false }
+		setup(deselected(map[string]string{}))
+		_, err := r.Reconcile(ctx, request)
+		Expect(err).To(MatchError(ContainSubstring("target east")))
+
+		widget := stored()
+		Expect(widget.Finalizers).To(ContainElement(tutorialkubebuilderiov1alpha1.MirrorFinalizer))
+		Expect(widget.Annotations).To(HaveKey(tutorialkubebuilderiov1alpha1.DeselectedAnnotation))
+
+		_, err = r.Reconcile(ctx, request)
+		Expect(err).To(HaveOccurred())
+		Expect(stored().Finalizers).NotTo(BeEmpty())
+		Expect(recorder.Events).To(BeEmpty())
+	})
+
+	It("gives up on an unreachable target after the deletion timeout since deselection", func() {
+		r.Targets[0].Reachable = func() bool { return false }
+		setup(deselected(map[string]string{
+			tutorialkubebuilderiov1alpha1.DeselectedAnnotation: time.Now().Add(-2 * time.Minute).UTC().Format(time.RFC3339),
+		}))
+		Expect(r.Reconcile(ctx, request)).To(Equal(ctrl.Result{}))
+
+		widget := stored()
+		Expect(widget.Finalizers).To(BeEmpty())
+		Expect(widget.Annotations).NotTo(HaveKey(tutorialkubebuilderiov1alpha1.DeselectedAnnotation))
+		Expect(recorder.Events).To(Receive(ContainSubstring("MirrorCleanupFailed")))
+	})
+
+	It("forgets an earlier deselection once the Widget is selected again", func() {
+		r.Targets[0].Reachable = func() bool { return false }
+		widget := deselected(map[string]string{
+			tutorialkubebuilderiov1alpha1.DeselectedAnnotation: time.Now().UTC().Format(time.RFC3339),
+		})
+		delete(widget.Annotations, tutorialkubebuilderiov1alpha1.MirrorAnnotation)
+		setup(widget)
+		Expect(r.Reconcile(ctx, request)).To(Equal(ctrl.Result{RequeueAfter: UnreachableRequeueAfter}))
+		Expect(stored().Annotations).NotTo(HaveKey(tutorialkubebuilderiov1alpha1.DeselectedAnnotation))
+	})
+})
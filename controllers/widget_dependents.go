@@ -0,0 +1,303 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kcp-dev/logicalcluster/v2"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
+)
+
+// Kinds of the objects a Widget references.
+const (
+	kindConfigMap = "ConfigMap"
+	kindSecret    = "Secret"
+)
+
+// dependentKey identifies a ConfigMap or Secret referenced by Widgets.
+type dependentKey struct {
+	Kind string
+	client.ObjectKey
+}
+
+// widgetDependents returns the ConfigMaps and Secrets referenced by widget,
+// located in namespace.
+func widgetDependents(widget *tutorialkubebuilderiov1alpha1.Widget, namespace string) []dependentKey {
+	keys := make([]dependentKey, 0, len(widget.Spec.ConfigMapRefs)+len(widget.Spec.SecretRefs))
+	for _, ref := range widget.Spec.ConfigMapRefs {
+		keys = append(keys, dependentKey{Kind: kindConfigMap, ObjectKey: client.ObjectKey{Namespace: namespace, Name: ref.Name}})
+	}
+	for _, ref := range widget.Spec.SecretRefs {
+		keys = append(keys, dependentKey{Kind: kindSecret, ObjectKey: client.ObjectKey{Namespace: namespace, Name: ref.Name}})
+	}
+	return keys
+}
+
+// newDependent returns an empty object of the given dependent kind.
+func newDependent(kind string) client.Object {
+	if kind == kindSecret {
+		return &corev1.Secret{}
+	}
+	return &corev1.ConfigMap{}
+}
+
+// isDependent reports whether mirror is a ConfigMap or Secret copied along with
+// the Widgets referencing it. Dependents are written and released on behalf of
+// those Widgets only, even if their kind is also mirrored on its own.
+func isDependent(mirror client.Object) bool {
+	return mirror.GetLabels()[tutorialkubebuilderiov1alpha1.DependentLabel] == "true"
+}
+
+// mirrorDependents copies the ConfigMaps and Secrets referenced by widget, read
+// through c, into namespace of target. They are copied before widget itself,
+// so a missing one fails the mirroring of widget. It returns the fields of the
+// copies owned by other field managers, which are only taken over if force is
+// set. ctx must carry the logical cluster of widget, mirrorCtx must not.
+func mirrorDependents(ctx, mirrorCtx context.Context, c client.Reader, widget *tutorialkubebuilderiov1alpha1.Widget, target MirrorTarget, namespace string, force bool) ([]string, error) {
+	var conflicts []string
+	for _, key := range widgetDependents(widget, widget.Namespace) {
+		source := newDependent(key.Kind)
+		if err := c.Get(ctx, key.ObjectKey, source); err != nil {
+			if apierrors.IsNotFound(err) {
+				return conflicts, fmt.Errorf("referenced %s %s not found", key.Kind, key.Name)
+			}
+			return conflicts, err
+		}
+		fields, err := writeDependent(mirrorCtx, target, desiredDependent(source, namespace), source, force)
+		if err != nil {
+			return conflicts, fmt.Errorf("unable to mirror %s %s: %w", key.Kind, key.Name, err)
+		}
+		for _, field := range fields {
+			conflicts = append(conflicts, fmt.Sprintf("%s %s: %s", key.Kind, key.Name, field))
+		}
+	}
+	return conflicts, nil
+}
+
+// desiredDependent returns the copy of source, a ConfigMap or Secret, that
+// should exist in namespace of a mirror cluster, labelled as a dependent and
+// stamped with the origin of source and the hash of its content.
+func desiredDependent(source client.Object, namespace string) client.Object {
+	objectMeta := metav1.ObjectMeta{
+		Name:        source.GetName(),
+		Namespace:   namespace,
+		Labels:      copyStringMap(source.GetLabels()),
+		Annotations: copyStringMap(source.GetAnnotations()),
+	}
+	if objectMeta.Labels == nil {
+		objectMeta.Labels = map[string]string{}
+	}
+	objectMeta.Labels[tutorialkubebuilderiov1alpha1.DependentLabel] = "true"
+	delete(objectMeta.Annotations, logicalcluster.AnnotationKey)
+	delete(objectMeta.Annotations, corev1.LastAppliedConfigAnnotation)
+	for _, annotation := range mirrorAnnotations {
+		delete(objectMeta.Annotations, annotation)
+	}
+
+	var desired client.Object
+	switch source := source.(type) {
+	case *corev1.Secret:
+		desired = &corev1.Secret{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: kindSecret},
+			ObjectMeta: objectMeta,
+			Type:       source.Type,
+			Data:       source.Data,
+		}
+	case *corev1.ConfigMap:
+		desired = &corev1.ConfigMap{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: kindConfigMap},
+			ObjectMeta: objectMeta,
+			Data:       source.Data,
+			BinaryData: source.BinaryData,
+		}
+	}
+	setOrigin(desired, source)
+	desired.GetAnnotations()[tutorialkubebuilderiov1alpha1.ContentHashAnnotation] = dependentHash(desired)
+	return desired
+}
+
+// writeDependent server-side applies desired, the copy of source, into target
+// unless the current copy is up to date according to its
+// ContentHashAnnotation. An existing object that was not copied from source by
+// the controller is left alone.
+func writeDependent(ctx context.Context, target MirrorTarget, desired, source client.Object, force bool) ([]string, error) {
+	kind := desired.GetObjectKind().GroupVersionKind().Kind
+	current := newDependent(kind)
+	if err := target.Client.Get(ctx, client.ObjectKeyFromObject(desired), current); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+	} else {
+		if _, ok := current.GetAnnotations()[tutorialkubebuilderiov1alpha1.SourceNameAnnotation]; !ok {
+			return nil, fmt.Errorf("%s %s already exists and was not mirrored by the controller", kind, client.ObjectKeyFromObject(current))
+		}
+		if err := checkOrigin(current, source); err != nil {
+			return nil, err
+		}
+		if hashUpToDate(desired, current, func() string { return dependentHash(current) }) {
+			mirrorWrites.WithLabelValues(target.Name, kind, writeResultSkipped).Inc()
+			return nil, nil
+		}
+	}
+	conflicts, err := applyMirror(ctx, target.Client, desired, force)
+	if err == nil && (force || len(conflicts) == 0) {
+		mirrorWrites.WithLabelValues(target.Name, kind, writeResultPerformed).Inc()
+	}
+	return conflicts, err
+}
+
+// dependentHash returns a hash over the mirrored content of a ConfigMap or
+// Secret: its data, labels and annotations.
+func dependentHash(obj client.Object) string {
+	switch obj := obj.(type) {
+	case *corev1.Secret:
+		return hashContent(obj, struct {
+			Type corev1.SecretType `json:"type,omitempty"`
+			Data map[string][]byte `json:"data,omitempty"`
+		}{obj.Type, obj.Data})
+	case *corev1.ConfigMap:
+		return hashContent(obj, struct {
+			Data       map[string]string `json:"data,omitempty"`
+			BinaryData map[string][]byte `json:"binaryData,omitempty"`
+		}{obj.Data, obj.BinaryData})
+	}
+	return ""
+}
+
+// unreferencedDependents returns the dependents in namespace of c, or in every
+// namespace if namespace is empty, that no Widget references. The references
+// of the Widget with key widget are taken from keep instead, because the
+// Widget was just written or deleted and may not be seen by c yet.
+func unreferencedDependents(ctx context.Context, c client.Reader, namespace string, widget client.ObjectKey, keep []dependentKey) ([]client.Object, error) {
+	referenced := map[dependentKey]bool{}
+	for _, key := range keep {
+		referenced[key] = true
+	}
+	var widgets tutorialkubebuilderiov1alpha1.WidgetList
+	if err := c.List(ctx, &widgets, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	for i := range widgets.Items {
+		w := &widgets.Items[i]
+		if client.ObjectKeyFromObject(w) == widget {
+			continue
+		}
+		for _, key := range widgetDependents(w, w.Namespace) {
+			referenced[key] = true
+		}
+	}
+
+	selector := client.MatchingLabels{tutorialkubebuilderiov1alpha1.DependentLabel: "true"}
+	var unreferenced []client.Object
+	var configMaps corev1.ConfigMapList
+	if err := c.List(ctx, &configMaps, client.InNamespace(namespace), selector); err != nil {
+		return nil, err
+	}
+	for i := range configMaps.Items {
+		cm := &configMaps.Items[i]
+		if !referenced[dependentKey{Kind: kindConfigMap, ObjectKey: client.ObjectKeyFromObject(cm)}] && cm.DeletionTimestamp.IsZero() {
+			cm.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind(kindConfigMap))
+			unreferenced = append(unreferenced, cm)
+		}
+	}
+	var secrets corev1.SecretList
+	if err := c.List(ctx, &secrets, client.InNamespace(namespace), selector); err != nil {
+		return nil, err
+	}
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		if !referenced[dependentKey{Kind: kindSecret, ObjectKey: client.ObjectKeyFromObject(secret)}] && secret.DeletionTimestamp.IsZero() {
+			secret.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind(kindSecret))
+			unreferenced = append(unreferenced, secret)
+		}
+	}
+	return unreferenced, nil
+}
+
+// pruneDependents deletes the dependents in namespace of target that no Widget
+// references anymore. widget and keep are as for unreferencedDependents.
+func pruneDependents(ctx context.Context, target MirrorTarget, namespace string, widget client.ObjectKey, keep []dependentKey) error {
+	unreferenced, err := unreferencedDependents(ctx, target.Client, namespace, widget, keep)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, obj := range unreferenced {
+		if err := deleteDependent(ctx, target.Client, obj); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		log.FromContext(ctx).Info("Deleted unreferenced mirrored object", "target", target.Name,
+			"kind", obj.GetObjectKind().GroupVersionKind().Kind, "mirror", client.ObjectKeyFromObject(obj))
+	}
+	return kerrors.NewAggregate(errs)
+}
+
+// deleteDependent deletes obj through c unless it was replaced in the meantime.
+func deleteDependent(ctx context.Context, c client.Client, obj client.Object) error {
+	uid := obj.GetUID()
+	if err := c.Delete(ctx, obj, client.Preconditions{UID: &uid}); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("unable to delete %s %s: %w", obj.GetObjectKind().GroupVersionKind().Kind, client.ObjectKeyFromObject(obj), err)
+	}
+	return nil
+}
+
+// DependentEventHandler enqueues the reference Widgets, read through c, that
+// reference a ConfigMap or Secret, so that changes to it are mirrored.
+func DependentEventHandler(c client.Reader) handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+		cluster := logicalcluster.From(obj)
+		ctx := context.Background()
+		if !cluster.Empty() {
+			ctx = logicalcluster.WithCluster(ctx, cluster)
+		}
+		key := dependentKey{Kind: kindConfigMap, ObjectKey: client.ObjectKeyFromObject(obj)}
+		if _, ok := obj.(*corev1.Secret); ok {
+			key.Kind = kindSecret
+		}
+		var widgets tutorialkubebuilderiov1alpha1.WidgetList
+		if err := c.List(ctx, &widgets, client.InNamespace(obj.GetNamespace())); err != nil {
+			log.FromContext(ctx).Error(err, "unable to list reference Widgets", "namespace", obj.GetNamespace())
+			return nil
+		}
+		var requests []reconcile.Request
+		for i := range widgets.Items {
+			w := &widgets.Items[i]
+			for _, dependent := range widgetDependents(w, w.Namespace) {
+				if dependent == key {
+					requests = append(requests, reconcile.Request{
+						ClusterName:    cluster.String(),
+						NamespacedName: client.ObjectKeyFromObject(w),
+					})
+					break
+				}
+			}
+		}
+		return requests
+	})
+}
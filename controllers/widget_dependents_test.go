@@ -0,0 +1,129 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
+)
+
+var _ = Describe("Widget dependents", func() {
+	var (
+		ctx    context.Context
+		scheme *runtime.Scheme
+		mirror client.Client
+		target MirrorTarget
+	)
+
+	dependent := func(name string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{tutorialkubebuilderiov1alpha1.DependentLabel: "true"},
+			Annotations: map[string]string{
+				tutorialkubebuilderiov1alpha1.SourceNamespaceAnnotation: "default",
+				tutorialkubebuilderiov1alpha1.SourceNameAnnotation:      name,
+			},
+		}}
+	}
+	exists := func(name string) bool {
+		var cm corev1.ConfigMap
+		return mirror.Get(ctx, client.ObjectKey{Namespace: "default", Name: name}, &cm) == nil
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme = runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(tutorialkubebuilderiov1alpha1.AddToScheme(scheme)).To(Succeed())
+		mirror = fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			&tutorialkubebuilderiov1alpha1.Widget{
+				ObjectMeta: metav1.ObjectMeta{Name: "live", Namespace: "default"},
+				Spec: tutorialkubebuilderiov1alpha1.WidgetSpec{
+					ConfigMapRefs: []corev1.LocalObjectReference{{Name: "used"}},
+				},
+			},
+			dependent("used"),
+			dependent("unused"),
+			&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "unmanaged", Namespace: "default"}},
+		).Build()
+		target = MirrorTarget{Name: "east", Client: mirror}
+	})
+
+	It("copies the data of referenced objects and labels the copies", func() {
+		source := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "credentials", Namespace: "tenant", Labels: map[string]string{"app": "widget"}},
+			Type:       corev1.SecretTypeOpaque,
+			Data:       map[string][]byte{"password": []byte("hunter2")},
+		}
+		desired, ok := desiredDependent(source, "default").(*corev1.Secret)
+		Expect(ok).To(BeTrue())
+		Expect(desired.Namespace).To(Equal("default"))
+		Expect(desired.Data).To(Equal(source.Data))
+		Expect(desired.Type).To(Equal(corev1.SecretTypeOpaque))
+		Expect(desired.Labels).To(HaveKeyWithValue("app", "widget"))
+		Expect(desired.Labels).To(HaveKeyWithValue(tutorialkubebuilderiov1alpha1.DependentLabel, "true"))
+		Expect(desired.Annotations).To(HaveKeyWithValue(tutorialkubebuilderiov1alpha1.SourceNamespaceAnnotation, "tenant"))
+	})
+
+	It("leaves objects it did not mirror alone", func() {
+		source := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "unmanaged", Namespace: "default"}}
+		_, err := writeDependent(ctx, target, desiredDependent(source, "default"), source, true)
+		Expect(err).To(MatchError(ContainSubstring("was not mirrored by the controller")))
+	})
+
+	It("deletes copies no Widget references", func() {
+		Expect(pruneDependents(ctx, target, "default", client.ObjectKey{}, nil)).To(Succeed())
+		Expect(exists("used")).To(BeTrue())
+		Expect(exists("unused")).To(BeFalse())
+		Expect(exists("unmanaged")).To(BeTrue())
+	})
+
+	It("takes the references of a Widget just written from keep", func() {
+		keep := []dependentKey{{Kind: kindConfigMap, ObjectKey: client.ObjectKey{Namespace: "default", Name: "unused"}}}
+		Expect(pruneDependents(ctx, target, "default", client.ObjectKey{Namespace: "default", Name: "live"}, keep)).To(Succeed())
+		Expect(exists("used")).To(BeFalse())
+		Expect(exists("unused")).To(BeTrue())
+	})
+
+	It("sweeps copies no Widget references", func() {
+		gc := &MirrorGarbageCollector{
+			Client:         fake.NewClientBuilder().WithScheme(scheme).Build(),
+			Kinds:          []schema.GroupVersionKind{tutorialkubebuilderiov1alpha1.GroupVersion.WithKind("Widget")},
+			Targets:        []MirrorTarget{target},
+			DeletionPolicy: tutorialkubebuilderiov1alpha1.DeletionPolicyOrphan,
+			ReportOnly:     true,
+		}
+		Expect(gc.Sweep(ctx)).To(Succeed())
+		Expect(exists("unused")).To(BeTrue())
+
+		gc.ReportOnly = false
+		Expect(gc.Sweep(ctx)).To(Succeed())
+		Expect(exists("used")).To(BeTrue())
+		Expect(exists("unused")).To(BeFalse())
+	})
+})
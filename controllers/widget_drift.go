@@ -0,0 +1,235 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/kcp-dev/logicalcluster/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
+)
+
+// contentHash returns a hash over the mirrored content of widget: its spec,
+// labels and annotations, excluding the ContentHashAnnotation itself.
+func contentHash(widget *tutorialkubebuilderiov1alpha1.Widget) string {
+	return hashContent(widget, widget.Spec)
+}
+
+// hashContent returns a hash over the labels and annotations of obj applied by
+// the controller, excluding the ContentHashAnnotation, and content, the rest of
+// the mirrored content of obj.
+func hashContent(obj client.Object, content interface{}) string {
+	labels, annotations := appliedMetadata(obj)
+	annotations = copyStringMap(annotations)
+	delete(annotations, tutorialkubebuilderiov1alpha1.ContentHashAnnotation)
+
+	// The content is encoded as spec so that hashes recorded on mirrored
+	// Widgets before other kinds were mirrored stay valid.
+	encoded := struct {
+		Labels      map[string]string `json:"labels,omitempty"`
+		Annotations map[string]string `json:"annotations,omitempty"`
+		Content     interface{}       `json:"spec"`
+	}{
+		Labels:      labels,
+		Annotations: annotations,
+		Content:     content,
+	}
+	// Mirrored content was decoded from JSON and encodes again; map keys are
+	// sorted.
+	data, _ := json.Marshal(encoded)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// mirrorDrifted reports whether mirror was modified since the controller last
+// wrote it. Mirrored Widgets without a ContentHashAnnotation were not written by
+// the controller yet and are never considered drifted.
+func mirrorDrifted(mirror *tutorialkubebuilderiov1alpha1.Widget) bool {
+	hash, ok := mirror.Annotations[tutorialkubebuilderiov1alpha1.ContentHashAnnotation]
+	return ok && hash != contentHash(mirror)
+}
+
+// driftPolicy returns the drift policy for widget, honouring the per-Widget
+// annotation over the reconciler wide setting.
+func (r *WidgetReconciler) driftPolicy(widget *tutorialkubebuilderiov1alpha1.Widget) tutorialkubebuilderiov1alpha1.DriftPolicy {
+	return driftPolicy(widget, r.DriftPolicy)
+}
+
+// driftPolicy returns the drift policy for reference, honouring its
+// DriftPolicyAnnotation over policy, which defaults to DriftPolicyRevert.
+func driftPolicy(reference client.Object, policy tutorialkubebuilderiov1alpha1.DriftPolicy) tutorialkubebuilderiov1alpha1.DriftPolicy {
+	if p := tutorialkubebuilderiov1alpha1.DriftPolicy(reference.GetAnnotations()[tutorialkubebuilderiov1alpha1.DriftPolicyAnnotation]); p.IsValid() {
+		return p
+	}
+	if policy.IsValid() {
+		return policy
+	}
+	return tutorialkubebuilderiov1alpha1.DriftPolicyRevert
+}
+
+// Reasons reported in the Drifted condition.
+const (
+	driftReasonIgnored  = "Ignored"
+	driftReasonAdopted  = "Adopted"
+	driftReasonReverted = "Reverted"
+	driftReasonInSync   = "InSync"
+)
+
+// handleDrift records that mirror was modified in target and applies the drift
+// policy of widget. It reports whether the mirrored Widget should still be
+// written, and the reason to summarise in the Drifted condition.
+func (r *WidgetReconciler) handleDrift(ctx context.Context, widget, mirror *tutorialkubebuilderiov1alpha1.Widget, target MirrorTarget) (bool, string, error) {
+	reported := meta.IsStatusConditionTrue(widget.Status.Conditions, tutorialkubebuilderiov1alpha1.ConditionTypeDrifted)
+	return r.lifecycle().handleDrift(ctx, widget, target, reported, func() {
+		adoptMirror(widget, mirror)
+	})
+}
+
+// handleDrift records that the copy of reference was modified in target, unless
+// reported, and applies the drift policy of reference, calling adopt to copy
+// the mirrored content into reference under DriftPolicyAdopt. Copies in a
+// target with transforms differ from reference by design and are reverted
+// instead of adopted. It reports whether the mirrored object should still be
+// written, and the reason to summarise in the Drifted condition.
+func (l mirrorLifecycle) handleDrift(ctx context.Context, reference client.Object, target MirrorTarget, reported bool, adopt func()) (bool, string, error) {
+	policy := driftPolicy(reference, l.DriftPolicy)
+	if policy == tutorialkubebuilderiov1alpha1.DriftPolicyAdopt && len(target.Transforms) > 0 {
+		// Adopting would copy the transformed content into reference.
+		policy = tutorialkubebuilderiov1alpha1.DriftPolicyRevert
+	}
+	if !reported {
+		l.Recorder.Eventf(reference, corev1.EventTypeWarning, "MirrorDrifted",
+			"Mirrored %s was modified in target %s, applying drift policy %s", l.Kind, target.Name, policy)
+	}
+
+	switch policy {
+	case tutorialkubebuilderiov1alpha1.DriftPolicyIgnore:
+		return false, driftReasonIgnored, nil
+	case tutorialkubebuilderiov1alpha1.DriftPolicyAdopt:
+		if target.DryRun {
+			// Changes in a dry-run target are not copied to the reference
+			// cluster.
+			log.FromContext(ctx).Info("Dry run: would adopt mirrored object", "target", target.Name)
+			return false, driftReasonIgnored, nil
+		}
+		adopt()
+		if err := l.Client.Update(ctx, reference); err != nil {
+			return false, "", err
+		}
+		return true, driftReasonAdopted, nil
+	default:
+		return true, driftReasonReverted, nil
+	}
+}
+
+// adoptMirror copies the mirrored content of mirror into widget.
+func adoptMirror(widget, mirror *tutorialkubebuilderiov1alpha1.Widget) {
+	widget.Labels = copyStringMap(mirror.Labels)
+	widget.Annotations = adoptedAnnotations(widget, mirror)
+	widget.Spec = mirror.Spec
+}
+
+// adoptedAnnotations returns the annotations of mirror without those set by the
+// controller, keeping the logical cluster annotation of reference.
+func adoptedAnnotations(reference, mirror client.Object) map[string]string {
+	annotations := copyStringMap(mirror.GetAnnotations())
+	for _, key := range mirrorAnnotations {
+		delete(annotations, key)
+	}
+	if cluster, ok := reference.GetAnnotations()[logicalcluster.AnnotationKey]; ok {
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[logicalcluster.AnnotationKey] = cluster
+	}
+	return annotations
+}
+
+// setDriftedCondition summarises in the Drifted condition of widget the targets
+// that reported drift, keyed by the reason returned from handleDrift.
+func setDriftedCondition(widget *tutorialkubebuilderiov1alpha1.Widget, drift map[string][]string) {
+	condition := metav1.Condition{
+		Type:               tutorialkubebuilderiov1alpha1.ConditionTypeDrifted,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: widget.Generation,
+	}
+	switch {
+	case len(drift[driftReasonIgnored]) > 0:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = driftReasonIgnored
+		condition.Message = fmt.Sprintf("Mirrored Widget was modified in %s and is left alone",
+			strings.Join(drift[driftReasonIgnored], ", "))
+	case len(drift[driftReasonAdopted]) > 0:
+		condition.Reason = driftReasonAdopted
+		condition.Message = fmt.Sprintf("Changes made to the mirrored Widget in %s were copied to the reference Widget",
+			strings.Join(drift[driftReasonAdopted], ", "))
+	case len(drift[driftReasonReverted]) > 0:
+		condition.Reason = driftReasonReverted
+		condition.Message = fmt.Sprintf("Changes made to the mirrored Widget in %s were overwritten",
+			strings.Join(drift[driftReasonReverted], ", "))
+	case meta.IsStatusConditionTrue(widget.Status.Conditions, tutorialkubebuilderiov1alpha1.ConditionTypeDrifted):
+		condition.Reason = driftReasonInSync
+		condition.Message = "Mirrored Widgets match the reference Widget"
+	default:
+		return
+	}
+	meta.SetStatusCondition(&widget.Status.Conditions, condition)
+}
+
+// Reasons reported in the Conflicted condition.
+const (
+	conflictReasonReported = "Reported"
+	conflictReasonForced   = "Forced"
+	conflictReasonResolved = "Resolved"
+)
+
+// setConflictedCondition summarises in the Conflicted condition of widget the
+// fields of its mirrored Widgets in targets that are owned by other field
+// managers, as listed in conflicts, and what policy did about them.
+func setConflictedCondition(widget *tutorialkubebuilderiov1alpha1.Widget, policy tutorialkubebuilderiov1alpha1.ConflictPolicy, conflicts map[string][]string, targets []string) {
+	condition := metav1.Condition{
+		Type:               tutorialkubebuilderiov1alpha1.ConditionTypeConflicted,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: widget.Generation,
+	}
+	switch {
+	case len(targets) > 0 && policy == tutorialkubebuilderiov1alpha1.ConflictPolicyReport:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = conflictReasonReported
+		condition.Message = "Fields of the mirrored Widget are owned by other field managers in " + formatConflicts(conflicts, targets)
+	case len(targets) > 0:
+		condition.Reason = conflictReasonForced
+		condition.Message = "Fields of the mirrored Widget owned by other field managers were taken over in " + formatConflicts(conflicts, targets)
+	case meta.IsStatusConditionTrue(widget.Status.Conditions, tutorialkubebuilderiov1alpha1.ConditionTypeConflicted):
+		condition.Reason = conflictReasonResolved
+		condition.Message = "No fields of the mirrored Widgets are owned by other field managers"
+	default:
+		return
+	}
+	meta.SetStatusCondition(&widget.Status.Conditions, condition)
+}
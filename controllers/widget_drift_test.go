@@ -0,0 +1,200 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/kcp-dev/logicalcluster/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
+)
+
+var _ = Describe("Widget drift", func() {
+	var (
+		ctx       context.Context
+		reference client.Client
+		recorder  *record.FakeRecorder
+		r         *WidgetReconciler
+		target    MirrorTarget
+		widget    *tutorialkubebuilderiov1alpha1.Widget
+		mirror    *tutorialkubebuilderiov1alpha1.Widget
+	)
+
+	key := client.ObjectKey{Namespace: "default", Name: "widget"}
+	stored := func() *tutorialkubebuilderiov1alpha1.Widget {
+		var w tutorialkubebuilderiov1alpha1.Widget
+		Expect(reference.Get(ctx, key, &w)).To(Succeed())
+		return &w
+	}
+	drifted := func() *metav1.Condition {
+		return meta.FindStatusCondition(widget.Status.Conditions, tutorialkubebuilderiov1alpha1.ConditionTypeDrifted)
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(tutorialkubebuilderiov1alpha1.AddToScheme(scheme)).To(Succeed())
+		widget = &tutorialkubebuilderiov1alpha1.Widget{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        key.Name,
+				Namespace:   key.Namespace,
+				Labels:      map[string]string{"app": "widget"},
+				Annotations: map[string]string{logicalcluster.AnnotationKey: "root:east"},
+			},
+			Spec: tutorialkubebuilderiov1alpha1.WidgetSpec{Foo: "reference"},
+		}
+		reference = fake.NewClientBuilder().WithScheme(scheme).WithObjects(widget).Build()
+		Expect(reference.Get(ctx, key, widget)).To(Succeed())
+		recorder = record.NewFakeRecorder(10)
+		r = &WidgetReconciler{Client: reference, Scheme: scheme, Recorder: recorder}
+		target = MirrorTarget{Name: "east"}
+
+		var err error
+		mirror, err = desiredMirror(widget, target, key)
+		Expect(err).NotTo(HaveOccurred())
+		mirror.Spec.Foo = "mirror"
+		mirror.Labels["edited"] = "true"
+	})
+
+	It("detects mirrored Widgets modified since they were written", func() {
+		written, err := desiredMirror(widget, target, key)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mirrorDrifted(written)).To(BeFalse())
+		Expect(mirrorDrifted(mirror)).To(BeTrue())
+
+		delete(mirror.Annotations, tutorialkubebuilderiov1alpha1.ContentHashAnnotation)
+		Expect(mirrorDrifted(mirror)).To(BeFalse())
+	})
+
+	It("overwrites the mirrored Widget with the Revert policy", func() {
+		write, reason, err := r.handleDrift(ctx, widget, mirror, target)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(write).To(BeTrue())
+		Expect(reason).To(Equal(driftReasonReverted))
+		Expect(stored().Spec.Foo).To(Equal("reference"))
+		Expect(recorder.Events).To(Receive(ContainSubstring("MirrorDrifted")))
+	})
+
+	It("copies the mirrored Widget to the reference Widget with the Adopt policy", func() {
+		r.DriftPolicy = tutorialkubebuilderiov1alpha1.DriftPolicyAdopt
+		write, reason, err := r.handleDrift(ctx, widget, mirror, target)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(write).To(BeTrue())
+		Expect(reason).To(Equal(driftReasonAdopted))
+
+		adopted := stored()
+		Expect(adopted.Spec.Foo).To(Equal("mirror"))
+		Expect(adopted.Labels).To(HaveKeyWithValue("edited", "true"))
+		Expect(adopted.Annotations).To(HaveKeyWithValue(logicalcluster.AnnotationKey, "root:east"))
+		for _, annotation := range mirrorAnnotations {
+			Expect(adopted.Annotations).NotTo(HaveKey(annotation))
+		}
+	})
+
+	It("reverts instead of adopting changes made in a target with transforms", func() {
+		target.Transforms = []Transform{{
+			Labels:    &MetadataTransform{Add: map[string]string{"mirrored": "true"}},
+			JSONPatch: []JSONPatchOperation{{Op: "replace", Path: "/spec/foo", Value: []byte(`"transformed"`)}},
+		}}
+		var err error
+		mirror, err = desiredMirror(widget, target, key)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mirror.Spec.Foo).To(Equal("transformed"))
+		mirror.Labels["edited"] = "true"
+		Expect(mirrorDrifted(mirror)).To(BeTrue())
+
+		widget.Annotations[tutorialkubebuilderiov1alpha1.DriftPolicyAnnotation] = string(tutorialkubebuilderiov1alpha1.DriftPolicyAdopt)
+		write, reason, err := r.handleDrift(ctx, widget, mirror, target)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(write).To(BeTrue())
+		Expect(reason).To(Equal(driftReasonReverted))
+
+		unchanged := stored()
+		Expect(unchanged.Spec.Foo).To(Equal("reference"))
+		Expect(unchanged.Labels).NotTo(HaveKey("mirrored"))
+		Expect(unchanged.Labels).NotTo(HaveKey("edited"))
+		Expect(recorder.Events).To(Receive(ContainSubstring("applying drift policy Revert")))
+	})
+
+	It("does not adopt changes made in a dry-run target", func() {
+		r.DriftPolicy = tutorialkubebuilderiov1alpha1.DriftPolicyAdopt
+		target.DryRun = true
+		write, reason, err := r.handleDrift(ctx, widget, mirror, target)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(write).To(BeFalse())
+		Expect(reason).To(Equal(driftReasonIgnored))
+		Expect(stored().Spec.Foo).To(Equal("reference"))
+	})
+
+	It("leaves the mirrored Widget alone with the Ignore policy", func() {
+		r.DriftPolicy = tutorialkubebuilderiov1alpha1.DriftPolicyIgnore
+		write, reason, err := r.handleDrift(ctx, widget, mirror, target)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(write).To(BeFalse())
+		Expect(reason).To(Equal(driftReasonIgnored))
+		Expect(stored().Spec.Foo).To(Equal("reference"))
+	})
+
+	It("honours the drift policy annotation over the reconciler policy", func() {
+		r.DriftPolicy = tutorialkubebuilderiov1alpha1.DriftPolicyAdopt
+		widget.Annotations[tutorialkubebuilderiov1alpha1.DriftPolicyAnnotation] = string(tutorialkubebuilderiov1alpha1.DriftPolicyIgnore)
+		write, reason, err := r.handleDrift(ctx, widget, mirror, target)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(write).To(BeFalse())
+		Expect(reason).To(Equal(driftReasonIgnored))
+		Expect(stored().Spec.Foo).To(Equal("reference"))
+	})
+
+	It("sets the Drifted condition and clears it once in sync", func() {
+		setDriftedCondition(widget, nil)
+		Expect(drifted()).To(BeNil())
+
+		setDriftedCondition(widget, map[string][]string{driftReasonIgnored: {"east"}})
+		Expect(drifted()).To(And(
+			HaveField("Status", metav1.ConditionTrue),
+			HaveField("Reason", driftReasonIgnored),
+			HaveField("Message", ContainSubstring("east")),
+		))
+
+		// Drift already reported is not recorded again.
+		r.DriftPolicy = tutorialkubebuilderiov1alpha1.DriftPolicyIgnore
+		_, _, err := r.handleDrift(ctx, widget, mirror, target)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(recorder.Events).To(BeEmpty())
+
+		setDriftedCondition(widget, nil)
+		Expect(drifted()).To(And(
+			HaveField("Status", metav1.ConditionFalse),
+			HaveField("Reason", driftReasonInSync),
+		))
+
+		setDriftedCondition(widget, map[string][]string{driftReasonReverted: {"east"}})
+		Expect(drifted()).To(And(
+			HaveField("Status", metav1.ConditionFalse),
+			HaveField("Reason", driftReasonReverted),
+		))
+	})
+})
@@ -21,16 +21,15 @@ import (
 	"flag"
 	"fmt"
 	apisv1alpha1 "github.com/kcp-dev/kcp/pkg/apis/apis/v1alpha1"
-	"io/ioutil"
-	"k8s.io/client-go/tools/clientcmd"
-	"log"
 	"os"
-	"sigs.k8s.io/controller-runtime/pkg/cluster"
-	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"reflect"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
-	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
-	"sigs.k8s.io/controller-runtime/pkg/source"
+	"strings"
+	"time"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
@@ -43,11 +42,14 @@ import (
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
-	"sigs.k8s.io/controller-runtime/pkg/kcp"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
+	configv1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/config/v1alpha1"
 	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
 	"github.com/yourrepo/kb-kcp-tutorial/controllers"
 	//+kubebuilder:scaffold:imports
@@ -69,15 +71,109 @@ func main() {
 	var configFile string
 	var configFile2 string
 	var apiExportName string
+	var deletionPolicy string
+	var deletionTimeout time.Duration
+	var driftPolicy string
+	var conflictPolicy string
+	var mirrorTargets mirrorTargetFlags
+	var mirrorKinds mirrorKindFlags
+	var dryRun bool
+	var gcInterval time.Duration
+	var gcBatchSize int64
+	var gcReportOnly bool
+	var namespaceMapping string
+	var labelSelector string
+	var annotationSelector string
+	var createNamespaces bool
+	var namespaceLabels string
+	var namespaceAnnotations string
+	var probeAddr string
+	var maxConcurrentReconciles int
+	var workspaceRoutes mirrorRouteFlags
+	var workspaceLabel string
+	var workspaceDefaultTargets string
+	var mode runMode
+	_ = mode.Set(defaultMode)
+	flag.Var(&mode, "mode",
+		"How the controller runs, given as a comma separated list of modes: one of "+modeStandalone+" (reconcile Widgets "+
+			"in the cluster of the kubeconfig), "+modeKCP+" (reconcile Widgets in every workspace bound to the APIExport "+
+			"through its virtual workspace) or "+modeAuto+" ("+modeKCP+" if the apis.kcp.dev group is served, "+modeStandalone+
+			" otherwise), optionally combined with "+modeMirror+" (mirror the Widgets into the mirror targets), e.g. "+
+			modeKCP+","+modeMirror+". The --mirror-* flags and --config2 require "+modeMirror+".")
+	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.StringVar(&apiExportName, "api-export-name", "", "The name of the APIExport.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 0,
+		"How many objects each controller reconciles at a time. 0 uses controller.groupKindConcurrency of --config, or 1.")
 	flag.StringVar(&configFile, "config", "",
-		"The controller will load its initial configuration from this file. "+
+		"The controller will load its initial configuration from this file, holding either a ControllerConfig of "+
+			configv1alpha1.GroupVersion.String()+" or a ControllerManagerConfig. "+
 			"Omit this flag to use the default configuration values. "+
 			"Command-line flags override configuration from this file.")
 	flag.StringVar(&configFile2, "config2", "",
-		"The mirror controller will load its initial configuration from this file. "+
-			"Omit this flag to use the default configuration values. "+
-			"Command-line flags override configuration from this file.")
+		"Either the kubeconfig of a mirror target named "+defaultMirrorTargetName+", or a config file as given to "+
+			"--config for the mirror controllers. As these run in the manager configured by --config, the settings of both files "+
+			"are merged, and must not differ. Command-line flags override configuration from this file.")
+	flag.Var(&mirrorTargets, "mirror-target",
+		"A cluster to mirror Widgets into, given as name=<name>,kubeconfig=<path>[,namespace=<mapping>][,transforms=<path>][,dry-run=<bool>]"+
+			"[,create-namespaces=<bool>]. "+
+			"The transforms file holds a YAML list of transforms applied to Widgets mirrored into the target. "+
+			"The kubeconfig is reloaded whenever the file changes. The controller starts while a target is unreachable "+
+			"and reports not ready until every target has been reached. May be repeated. "+
+			"--config2, if set to a kubeconfig, adds a target named "+defaultMirrorTargetName+".")
+	flag.Var(&mirrorKinds, "mirror-kind",
+		"A kind to mirror in addition to Widgets, given as <apiVersion>/<kind>, e.g. v1/ConfigMap. May be repeated. "+
+			"The controller needs RBAC permissions, and on kcp a permission claim, for every kind. "+
+			"ConfigMaps and Secrets copied along with the Widgets referencing them are left to the Widget controller.")
+	flag.BoolVar(&dryRun, "mirror-dry-run", false,
+		"Only send server-side dry-run requests to mirror targets, and log, count and record as events the changes "+
+			"that would have been made. Targets can override this with their dry-run key.")
+	flag.DurationVar(&gcInterval, "mirror-gc-interval", controllers.DefaultGCInterval,
+		"How often mirror targets are swept for mirrored objects left behind by deleted or unselected reference objects. "+
+			"0 disables the sweep.")
+	flag.Int64Var(&gcBatchSize, "mirror-gc-batch-size", controllers.DefaultGCBatchSize,
+		"How many mirrored objects the sweep lists from a mirror target at a time.")
+	flag.BoolVar(&gcReportOnly, "mirror-gc-report-only", false,
+		"Only log and count the orphaned mirrored objects found by the sweep instead of releasing them.")
+	flag.StringVar(&namespaceMapping, "mirror-namespace-mapping", "identity",
+		"How reference namespaces map to mirror namespaces: identity, fixed:<namespace>, prefix:<prefix>, "+
+			"suffix:<suffix> or template:<template>, where the template can use {{.Namespace}}, {{.Cluster}} "+
+			"and the dns function. Targets can override this with their namespace key.")
+	flag.BoolVar(&createNamespaces, "mirror-create-namespaces", false,
+		"Create missing namespaces in mirror targets, and delete them once they no longer contain mirrored objects. "+
+			"Namespaces are only deleted by the sweep enabled with --mirror-gc-interval, and only if the controller created them. "+
+			"Targets can override this with their create-namespaces key.")
+	flag.StringVar(&namespaceLabels, "mirror-namespace-labels", "",
+		"Labels set on namespaces created in mirror targets, given as key=value pairs separated by commas.")
+	flag.StringVar(&namespaceAnnotations, "mirror-namespace-annotations", "",
+		"Annotations set on namespaces created in mirror targets, given as key=value pairs separated by commas.")
+	flag.Var(&workspaceRoutes, "mirror-workspace-route",
+		"Mirror the objects of a kcp workspace into the given targets only, given as <workspace>=<target>[+<target>...], "+
+			"e.g. root:org:tenant=east. May be repeated. Workspaces that are not routed are mirrored into "+
+			"--mirror-workspace-default-targets.")
+	flag.StringVar(&workspaceLabel, "mirror-workspace-label", "",
+		"Mirror the objects of a kcp workspace that is not routed by --mirror-workspace-route into the target named "+
+			"by this label on its ClusterWorkspace. The controller needs to read ClusterWorkspaces in the parent workspaces. "+
+			"Label changes are picked up within "+controllers.WorkspaceLabelRequeueAfter.String()+"; unknown target names are reported in the Widget status.")
+	flag.StringVar(&workspaceDefaultTargets, "mirror-workspace-default-targets", "",
+		"Comma separated names of the targets of kcp workspaces that are not routed otherwise. "+
+			"Only used with --mirror-workspace-route or --mirror-workspace-label; empty means such workspaces are not mirrored.")
+	flag.StringVar(&labelSelector, "mirror-label-selector", "",
+		"Only mirror Widgets whose labels match this selector. Widgets can opt in or out with the "+
+			tutorialkubebuilderiov1alpha1.MirrorAnnotation+" annotation.")
+	flag.StringVar(&annotationSelector, "mirror-annotation-selector", "",
+		"Only mirror Widgets whose annotations match this selector, given in label selector syntax.")
+	flag.StringVar(&deletionPolicy, "mirror-deletion-policy", string(tutorialkubebuilderiov1alpha1.DeletionPolicyDelete),
+		"What happens to a mirrored Widget when its reference Widget is deleted: Delete, Orphan or Retain. "+
+			"Widgets can override this with the "+tutorialkubebuilderiov1alpha1.DeletionPolicyAnnotation+" annotation.")
+	flag.DurationVar(&deletionTimeout, "mirror-deletion-timeout", controllers.DefaultDeletionTimeout,
+		"How long the deletion or deselection of a reference Widget waits for an unreachable mirror cluster before giving up.")
+	flag.StringVar(&driftPolicy, "mirror-drift-policy", string(tutorialkubebuilderiov1alpha1.DriftPolicyRevert),
+		"What happens when a mirrored Widget is modified in the mirror cluster: Revert, Adopt or Ignore. "+
+			"Adopt cannot be used with mirror targets that have transforms. "+
+			"Widgets can override this with the "+tutorialkubebuilderiov1alpha1.DriftPolicyAnnotation+" annotation.")
+	flag.StringVar(&conflictPolicy, "mirror-conflict-policy", string(tutorialkubebuilderiov1alpha1.ConflictPolicyForce),
+		"What happens when fields of a mirrored Widget are owned by other field managers in the mirror cluster: Force or Report. "+
+			"Widgets can override this with the "+tutorialkubebuilderiov1alpha1.ConflictPolicyAnnotation+" annotation.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -85,195 +181,117 @@ func main() {
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
-	setupLog = setupLog.WithValues("api-export-name", apiExportName)
-	mgr, err := manager.New(ctrl.GetConfigOrDie(), manager.Options{Scheme: scheme})
-	if err != nil {
-		panic(err)
+
+	if names := mirrorFlagsSet(flag.CommandLine); !mode.Mirror && len(names) > 0 {
+		setupLog.Error(fmt.Errorf("%s require the %s mode", strings.Join(names, ", "), modeMirror), "invalid --mode")
+		os.Exit(1)
 	}
 
-	var err2 error
-	options2 := ctrl.Options{Scheme: scheme}
+	// The settings of the config files are applied to the flags that were
+	// not set on the command line.
+	var configFiles []string
+	if configFile != "" {
+		configFiles = append(configFiles, configFile)
+	}
 	if configFile2 != "" {
-		options2, err2 = options2.AndFrom(ctrl.ConfigFile().AtPath(configFile2))
-		if err2 != nil {
-			setupLog.Error(err, "unable to load the config file 2")
+		isConfig, err := isManagerConfig(configFile2)
+		if err != nil {
+			setupLog.Error(err, "invalid --config2")
+			os.Exit(1)
+		}
+		if isConfig {
+			configFiles = append(configFiles, configFile2)
+		} else if err := mirrorTargets.add(mirrorTargetFlag{Name: defaultMirrorTargetName, Kubeconfig: configFile2}); err != nil {
+			setupLog.Error(err, "invalid --config2")
 			os.Exit(1)
 		}
 	}
-	options2.MetricsBindAddress = "0"
-
-	var clientCfg clientcmd.ClientConfig
-
-	var kubeConfig, _ = ioutil.ReadFile(configFile2)
-	clientCfg, err = clientcmd.NewClientConfigFromBytes(kubeConfig)
+	controllerConfig, err := loadManagerConfigs(configFiles)
 	if err != nil {
-		log.Fatal(err)
+		setupLog.Error(err, "unable to load the config files")
+		os.Exit(1)
 	}
+	if controllerConfig != nil {
+		if !mode.Mirror && !reflect.DeepEqual(controllerConfig.Mirror, configv1alpha1.MirrorConfig{}) {
+			setupLog.Error(fmt.Errorf("the mirror section requires the %s mode", modeMirror), "invalid config files")
+			os.Exit(1)
+		}
+		controllerConfig.Default()
+		if err := applyConfigFlags(flag.CommandLine, controllerConfig); err != nil {
+			setupLog.Error(err, "invalid config files")
+			os.Exit(1)
+		}
+	}
+	setupLog = setupLog.WithValues("api-export-name", apiExportName, "mode", mode.String())
 
-	var restCfg *rest.Config
-
-	setupLog.Info("here1")
-	restCfg, err = clientCfg.ClientConfig()
+	if !tutorialkubebuilderiov1alpha1.DeletionPolicy(deletionPolicy).IsValid() {
+		setupLog.Error(fmt.Errorf("unknown deletion policy %q", deletionPolicy), "invalid --mirror-deletion-policy")
+		os.Exit(1)
+	}
+	if !tutorialkubebuilderiov1alpha1.DriftPolicy(driftPolicy).IsValid() {
+		setupLog.Error(fmt.Errorf("unknown drift policy %q", driftPolicy), "invalid --mirror-drift-policy")
+		os.Exit(1)
+	}
+	if !tutorialkubebuilderiov1alpha1.ConflictPolicy(conflictPolicy).IsValid() {
+		setupLog.Error(fmt.Errorf("unknown conflict policy %q", conflictPolicy), "invalid --mirror-conflict-policy")
+		os.Exit(1)
+	}
+	defaultNamespaceMapping, err := controllers.ParseNamespaceMapping(namespaceMapping)
 	if err != nil {
-		log.Fatal(err)
+		setupLog.Error(err, "invalid --mirror-namespace-mapping")
+		os.Exit(1)
 	}
 
-	setupLog.Info("here2")
-	mirrorCluster, err2 := cluster.New(restCfg)
-
-	if err2 != nil {
-		panic(err2)
+	var mirrorNamespaces controllers.MirrorNamespaces
+	if mirrorNamespaces.Labels, err = parseKeyValues(namespaceLabels, true); err != nil {
+		setupLog.Error(err, "invalid --mirror-namespace-labels")
+		os.Exit(1)
 	}
-
-	setupLog.Info("here3")
-	if err := mgr.Add(mirrorCluster); err != nil {
-		panic(err)
+	if mirrorNamespaces.Annotations, err = parseKeyValues(namespaceAnnotations, false); err != nil {
+		setupLog.Error(err, "invalid --mirror-namespace-annotations")
+		os.Exit(1)
 	}
 
-	setupLog.Info("here4")
-	if err := NewMirrorWidgetReconciler(mgr, mirrorCluster); err != nil {
-		panic(err)
+	var filter controllers.MirrorFilter
+	if filter.LabelSelector, err = labels.Parse(labelSelector); err != nil {
+		setupLog.Error(err, "invalid --mirror-label-selector")
+		os.Exit(1)
 	}
-
-	//setupLog.Info("here4")
-	//if err = (&controllers.WidgetReconciler{
-	//	Client: mgr.GetClient(),
-	//	Scheme: mgr.GetScheme(),
-	//}).SetupWithManager(mgr); err != nil {
-	//	setupLog.Error(err, "unable to create controller", "controller", "Widget")
-	//	os.Exit(1)
-	//}
-
-	setupLog.Info("here5")
-	if err := mgr.Start(signals.SetupSignalHandler()); err != nil {
-		panic(err)
+	if filter.AnnotationSelector, err = labels.Parse(annotationSelector); err != nil {
+		setupLog.Error(err, "invalid --mirror-annotation-selector")
+		os.Exit(1)
 	}
-}
-
-func NewMirrorWidgetReconciler(mgr manager.Manager, mirrorCluster cluster.Cluster) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		// Watch Secrets in the reference cluster
-		For(&tutorialkubebuilderiov1alpha1.Widget{}).
-		// Watch Secrets in the mirror cluster
-		Watches(
-			source.NewKindWithCache(&tutorialkubebuilderiov1alpha1.Widget{}, mirrorCluster.GetCache()),
-			&handler.EnqueueRequestForObject{},
-		).
-		Complete(&controllers.WidgetReconciler{
-			Client: mirrorCluster.GetClient(),
-			Scheme: mgr.GetScheme(),
-		})
-}
 
-func main2() {
-	var configFile string
-	var configFile2 string
-	var apiExportName string
-	flag.StringVar(&apiExportName, "api-export-name", "", "The name of the APIExport.")
-	flag.StringVar(&configFile, "config", "",
-		"The controller will load its initial configuration from this file. "+
-			"Omit this flag to use the default configuration values. "+
-			"Command-line flags override configuration from this file.")
-	flag.StringVar(&configFile2, "config2", "",
-		"The mirror controller will load its initial configuration from this file. "+
-			"Omit this flag to use the default configuration values. "+
-			"Command-line flags override configuration from this file.")
-	opts := zap.Options{
-		Development: true,
+	var defaultTargets []string
+	if workspaceDefaultTargets != "" {
+		defaultTargets = strings.Split(workspaceDefaultTargets, ",")
 	}
-	opts.BindFlags(flag.CommandLine)
-	flag.Parse()
-
-	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
-	setupLog = setupLog.WithValues("api-export-name", apiExportName)
 
 	ctx := ctrl.SetupSignalHandler()
-
 	restConfig := ctrl.GetConfigOrDie()
 
-	var mgr ctrl.Manager
-	var mgr2 ctrl.Manager
-	var err error
-	var err2 error
-
-	if kcpAPIsGroupPresent(restConfig) {
-		setupLog.Info("Looking up virtual workspace URL")
-		cfg, err := restConfigForAPIExport(ctx, restConfig, apiExportName)
-		if err != nil {
-			setupLog.Error(err, "error looking up virtual workspace URL")
-		}
-
-		setupLog.Info("Using virtual workspace URL", "url", cfg.Host)
-
-		options := ctrl.Options{Scheme: scheme}
-		options.LeaderElectionConfig = restConfig
-
-		if configFile != "" {
-			options, err = options.AndFrom(ctrl.ConfigFile().AtPath(configFile))
-			if err != nil {
-				setupLog.Error(err, "unable to load the config file")
-				os.Exit(1)
-			}
-		}
-
-		mgr, err = kcp.NewClusterAwareManager(cfg, options)
-		if err != nil {
-			setupLog.Error(err, "unable to start cluster aware manager")
-			os.Exit(1)
-		}
-	} else {
-		setupLog.Info("The apis.kcp.dev group is not present - creating standard manager")
-
-		options := ctrl.Options{Scheme: scheme}
-		options2 := ctrl.Options{Scheme: scheme}
-
-		if configFile != "" {
-			options, err = options.AndFrom(ctrl.ConfigFile().AtPath(configFile))
-			if err != nil {
-				setupLog.Error(err, "unable to load the config file")
-				os.Exit(1)
-			}
-		}
-
-		mgr, err = ctrl.NewManager(ctrl.GetConfigOrDie(), options)
-		if err != nil {
-			setupLog.Error(err, "unable to start manager")
-			os.Exit(1)
-		}
-
-		if configFile2 != "" {
-			options2, err2 = options.AndFrom(ctrl.ConfigFile().AtPath(configFile2))
-			if err2 != nil {
-				setupLog.Error(err, "unable to load the config file 2")
-				os.Exit(1)
-			}
+	// Command-line flags override the config files, which override the
+	// defaults of the flags.
+	options := ctrl.Options{Scheme: scheme}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "health-probe-bind-address" {
+			options.HealthProbeBindAddress = probeAddr
 		}
-		options2.MetricsBindAddress = "0"
-
-		mgr2, err2 = ctrl.NewManager(ctrl.GetConfigOrDie(), options2)
-		if err2 != nil {
-			setupLog.Error(err2, "unable to start manager 2")
+	})
+	if controllerConfig != nil {
+		if options, err = applyManagerConfig(options, controllerConfig); err != nil {
+			setupLog.Error(err, "unable to apply the config files")
 			os.Exit(1)
 		}
-
 	}
-
-	if err = (&controllers.WidgetReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "Widget")
-		os.Exit(1)
+	if options.HealthProbeBindAddress == "" {
+		options.HealthProbeBindAddress = probeAddr
 	}
-
-	if err2 = (&controllers.WidgetReconciler{
-		Client: mgr2.GetClient(),
-		Scheme: mgr2.GetScheme(),
-	}).SetupWithManager(mgr2); err2 != nil {
-		setupLog.Error(err2, "unable to create controller", "controller", "Widget")
+	mgr, err := newManager(ctx, restConfig, mode, apiExportName, options)
+	if err != nil {
+		setupLog.Error(err, "unable to create manager")
 		os.Exit(1)
 	}
-	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
@@ -284,23 +302,128 @@ func main2() {
 		os.Exit(1)
 	}
 
+	// The manager is added last, so that its controllers stop before the
+	// mirror clusters they write to.
+	supervisor := &controllers.Supervisor{}
+	if !mode.Mirror {
+		if err := (&controllers.WidgetReconciler{
+			Client:                  mgr.GetClient(),
+			Scheme:                  mgr.GetScheme(),
+			MaxConcurrentReconciles: maxConcurrentReconciles,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "Widget")
+			os.Exit(1)
+		}
+	} else if err := setupMirroring(mgr, supervisor, restConfig, mirrorOptions{
+		Targets:                 mirrorTargets,
+		Kinds:                   mirrorKinds,
+		DryRun:                  dryRun,
+		NamespaceMapping:        defaultNamespaceMapping,
+		CreateNamespaces:        createNamespaces,
+		Namespaces:              mirrorNamespaces,
+		Filter:                  filter,
+		DeletionPolicy:          tutorialkubebuilderiov1alpha1.DeletionPolicy(deletionPolicy),
+		DeletionTimeout:         deletionTimeout,
+		DriftPolicy:             tutorialkubebuilderiov1alpha1.DriftPolicy(driftPolicy),
+		ConflictPolicy:          tutorialkubebuilderiov1alpha1.ConflictPolicy(conflictPolicy),
+		WorkspaceRoutes:         workspaceRoutes,
+		WorkspaceLabel:          workspaceLabel,
+		WorkspaceDefaultTargets: defaultTargets,
+		GCInterval:              gcInterval,
+		GCBatchSize:             gcBatchSize,
+		GCReportOnly:            gcReportOnly,
+		MaxConcurrentReconciles: maxConcurrentReconciles,
+	}); err != nil {
+		setupLog.Error(err, "unable to set up mirroring")
+		os.Exit(1)
+	}
+	//+kubebuilder:scaffold:builder
+
+	if err := supervisor.Add("manager", mgr); err != nil {
+		setupLog.Error(err, "unable to add manager")
+		os.Exit(1)
+	}
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctx); err != nil {
+	if err := supervisor.Start(ctx); err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
-	setupLog.Info("starting manager 2")
-	if err2 := mgr2.Start(ctx); err2 != nil {
-		setupLog.Error(err2, "problem running manager 2")
-		os.Exit(1)
+}
+
+// NewMirrorWidgetReconciler completes r with the reference cluster client of mgr
+// and one target per mirror cluster, and registers it with mgr. Widgets are
+// watched in the reference cluster, filtered by r.Filter, and in every mirror
+// cluster, where only Widgets written by the controller are watched. The
+// ConfigMaps and Secrets referenced by Widgets are watched in the reference
+// cluster.
+func NewMirrorWidgetReconciler(mgr manager.Manager, mirrorClusters []mirrorCluster, r *controllers.WidgetReconciler) error {
+	r.Client = mgr.GetClient()
+	r.Scheme = mgr.GetScheme()
+	r.Recorder = mgr.GetEventRecorderFor("widget-mirror")
+
+	// Watch Widgets in the reference cluster
+	c, err := ctrl.NewControllerManagedBy(mgr).
+		For(&tutorialkubebuilderiov1alpha1.Widget{}, builder.WithPredicates(r.Filter.ReferencePredicate())).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		// Catch up as soon as a namespace is resumed
+		Watches(&source.Kind{Type: &corev1.Namespace{}}, controllers.NamespacePauseHandler(mgr.GetClient(), func() client.ObjectList {
+			return &tutorialkubebuilderiov1alpha1.WidgetList{}
+		}), builder.WithPredicates(controllers.NamespacePausePredicate())).
+		// Mirror changes to the ConfigMaps and Secrets referenced by Widgets
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, controllers.DependentEventHandler(mgr.GetClient())).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, controllers.DependentEventHandler(mgr.GetClient())).
+		Build(r)
+	if err != nil {
+		return err
+	}
+	for _, mc := range mirrorClusters {
+		r.Targets = append(r.Targets, mc.target(r.Recorder))
+		// Watch Widgets in the mirror cluster
+		if err := mc.cluster.Watch(c, &tutorialkubebuilderiov1alpha1.Widget{},
+			controllers.MirrorEventHandler(), controllers.MirrorPredicate()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewMirrorReconciler sets up r to mirror objects of kind r.GVK from the
+// reference cluster into mirrorClusters.
+func NewMirrorReconciler(mgr manager.Manager, mirrorClusters []mirrorCluster, r *controllers.MirrorReconciler) error {
+	r.Client = mgr.GetClient()
+	r.Recorder = mgr.GetEventRecorderFor(controllerName(r.GVK))
+
+	reference := &unstructured.Unstructured{}
+	reference.SetGroupVersionKind(r.GVK)
+	c, err := ctrl.NewControllerManagedBy(mgr).
+		Named(controllerName(r.GVK)).
+		For(reference, builder.WithPredicates(r.Filter.ReferencePredicate())).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		Watches(&source.Kind{Type: &corev1.Namespace{}}, controllers.NamespacePauseHandler(mgr.GetClient(), func() client.ObjectList {
+			list := &unstructured.UnstructuredList{}
+			list.SetGroupVersionKind(r.GVK.GroupVersion().WithKind(r.GVK.Kind + "List"))
+			return list
+		}), builder.WithPredicates(controllers.NamespacePausePredicate())).
+		Build(r)
+	if err != nil {
+		return err
+	}
+	for _, mc := range mirrorClusters {
+		r.Targets = append(r.Targets, mc.target(r.Recorder))
+		mirror := &unstructured.Unstructured{}
+		mirror.SetGroupVersionKind(r.GVK)
+		if err := mc.cluster.Watch(c, mirror, controllers.MirrorEventHandler(), controllers.MirrorPredicate()); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 // +kubebuilder:rbac:groups="apis.kcp.dev",resources=apiexports,verbs=get;list;watch
 
-// restConfigForAPIExport returns a *rest.Config properly configured to communicate with the endpoint for the
-// APIExport's virtual workspace.
-func restConfigForAPIExport(ctx context.Context, cfg *rest.Config, apiExportName string) (*rest.Config, error) {
+// getAPIExport returns the APIExport named apiExportName in the workspace reached
+// with cfg, or the only APIExport of the workspace if apiExportName is empty.
+func getAPIExport(ctx context.Context, cfg *rest.Config, apiExportName string) (*apisv1alpha1.APIExport, error) {
 	scheme := runtime.NewScheme()
 	if err := apisv1alpha1.AddToScheme(scheme); err != nil {
 		return nil, fmt.Errorf("error adding apis.kcp.dev/v1alpha1 to scheme: %w", err)
@@ -332,37 +455,50 @@ func restConfigForAPIExport(ctx context.Context, cfg *rest.Config, apiExportName
 		apiExport = exports.Items[0]
 	}
 
+	return &apiExport, nil
+}
+
+// virtualWorkspaceURLs returns the URLs of the virtual workspaces of apiExport,
+// one per shard.
+func virtualWorkspaceURLs(apiExport *apisv1alpha1.APIExport) ([]string, error) {
 	if len(apiExport.Status.VirtualWorkspaces) < 1 {
-		return nil, fmt.Errorf("APIExport %q status.virtualWorkspaces is empty", apiExportName)
+		return nil, fmt.Errorf("APIExport %q status.virtualWorkspaces is empty", apiExport.Name)
 	}
+	urls := make([]string, 0, len(apiExport.Status.VirtualWorkspaces))
+	for _, vw := range apiExport.Status.VirtualWorkspaces {
+		urls = append(urls, vw.URL)
+	}
+	return urls, nil
+}
 
+// restConfigForVirtualWorkspace returns a *rest.Config properly configured to
+// communicate with the virtual workspace at url.
+func restConfigForVirtualWorkspace(cfg *rest.Config, url string) *rest.Config {
 	cfg = rest.CopyConfig(cfg)
-	// TODO(ncdc): sharding support
-	cfg.Host = apiExport.Status.VirtualWorkspaces[0].URL
-
-	return cfg, nil
+	cfg.Host = url
+	return cfg
 }
 
-func kcpAPIsGroupPresent(restConfig *rest.Config) bool {
+// kcpAPIsGroupPresent reports whether the cluster reached with restConfig
+// serves the apis.kcp.dev group.
+func kcpAPIsGroupPresent(restConfig *rest.Config) (bool, error) {
 	discoveryClient, err := discovery.NewDiscoveryClientForConfig(restConfig)
 	if err != nil {
-		setupLog.Error(err, "failed to create discovery client")
-		os.Exit(1)
+		return false, fmt.Errorf("failed to create discovery client: %w", err)
 	}
 	apiGroupList, err := discoveryClient.ServerGroups()
 	if err != nil {
-		setupLog.Error(err, "failed to get server groups")
-		os.Exit(1)
+		return false, fmt.Errorf("failed to get server groups: %w", err)
 	}
 
 	for _, group := range apiGroupList.Groups {
 		if group.Name == apisv1alpha1.SchemeGroupVersion.Group {
 			for _, version := range group.Versions {
 				if version.Version == apisv1alpha1.SchemeGroupVersion.Version {
-					return true
+					return true, nil
 				}
 			}
 		}
 	}
-	return false
+	return false, nil
 }
@@ -0,0 +1,461 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlconfigv1alpha1 "sigs.k8s.io/controller-runtime/pkg/config/v1alpha1"
+	"sigs.k8s.io/yaml"
+
+	configv1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/config/v1alpha1"
+)
+
+// Kinds of the files given to --config.
+const (
+	// managerConfigKind is the kind of controller-runtime, holding the
+	// settings of the manager only.
+	managerConfigKind = "ControllerManagerConfig"
+	// controllerConfigKind also holds the settings of the kcp and mirror
+	// modes.
+	controllerConfigKind = "ControllerConfig"
+)
+
+// managerConfigFile is the content of a ControllerManagerConfig file.
+type managerConfigFile struct {
+	ctrlconfigv1alpha1.ControllerManagerConfiguration `json:",inline"`
+	// Metadata is accepted, as kustomize labels the file, but ignored.
+	Metadata metav1.ObjectMeta `json:"metadata,omitempty"`
+}
+
+// controllerConfigFile is the content of a ControllerConfig file.
+type controllerConfigFile struct {
+	configv1alpha1.ControllerConfig `json:",inline"`
+	// Metadata is accepted, as kustomize labels the file, but ignored.
+	Metadata metav1.ObjectMeta `json:"metadata,omitempty"`
+}
+
+// isManagerConfig reports whether the file at path holds a ControllerConfig
+// or a ControllerManagerConfig rather than, e.g., a kubeconfig.
+func isManagerConfig(path string) (bool, error) {
+	_, typeMeta, err := readConfigFile(path)
+	if err != nil {
+		return false, err
+	}
+	return isManagerConfigType(typeMeta) || isControllerConfigType(typeMeta), nil
+}
+
+func isManagerConfigType(typeMeta metav1.TypeMeta) bool {
+	return typeMeta.APIVersion == ctrlconfigv1alpha1.GroupVersion.String() && typeMeta.Kind == managerConfigKind
+}
+
+func isControllerConfigType(typeMeta metav1.TypeMeta) bool {
+	return typeMeta.APIVersion == configv1alpha1.GroupVersion.String() && typeMeta.Kind == controllerConfigKind
+}
+
+// readConfigFile reads the file at path along with the type it holds.
+func readConfigFile(path string) ([]byte, metav1.TypeMeta, error) {
+	var typeMeta metav1.TypeMeta
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, typeMeta, fmt.Errorf("error reading %q: %w", path, err)
+	}
+	if err := yaml.Unmarshal(data, &typeMeta); err != nil {
+		return nil, typeMeta, fmt.Errorf("error decoding %q: %w", path, err)
+	}
+	return data, typeMeta, nil
+}
+
+// loadManagerConfig loads and validates the ControllerConfig file at path. A
+// ControllerManagerConfig file is converted into a ControllerConfig. Unknown
+// fields are rejected, so that misspelt settings are not silently ignored.
+func loadManagerConfig(path string) (*configv1alpha1.ControllerConfig, error) {
+	data, typeMeta, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &configv1alpha1.ControllerConfig{}
+	switch {
+	case isControllerConfigType(typeMeta):
+		var file controllerConfigFile
+		if err := yaml.UnmarshalStrict(data, &file); err != nil {
+			return nil, fmt.Errorf("error decoding %q: %w", path, err)
+		}
+		c = &file.ControllerConfig
+	case isManagerConfigType(typeMeta):
+		var file managerConfigFile
+		if err := yaml.UnmarshalStrict(data, &file); err != nil {
+			return nil, fmt.Errorf("error decoding %q: %w", path, err)
+		}
+		c.ConvertFrom(&file.ControllerManagerConfiguration)
+	default:
+		return nil, fmt.Errorf("%q holds %s %s, expected %s %s or %s %s", path, typeMeta.APIVersion, typeMeta.Kind,
+			configv1alpha1.GroupVersion, controllerConfigKind, ctrlconfigv1alpha1.GroupVersion, managerConfigKind)
+	}
+	if err := validateManagerConfig(c.ControllerManagerConfigurationSpec); err != nil {
+		return nil, fmt.Errorf("invalid %q: %w", path, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid %q: %w", path, err)
+	}
+	return c, nil
+}
+
+// loadManagerConfigs loads the config files at paths and merges them into one
+// ControllerConfig, which is nil without paths. Settings the files set to
+// different values are rejected.
+func loadManagerConfigs(paths []string) (*configv1alpha1.ControllerConfig, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	merged := map[string]interface{}{}
+	var first *configv1alpha1.ControllerConfig
+	for i, path := range paths {
+		c, err := loadManagerConfig(path)
+		if err != nil {
+			return nil, err
+		}
+		if first == nil {
+			first = c
+		} else {
+			conflicts, err := managerConfigConflicts(first, c)
+			if err != nil {
+				return nil, err
+			}
+			if len(conflicts) > 0 {
+				return nil, fmt.Errorf("%q and %q set different values: %s", paths[0], paths[i], strings.Join(conflicts, ", "))
+			}
+		}
+		tree, err := configTree(c)
+		if err != nil {
+			return nil, err
+		}
+		mergeConfigTrees(merged, tree)
+	}
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	c := &configv1alpha1.ControllerConfig{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	c.APIVersion = configv1alpha1.GroupVersion.String()
+	c.Kind = controllerConfigKind
+	return c, nil
+}
+
+// validateManagerConfig checks the settings of spec the manager would only
+// reject once started, or not at all.
+func validateManagerConfig(spec ctrlconfigv1alpha1.ControllerManagerConfigurationSpec) error {
+	var errs []string
+	if err := validateBindAddress(spec.Metrics.BindAddress); err != nil {
+		errs = append(errs, fmt.Sprintf("metrics.bindAddress: %v", err))
+	}
+	if err := validateBindAddress(spec.Health.HealthProbeBindAddress); err != nil {
+		errs = append(errs, fmt.Sprintf("health.healthProbeBindAddress: %v", err))
+	}
+	if port := spec.Webhook.Port; port != nil && (*port < 1 || *port > 65535) {
+		errs = append(errs, fmt.Sprintf("webhook.port: %d is not a valid port", *port))
+	}
+	if spec.SyncPeriod != nil && spec.SyncPeriod.Duration <= 0 {
+		errs = append(errs, "syncPeriod: must be positive")
+	}
+	if le := spec.LeaderElection; le != nil {
+		if le.LeaderElect != nil && *le.LeaderElect && le.ResourceName == "" {
+			errs = append(errs, "leaderElection.resourceName: required when leaderElect is true")
+		}
+		if le.LeaseDuration.Duration < 0 || le.RenewDeadline.Duration < 0 || le.RetryPeriod.Duration < 0 {
+			errs = append(errs, "leaderElection: durations must not be negative")
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// validateBindAddress checks a bind address, which is either empty, 0 to
+// disable the endpoint, or host:port.
+func validateBindAddress(address string) error {
+	if address == "" || address == "0" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return err
+	}
+	return nil
+}
+
+// managerConfigConflicts returns the settings set to different values in a
+// and b, as dotted field paths.
+func managerConfigConflicts(a, b *configv1alpha1.ControllerConfig) ([]string, error) {
+	treeA, err := configTree(a)
+	if err != nil {
+		return nil, err
+	}
+	treeB, err := configTree(b)
+	if err != nil {
+		return nil, err
+	}
+	fieldsA, fieldsB := configFields(treeA), configFields(treeB)
+	var conflicts []string
+	for path, valueA := range fieldsA {
+		if valueB, ok := fieldsB[path]; ok && !reflect.DeepEqual(valueA, valueB) {
+			conflicts = append(conflicts, fmt.Sprintf("%s (%v and %v)", path, valueA, valueB))
+		}
+	}
+	sort.Strings(conflicts)
+	return conflicts, nil
+}
+
+// configTree returns the settings of c as a JSON object. Some leader election
+// settings are not pointers, so empty strings and zero durations are taken as
+// unset and left out.
+func configTree(c *configv1alpha1.ControllerConfig) (map[string]interface{}, error) {
+	c = c.DeepCopy()
+	c.TypeMeta = metav1.TypeMeta{}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var tree map[string]interface{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, err
+	}
+	var prune func(m map[string]interface{})
+	prune = func(m map[string]interface{}) {
+		for k, v := range m {
+			switch v := v.(type) {
+			case map[string]interface{}:
+				prune(v)
+			case string:
+				if v == "" || v == "0s" {
+					delete(m, k)
+				}
+			}
+		}
+	}
+	prune(tree)
+	return tree, nil
+}
+
+// configFields flattens tree into its leaf values by dotted field path. Lists
+// are leaves.
+func configFields(tree map[string]interface{}) map[string]interface{} {
+	fields := map[string]interface{}{}
+	var walk func(prefix string, value interface{})
+	walk = func(prefix string, value interface{}) {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			fields[prefix] = value
+			return
+		}
+		for k, v := range m {
+			if prefix != "" {
+				k = prefix + "." + k
+			}
+			walk(k, v)
+		}
+	}
+	walk("", tree)
+	return fields
+}
+
+// mergeConfigTrees sets the settings of src on dst.
+func mergeConfigTrees(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if m, ok := v.(map[string]interface{}); ok {
+			if d, ok := dst[k].(map[string]interface{}); ok {
+				mergeConfigTrees(d, m)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
+
+// applyManagerConfig completes options with the manager settings of c that
+// options does not set yet.
+func applyManagerConfig(options ctrl.Options, c *configv1alpha1.ControllerConfig) (ctrl.Options, error) {
+	var managerConfig ctrlconfigv1alpha1.ControllerManagerConfiguration
+	c.ConvertTo(&managerConfig)
+	options, err := options.AndFrom(&managerConfig)
+	if err != nil {
+		return options, err
+	}
+	// AndFrom leaves out the graceful shutdown timeout.
+	if timeout := managerConfig.GracefulShutdownTimeout; options.GracefulShutdownTimeout == nil && timeout != nil {
+		options.GracefulShutdownTimeout = &timeout.Duration
+	}
+	return options, nil
+}
+
+// configFlag is a flag set from a setting of the config files.
+type configFlag struct {
+	// field is the path of the setting.
+	field string
+	name  string
+	value string
+}
+
+// applyConfigFlags sets the flags of fs from the settings of c, so that they
+// are parsed and checked the same way. Flags set on the command line override
+// the settings of c.
+func applyConfigFlags(fs *flag.FlagSet, c *configv1alpha1.ControllerConfig) error {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	flags, err := configFlags(c)
+	if err != nil {
+		return err
+	}
+	for _, f := range flags {
+		if set[f.name] {
+			continue
+		}
+		if err := fs.Set(f.name, f.value); err != nil {
+			return fmt.Errorf("invalid %s: %w", f.field, err)
+		}
+	}
+	return nil
+}
+
+// configFlags returns the flags matching the settings of c that are set.
+// Repeated flags are returned once per value.
+func configFlags(c *configv1alpha1.ControllerConfig) ([]configFlag, error) {
+	var flags []configFlag
+	add := func(field, name, value string) {
+		flags = append(flags, configFlag{field: field, name: name, value: value})
+	}
+	addBool := func(field, name string, value *bool) {
+		if value != nil {
+			add(field, name, strconv.FormatBool(*value))
+		}
+	}
+	addString := func(field, name, value string) {
+		if value != "" {
+			add(field, name, value)
+		}
+	}
+	addDuration := func(field, name string, value *metav1.Duration) {
+		if value != nil {
+			add(field, name, value.Duration.String())
+		}
+	}
+
+	if c.MaxConcurrentReconciles != nil {
+		add("maxConcurrentReconciles", "max-concurrent-reconciles", strconv.Itoa(*c.MaxConcurrentReconciles))
+	}
+	addString("kcp.apiExportName", "api-export-name", c.KCP.APIExportName)
+
+	m := c.Mirror
+	for i, t := range m.Targets {
+		field := fmt.Sprintf("mirror.targets[%d]", i)
+		pairs := [][2]string{{"name", t.Name}, {"kubeconfig", t.Kubeconfig}, {"namespace", t.NamespaceMapping}, {"transforms", t.Transforms}}
+		if t.DryRun != nil {
+			pairs = append(pairs, [2]string{"dry-run", strconv.FormatBool(*t.DryRun)})
+		}
+		if t.CreateNamespaces != nil {
+			pairs = append(pairs, [2]string{"create-namespaces", strconv.FormatBool(*t.CreateNamespaces)})
+		}
+		value, err := formatKeyValues(field, pairs)
+		if err != nil {
+			return nil, err
+		}
+		add(field, "mirror-target", value)
+	}
+	for i, kind := range m.Kinds {
+		add(fmt.Sprintf("mirror.kinds[%d]", i), "mirror-kind", kind)
+	}
+	addBool("mirror.dryRun", "mirror-dry-run", m.DryRun)
+	addString("mirror.namespaceMapping", "mirror-namespace-mapping", m.NamespaceMapping)
+	addBool("mirror.createNamespaces", "mirror-create-namespaces", m.CreateNamespaces)
+	for field, values := range map[string]map[string]string{
+		"mirror.namespaceLabels":      m.NamespaceLabels,
+		"mirror.namespaceAnnotations": m.NamespaceAnnotations,
+	} {
+		if values == nil {
+			continue
+		}
+		keys := make([]string, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		pairs := make([][2]string, 0, len(keys))
+		for _, k := range keys {
+			pairs = append(pairs, [2]string{k, values[k]})
+		}
+		value, err := formatKeyValues(field, pairs)
+		if err != nil {
+			return nil, err
+		}
+		name := "mirror-namespace-labels"
+		if field == "mirror.namespaceAnnotations" {
+			name = "mirror-namespace-annotations"
+		}
+		add(field, name, value)
+	}
+	addString("mirror.labelSelector", "mirror-label-selector", m.LabelSelector)
+	addString("mirror.annotationSelector", "mirror-annotation-selector", m.AnnotationSelector)
+	addString("mirror.deletionPolicy", "mirror-deletion-policy", string(m.DeletionPolicy))
+	addDuration("mirror.deletionTimeout", "mirror-deletion-timeout", m.DeletionTimeout)
+	addString("mirror.driftPolicy", "mirror-drift-policy", string(m.DriftPolicy))
+	addString("mirror.conflictPolicy", "mirror-conflict-policy", string(m.ConflictPolicy))
+	for i, r := range m.Workspaces.Routes {
+		add(fmt.Sprintf("mirror.workspaces.routes[%d]", i), "mirror-workspace-route", r.Workspace+"="+strings.Join(r.Targets, "+"))
+	}
+	addString("mirror.workspaces.label", "mirror-workspace-label", m.Workspaces.Label)
+	if len(m.Workspaces.DefaultTargets) > 0 {
+		add("mirror.workspaces.defaultTargets", "mirror-workspace-default-targets", strings.Join(m.Workspaces.DefaultTargets, ","))
+	}
+	addDuration("mirror.gc.interval", "mirror-gc-interval", m.GC.Interval)
+	if m.GC.BatchSize != nil {
+		add("mirror.gc.batchSize", "mirror-gc-batch-size", strconv.FormatInt(*m.GC.BatchSize, 10))
+	}
+	addBool("mirror.gc.reportOnly", "mirror-gc-report-only", m.GC.ReportOnly)
+	return flags, nil
+}
+
+// formatKeyValues formats pairs as a comma separated list of key=value pairs,
+// leaving out empty values. Values cannot hold commas, which the flags taking
+// such lists do not support either.
+func formatKeyValues(field string, pairs [][2]string) (string, error) {
+	parts := make([]string, 0, len(pairs))
+	for _, p := range pairs {
+		if p[1] == "" {
+			continue
+		}
+		if strings.Contains(p[1], ",") {
+			return "", fmt.Errorf("invalid %s: %s %q cannot contain commas", field, p[0], p[1])
+		}
+		parts = append(parts, p[0]+"="+p[1])
+	}
+	return strings.Join(parts, ","), nil
+}
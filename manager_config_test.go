@@ -0,0 +1,284 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlconfigv1alpha1 "sigs.k8s.io/controller-runtime/pkg/config/v1alpha1"
+
+	configv1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/config/v1alpha1"
+)
+
+const (
+	controllerConfigHeader = "apiVersion: config.tutorial.kubebuilder.io/v1alpha1\nkind: ControllerConfig\n"
+	managerConfigHeader    = "apiVersion: controller-runtime.sigs.k8s.io/v1alpha1\nkind: ControllerManagerConfig\n"
+)
+
+// writeConfigFiles writes contents into files of a temporary directory and
+// returns their paths.
+func writeConfigFiles(t *testing.T, contents ...string) []string {
+	t.Helper()
+	dir := t.TempDir()
+	paths := make([]string, 0, len(contents))
+	for i, content := range contents {
+		path := filepath.Join(dir, "config"+string(rune('a'+i))+".yaml")
+		if err := ioutil.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func TestIsManagerConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    bool
+		wantErr bool
+	}{
+		{name: "controller config", content: controllerConfigHeader, want: true},
+		{name: "manager config", content: managerConfigHeader, want: true},
+		{name: "kubeconfig", content: "apiVersion: v1\nkind: Config\nclusters: []\n"},
+		{name: "other config group", content: "apiVersion: config.example.com/v1alpha1\nkind: ControllerConfig\n"},
+		{name: "malformed", content: "kind: [ControllerConfig\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := isManagerConfig(writeConfigFiles(t, tt.content)[0])
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("isManagerConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("isManagerConfig() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := isManagerConfig(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("isManagerConfig() of a missing file = nil error")
+	}
+}
+
+func TestLoadManagerConfigs(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents []string
+		// wantErr is a substring of the expected error, none if empty.
+		wantErr string
+		check   func(t *testing.T, c *configv1alpha1.ControllerConfig)
+	}{{
+		name: "no files",
+		check: func(t *testing.T, c *configv1alpha1.ControllerConfig) {
+			if c != nil {
+				t.Errorf("config = %+v, want nil", c)
+			}
+		},
+	}, {
+		name: "controller config",
+		contents: []string{controllerConfigHeader + `metadata:
+  labels:
+    app.kubernetes.io/managed-by: kustomize
+health:
+  healthProbeBindAddress: :8081
+mirror:
+  targets:
+  - name: east
+    kubeconfig: /etc/mirror/east.kubeconfig
+  gc:
+    interval: 10m
+`},
+		check: func(t *testing.T, c *configv1alpha1.ControllerConfig) {
+			if c.Health.HealthProbeBindAddress != ":8081" {
+				t.Errorf("healthProbeBindAddress = %q, want :8081", c.Health.HealthProbeBindAddress)
+			}
+			if len(c.Mirror.Targets) != 1 || c.Mirror.Targets[0].Name != "east" {
+				t.Errorf("targets = %+v, want east", c.Mirror.Targets)
+			}
+			if c.Mirror.GC.Interval == nil || c.Mirror.GC.Interval.Duration != 10*time.Minute {
+				t.Errorf("gc.interval = %v, want 10m", c.Mirror.GC.Interval)
+			}
+		},
+	}, {
+		name:     "manager config",
+		contents: []string{managerConfigHeader + "metrics:\n  bindAddress: 127.0.0.1:8080\n"},
+		check: func(t *testing.T, c *configv1alpha1.ControllerConfig) {
+			if c.APIVersion != configv1alpha1.GroupVersion.String() || c.Kind != controllerConfigKind {
+				t.Errorf("type = %s %s, want a ControllerConfig", c.APIVersion, c.Kind)
+			}
+			if c.Metrics.BindAddress != "127.0.0.1:8080" {
+				t.Errorf("metrics.bindAddress = %q, want 127.0.0.1:8080", c.Metrics.BindAddress)
+			}
+		},
+	}, {
+		name:     "malformed file",
+		contents: []string{controllerConfigHeader + "mirror:\n  targets: [\n"},
+		wantErr:  "error decoding",
+	}, {
+		name:     "unknown field",
+		contents: []string{controllerConfigHeader + "mirror:\n  driftPolcy: Adopt\n"},
+		wantErr:  `unknown field "driftPolcy"`,
+	}, {
+		name:     "mirror settings in a manager config",
+		contents: []string{managerConfigHeader + "mirror:\n  driftPolicy: Adopt\n"},
+		wantErr:  `unknown field "mirror"`,
+	}, {
+		name:     "unexpected kind",
+		contents: []string{"apiVersion: v1\nkind: Config\n"},
+		wantErr:  "holds v1 Config",
+	}, {
+		name:     "invalid manager setting",
+		contents: []string{controllerConfigHeader + "metrics:\n  bindAddress: localhost\n"},
+		wantErr:  "metrics.bindAddress",
+	}, {
+		name:     "invalid mirror setting",
+		contents: []string{controllerConfigHeader + "mirror:\n  driftPolicy: Merge\n"},
+		wantErr:  `mirror.driftPolicy: Unsupported value: "Merge"`,
+	}, {
+		name: "files setting different values",
+		contents: []string{
+			controllerConfigHeader + "health:\n  healthProbeBindAddress: :8081\nmirror:\n  driftPolicy: Revert\n",
+			controllerConfigHeader + "health:\n  healthProbeBindAddress: :8081\nmirror:\n  driftPolicy: Adopt\n",
+		},
+		wantErr: "set different values: mirror.driftPolicy (Revert and Adopt)",
+	}, {
+		name: "files setting different settings",
+		contents: []string{
+			managerConfigHeader + "health:\n  healthProbeBindAddress: :8081\n",
+			controllerConfigHeader + "health:\n  healthProbeBindAddress: :8081\nmirror:\n  driftPolicy: Adopt\n",
+		},
+		check: func(t *testing.T, c *configv1alpha1.ControllerConfig) {
+			if c.Health.HealthProbeBindAddress != ":8081" || c.Mirror.DriftPolicy != "Adopt" {
+				t.Errorf("config = %+v, want the settings of both files", c)
+			}
+		},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var paths []string
+			if len(tt.contents) > 0 {
+				paths = writeConfigFiles(t, tt.contents...)
+			}
+			c, err := loadManagerConfigs(paths)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadManagerConfigs() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadManagerConfigs() error = %v", err)
+			}
+			tt.check(t, c)
+		})
+	}
+}
+
+func TestApplyConfigFlags(t *testing.T) {
+	reconciles := 4
+	batchSize := int64(50)
+	c := &configv1alpha1.ControllerConfig{
+		MaxConcurrentReconciles: &reconciles,
+		KCP:                     configv1alpha1.KCPConfig{APIExportName: "widgets"},
+		Mirror: configv1alpha1.MirrorConfig{
+			DriftPolicy:     "Adopt",
+			DeletionTimeout: &metav1.Duration{Duration: time.Minute},
+			GC:              configv1alpha1.MirrorGCConfig{BatchSize: &batchSize},
+		},
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	maxConcurrentReconciles := fs.Int("max-concurrent-reconciles", 0, "")
+	apiExportName := fs.String("api-export-name", "", "")
+	driftPolicy := fs.String("mirror-drift-policy", "Revert", "")
+	deletionTimeout := fs.Duration("mirror-deletion-timeout", 5*time.Minute, "")
+	gcBatchSize := fs.Int64("mirror-gc-batch-size", 100, "")
+	if err := fs.Parse([]string{"--mirror-drift-policy=Ignore", "--max-concurrent-reconciles=2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := applyConfigFlags(fs, c); err != nil {
+		t.Fatalf("applyConfigFlags() error = %v", err)
+	}
+	// Flags set on the command line override the file.
+	if *driftPolicy != "Ignore" {
+		t.Errorf("mirror-drift-policy = %q, want the flag value Ignore", *driftPolicy)
+	}
+	if *maxConcurrentReconciles != 2 {
+		t.Errorf("max-concurrent-reconciles = %d, want the flag value 2", *maxConcurrentReconciles)
+	}
+	// Other flags take the settings of the file.
+	if *apiExportName != "widgets" {
+		t.Errorf("api-export-name = %q, want widgets", *apiExportName)
+	}
+	if *deletionTimeout != time.Minute {
+		t.Errorf("mirror-deletion-timeout = %s, want 1m", *deletionTimeout)
+	}
+	if *gcBatchSize != 50 {
+		t.Errorf("mirror-gc-batch-size = %d, want 50", *gcBatchSize)
+	}
+
+	// Settings the flags cannot express are rejected.
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("mirror-target", "", "")
+	c = &configv1alpha1.ControllerConfig{Mirror: configv1alpha1.MirrorConfig{
+		Targets: []configv1alpha1.MirrorTargetConfig{{Name: "east", Kubeconfig: "/etc/mirror/a,b"}},
+	}}
+	err := applyConfigFlags(fs, c)
+	if err == nil || !strings.Contains(err.Error(), "mirror.targets[0]") {
+		t.Errorf("applyConfigFlags() error = %v, want the kubeconfig with a comma to be rejected", err)
+	}
+}
+
+func TestApplyManagerConfig(t *testing.T) {
+	spec := ctrlconfigv1alpha1.ControllerManagerConfigurationSpec{
+		GracefulShutdownTimeout: &metav1.Duration{Duration: time.Minute},
+		Health:                  ctrlconfigv1alpha1.ControllerHealth{HealthProbeBindAddress: ":9091"},
+		Metrics:                 ctrlconfigv1alpha1.ControllerMetrics{BindAddress: "127.0.0.1:9090"},
+	}
+	options := ctrl.Options{HealthProbeBindAddress: ":8081", LeaderElection: true}
+
+	c := &configv1alpha1.ControllerConfig{
+		ControllerManagerConfigurationSpec: spec,
+		Mirror:                             configv1alpha1.MirrorConfig{DriftPolicy: "Adopt"},
+	}
+
+	options, err := applyManagerConfig(options, c)
+	if err != nil {
+		t.Fatalf("applyManagerConfig() error = %v", err)
+	}
+	if options.HealthProbeBindAddress != ":8081" {
+		t.Errorf("healthProbeBindAddress = %q, want the option :8081", options.HealthProbeBindAddress)
+	}
+	if options.MetricsBindAddress != "127.0.0.1:9090" {
+		t.Errorf("metricsBindAddress = %q, want the setting 127.0.0.1:9090", options.MetricsBindAddress)
+	}
+	if options.GracefulShutdownTimeout == nil || *options.GracefulShutdownTimeout != time.Minute {
+		t.Errorf("gracefulShutdownTimeout = %v, want 1m", options.GracefulShutdownTimeout)
+	}
+	if !options.LeaderElection {
+		t.Error("leaderElection = false, want the option true")
+	}
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
+)
+
+// mirrorKindFlags collects repeated --mirror-kind flags, each naming a kind as
+// <apiVersion>/<kind>, e.g. v1/ConfigMap or apps/v1/Deployment.
+type mirrorKindFlags []schema.GroupVersionKind
+
+func (f *mirrorKindFlags) String() string {
+	kinds := make([]string, 0, len(*f))
+	for _, gvk := range *f {
+		kinds = append(kinds, formatKind(gvk))
+	}
+	return strings.Join(kinds, ",")
+}
+
+func (f *mirrorKindFlags) Set(value string) error {
+	i := strings.LastIndex(value, "/")
+	if i < 0 || i == len(value)-1 {
+		return fmt.Errorf("%q is not of the form <apiVersion>/<kind>", value)
+	}
+	gv, err := schema.ParseGroupVersion(value[:i])
+	if err != nil {
+		return err
+	}
+	if gv.Version == "" {
+		return fmt.Errorf("%q has no version", value)
+	}
+	gvk := gv.WithKind(value[i+1:])
+	if gvk.GroupKind() == tutorialkubebuilderiov1alpha1.GroupVersion.WithKind("Widget").GroupKind() {
+		return fmt.Errorf("kind %s is always mirrored", formatKind(gvk))
+	}
+	for _, existing := range *f {
+		if existing.GroupKind() == gvk.GroupKind() {
+			return fmt.Errorf("kind %s is given more than once", formatKind(gvk))
+		}
+	}
+	*f = append(*f, gvk)
+	return nil
+}
+
+// formatKind formats gvk the way --mirror-kind expects it.
+func formatKind(gvk schema.GroupVersionKind) string {
+	return gvk.GroupVersion().String() + "/" + gvk.Kind
+}
+
+// controllerName returns the name of the controller mirroring gvk.
+func controllerName(gvk schema.GroupVersionKind) string {
+	name := "mirror-" + strings.ToLower(gvk.Kind)
+	if gvk.Group != "" {
+		name += "." + gvk.Group
+	}
+	return name
+}
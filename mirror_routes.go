@@ -0,0 +1,55 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kcp-dev/logicalcluster/v2"
+
+	"github.com/yourrepo/kb-kcp-tutorial/controllers"
+)
+
+// mirrorRouteFlags collects repeated --mirror-workspace-route flags, each
+// routing a kcp workspace to its targets as <workspace>=<target>[+<target>...].
+type mirrorRouteFlags map[logicalcluster.Name][]string
+
+func (f *mirrorRouteFlags) String() string {
+	routes := make([]string, 0, len(*f))
+	for workspace, targets := range *f {
+		routes = append(routes, workspace.String()+"="+strings.Join(targets, "+"))
+	}
+	sort.Strings(routes)
+	return strings.Join(routes, ",")
+}
+
+func (f *mirrorRouteFlags) Set(value string) error {
+	workspace, targets, err := controllers.ParseMirrorRoute(value)
+	if err != nil {
+		return err
+	}
+	if *f == nil {
+		*f = mirrorRouteFlags{}
+	}
+	if _, ok := (*f)[workspace]; ok {
+		return fmt.Errorf("workspace %s is routed more than once", workspace)
+	}
+	(*f)[workspace] = targets
+	return nil
+}
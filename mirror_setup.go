@@ -0,0 +1,198 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/kcp"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
+	"github.com/yourrepo/kb-kcp-tutorial/controllers"
+)
+
+// mirrorOptions are the settings of the mirror mode.
+type mirrorOptions struct {
+	Targets          mirrorTargetFlags
+	Kinds            []schema.GroupVersionKind
+	DryRun           bool
+	NamespaceMapping controllers.NamespaceMapping
+	CreateNamespaces bool
+	Namespaces       controllers.MirrorNamespaces
+	Filter           controllers.MirrorFilter
+
+	DeletionPolicy  tutorialkubebuilderiov1alpha1.DeletionPolicy
+	DeletionTimeout time.Duration
+	DriftPolicy     tutorialkubebuilderiov1alpha1.DriftPolicy
+	ConflictPolicy  tutorialkubebuilderiov1alpha1.ConflictPolicy
+
+	WorkspaceRoutes         mirrorRouteFlags
+	WorkspaceLabel          string
+	WorkspaceDefaultTargets []string
+
+	GCInterval   time.Duration
+	GCBatchSize  int64
+	GCReportOnly bool
+
+	MaxConcurrentReconciles int
+}
+
+// validate rejects combinations of settings the mirror mode cannot honour.
+func (o mirrorOptions) validate() error {
+	if o.DriftPolicy != tutorialkubebuilderiov1alpha1.DriftPolicyAdopt {
+		return nil
+	}
+	for _, target := range o.Targets {
+		if len(target.Transforms) > 0 {
+			// The transformed content would be copied back into the
+			// reference cluster.
+			return fmt.Errorf("drift policy %s cannot be used with mirror target %s, which has transforms",
+				o.DriftPolicy, target.Name)
+		}
+	}
+	return nil
+}
+
+// setupMirroring adds a cluster per mirror target to supervisor, with a health
+// check on mgr, the manager of the reference cluster, and registers with mgr
+// the controllers mirroring Widgets and the other kinds into them, along with
+// the garbage collector of the targets. restConfig reaches the reference
+// cluster outside of any virtual workspace.
+func setupMirroring(mgr manager.Manager, supervisor *controllers.Supervisor, restConfig *rest.Config, o mirrorOptions) error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+	mirrorClusters := make([]mirrorCluster, 0, len(o.Targets))
+	for _, target := range o.Targets {
+		c, err := newMirrorCluster(target.Name, target.Kubeconfig)
+		if err != nil {
+			return fmt.Errorf("unable to create mirror cluster %s: %w", target.Name, err)
+		}
+		if err := supervisor.Add("mirror-"+target.Name, c); err != nil {
+			return fmt.Errorf("unable to add mirror cluster %s: %w", target.Name, err)
+		}
+		mc := mirrorCluster{
+			name:             target.Name,
+			cluster:          c,
+			namespaceMapping: o.NamespaceMapping,
+			transforms:       target.Transforms,
+			dryRun:           o.DryRun,
+		}
+		if target.NamespaceMapping != nil {
+			mc.namespaceMapping = *target.NamespaceMapping
+		}
+		if target.DryRun != nil {
+			mc.dryRun = *target.DryRun
+		}
+		create := o.CreateNamespaces
+		if target.CreateNamespaces != nil {
+			create = *target.CreateNamespaces
+		}
+		if create {
+			namespaces := o.Namespaces
+			mc.namespaces = &namespaces
+		}
+		mirrorClusters = append(mirrorClusters, mc)
+	}
+
+	var router *controllers.MirrorRouter
+	if len(o.WorkspaceRoutes) > 0 || o.WorkspaceLabel != "" {
+		router = &controllers.MirrorRouter{
+			Routes:         o.WorkspaceRoutes,
+			Label:          o.WorkspaceLabel,
+			DefaultTargets: o.WorkspaceDefaultTargets,
+		}
+		if o.WorkspaceLabel != "" {
+			// ClusterWorkspaces are not served by virtual workspaces.
+			workspaces, err := kcp.NewClusterAwareAPIReader(restConfig, client.Options{Scheme: scheme})
+			if err != nil {
+				return fmt.Errorf("unable to create workspace reader: %w", err)
+			}
+			router.Workspaces = workspaces
+		}
+		targets := make([]controllers.MirrorTarget, 0, len(mirrorClusters))
+		for _, mc := range mirrorClusters {
+			targets = append(targets, controllers.MirrorTarget{Name: mc.name})
+		}
+		if err := router.Validate(targets); err != nil {
+			return fmt.Errorf("invalid mirror workspace routing: %w", err)
+		}
+	}
+
+	for _, mc := range mirrorClusters {
+		if err := mgr.AddHealthzCheck("mirror-"+mc.name, supervisor.Check("mirror-"+mc.name)); err != nil {
+			return fmt.Errorf("unable to set up health check for mirror cluster %s: %w", mc.name, err)
+		}
+		if err := mgr.AddReadyzCheck("mirror-"+mc.name, mc.cluster.ReadyzCheck); err != nil {
+			return fmt.Errorf("unable to set up ready check for mirror cluster %s: %w", mc.name, err)
+		}
+	}
+
+	if err := NewMirrorWidgetReconciler(mgr, mirrorClusters, &controllers.WidgetReconciler{
+		DeletionPolicy:  o.DeletionPolicy,
+		DeletionTimeout: o.DeletionTimeout,
+		DriftPolicy:     o.DriftPolicy,
+		ConflictPolicy:  o.ConflictPolicy,
+		Filter:          o.Filter,
+		Router:          router,
+
+		MaxConcurrentReconciles: o.MaxConcurrentReconciles,
+	}); err != nil {
+		return fmt.Errorf("unable to create controller Widget: %w", err)
+	}
+	for _, gvk := range o.Kinds {
+		if err := NewMirrorReconciler(mgr, mirrorClusters, &controllers.MirrorReconciler{
+			GVK:             gvk,
+			DeletionPolicy:  o.DeletionPolicy,
+			DeletionTimeout: o.DeletionTimeout,
+			DriftPolicy:     o.DriftPolicy,
+			ConflictPolicy:  o.ConflictPolicy,
+			Filter:          o.Filter,
+			Router:          router,
+
+			MaxConcurrentReconciles: o.MaxConcurrentReconciles,
+		}); err != nil {
+			return fmt.Errorf("unable to create controller %s: %w", controllerName(gvk), err)
+		}
+	}
+
+	if o.GCInterval > 0 {
+		gc := &controllers.MirrorGarbageCollector{
+			Client:         mgr.GetClient(),
+			Kinds:          append([]schema.GroupVersionKind{tutorialkubebuilderiov1alpha1.GroupVersion.WithKind("Widget")}, o.Kinds...),
+			Filter:         o.Filter,
+			DeletionPolicy: o.DeletionPolicy,
+			Router:         router,
+			Interval:       o.GCInterval,
+			BatchSize:      o.GCBatchSize,
+			ReportOnly:     o.GCReportOnly,
+		}
+		recorder := mgr.GetEventRecorderFor("mirror-gc")
+		for _, mc := range mirrorClusters {
+			gc.Targets = append(gc.Targets, mc.target(recorder))
+		}
+		if err := mgr.Add(gc); err != nil {
+			return fmt.Errorf("unable to add mirror garbage collector: %w", err)
+		}
+	}
+	return nil
+}
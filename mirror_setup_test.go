@@ -0,0 +1,63 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	tutorialkubebuilderiov1alpha1 "github.com/yourrepo/kb-kcp-tutorial/api/v1alpha1"
+	"github.com/yourrepo/kb-kcp-tutorial/controllers"
+)
+
+func TestMirrorOptionsValidate(t *testing.T) {
+	transformed := mirrorTargetFlag{Name: "east", Transforms: []controllers.Transform{{
+		Labels: &controllers.MetadataTransform{Add: map[string]string{"mirrored": "true"}},
+	}}}
+	plain := mirrorTargetFlag{Name: "west"}
+
+	tests := []struct {
+		name    string
+		options mirrorOptions
+		// wantErr is a substring of the expected error, none if empty.
+		wantErr string
+	}{{
+		name:    "adopt without transforms",
+		options: mirrorOptions{Targets: mirrorTargetFlags{plain}, DriftPolicy: tutorialkubebuilderiov1alpha1.DriftPolicyAdopt},
+	}, {
+		name:    "revert with transforms",
+		options: mirrorOptions{Targets: mirrorTargetFlags{plain, transformed}, DriftPolicy: tutorialkubebuilderiov1alpha1.DriftPolicyRevert},
+	}, {
+		name:    "adopt with transforms",
+		options: mirrorOptions{Targets: mirrorTargetFlags{plain, transformed}, DriftPolicy: tutorialkubebuilderiov1alpha1.DriftPolicyAdopt},
+		wantErr: "drift policy Adopt cannot be used with mirror target east",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.options.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validate() = %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validate() = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/cluster"
+
+	"github.com/yourrepo/kb-kcp-tutorial/controllers"
+)
+
+// defaultMirrorTargetName is the name of the mirror target given by --config2.
+const defaultMirrorTargetName = "default"
+
+// mirrorTargetFlag is the value of a --mirror-target flag: a comma separated
+// list of key=value pairs, e.g. name=east,kubeconfig=/etc/mirror/east.kubeconfig.
+type mirrorTargetFlag struct {
+	Name       string
+	Kubeconfig string
+	// NamespaceMapping overrides --mirror-namespace-mapping for the target.
+	NamespaceMapping *controllers.NamespaceMapping
+	// Transforms are loaded from the file given by the transforms key.
+	Transforms []controllers.Transform
+	// DryRun overrides --mirror-dry-run for the target.
+	DryRun *bool
+	// CreateNamespaces overrides --mirror-create-namespaces for the target.
+	CreateNamespaces *bool
+}
+
+// mirrorTargetFlags collects repeated --mirror-target flags.
+type mirrorTargetFlags []mirrorTargetFlag
+
+func (f *mirrorTargetFlags) String() string {
+	names := make([]string, 0, len(*f))
+	for _, t := range *f {
+		names = append(names, t.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func (f *mirrorTargetFlags) Set(value string) error {
+	var t mirrorTargetFlag
+	for _, kv := range strings.Split(value, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("expected key=value, got %q", kv)
+		}
+		switch parts[0] {
+		case "name":
+			t.Name = parts[1]
+		case "kubeconfig":
+			t.Kubeconfig = parts[1]
+		case "namespace":
+			m, err := controllers.ParseNamespaceMapping(parts[1])
+			if err != nil {
+				return err
+			}
+			t.NamespaceMapping = &m
+		case "transforms":
+			transforms, err := controllers.LoadTransforms(parts[1])
+			if err != nil {
+				return err
+			}
+			t.Transforms = transforms
+		case "dry-run":
+			dryRun, err := strconv.ParseBool(parts[1])
+			if err != nil {
+				return fmt.Errorf("invalid dry-run: %w", err)
+			}
+			t.DryRun = &dryRun
+		case "create-namespaces":
+			create, err := strconv.ParseBool(parts[1])
+			if err != nil {
+				return fmt.Errorf("invalid create-namespaces: %w", err)
+			}
+			t.CreateNamespaces = &create
+		default:
+			return fmt.Errorf("unknown key %q", parts[0])
+		}
+	}
+	return f.add(t)
+}
+
+func (f *mirrorTargetFlags) add(t mirrorTargetFlag) error {
+	if errs := validation.IsDNS1123Label(t.Name); len(errs) > 0 {
+		return fmt.Errorf("invalid target name %q: %s", t.Name, strings.Join(errs, ", "))
+	}
+	if t.Kubeconfig == "" {
+		return fmt.Errorf("target %q: kubeconfig is required", t.Name)
+	}
+	for _, existing := range *f {
+		if existing.Name == t.Name {
+			return fmt.Errorf("duplicate target name %q", t.Name)
+		}
+	}
+	*f = append(*f, t)
+	return nil
+}
+
+// mirrorCluster is a mirror target and the cluster backing it.
+type mirrorCluster struct {
+	name             string
+	cluster          *controllers.MirrorCluster
+	namespaceMapping controllers.NamespaceMapping
+	transforms       []controllers.Transform
+	dryRun           bool
+	// namespaces is nil unless missing namespaces are created.
+	namespaces *controllers.MirrorNamespaces
+}
+
+// target returns the mirror target reconcilers write into. Dry-run targets
+// record the changes they would have made through recorder.
+func (mc mirrorCluster) target(recorder record.EventRecorder) controllers.MirrorTarget {
+	target := controllers.MirrorTarget{
+		Name:             mc.name,
+		Client:           mc.cluster.GetClient(),
+		NamespaceMapping: mc.namespaceMapping,
+		Transforms:       mc.transforms,
+		Reachable:        mc.cluster.Reachable,
+		Namespaces:       mc.namespaces,
+	}
+	if mc.dryRun {
+		target = controllers.DryRunTarget(target, recorder)
+	}
+	return target
+}
+
+// newMirrorCluster prepares a connection to the cluster described by the
+// kubeconfig file at path, reloading the connection whenever the file changes.
+func newMirrorCluster(name, path string) (*controllers.MirrorCluster, error) {
+	return controllers.NewMirrorCluster(name, path, func(o *cluster.Options) {
+		o.Scheme = scheme
+	})
+}
+
+// parseKeyValues parses a comma separated list of key=value pairs, as given to
+// --mirror-namespace-labels and --mirror-namespace-annotations. Keys must be
+// qualified names; label values must be valid label values.
+func parseKeyValues(value string, labelValues bool) (map[string]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	m := map[string]string{}
+	for _, kv := range strings.Split(value, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("expected key=value, got %q", kv)
+		}
+		if errs := validation.IsQualifiedName(parts[0]); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid key %q: %s", parts[0], strings.Join(errs, ", "))
+		}
+		if labelValues {
+			if errs := validation.IsValidLabelValue(parts[1]); len(errs) > 0 {
+				return nil, fmt.Errorf("invalid value %q: %s", parts[1], strings.Join(errs, ", "))
+			}
+		}
+		m[parts[0]] = parts[1]
+	}
+	return m, nil
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/kcp"
+
+	"github.com/yourrepo/kb-kcp-tutorial/controllers"
+)
+
+// Modes accepted by --mode.
+const (
+	// modeStandalone reconciles Widgets in the cluster of the kubeconfig.
+	modeStandalone = "standalone"
+	// modeKCP reconciles Widgets in every workspace bound to the APIExport,
+	// through its virtual workspace.
+	modeKCP = "kcp"
+	// modeAuto is modeKCP if the apis.kcp.dev group is served, and
+	// modeStandalone otherwise.
+	modeAuto = "auto"
+	// modeMirror mirrors the reconciled Widgets into the mirror targets. It is
+	// combined with one of the other modes.
+	modeMirror = "mirror"
+)
+
+// defaultMode keeps the behaviour of the controller before --mode existed.
+const defaultMode = modeStandalone + "," + modeMirror
+
+// runMode is the value of the --mode flag: a comma separated list of modes
+// holding at most one of standalone, kcp and auto, and optionally mirror,
+// e.g. kcp,mirror.
+type runMode struct {
+	// Reference is how the cluster holding the reference Widgets is reached:
+	// modeStandalone, modeKCP or modeAuto.
+	Reference string
+	// Mirror enables mirroring into the mirror targets.
+	Mirror bool
+}
+
+func (m *runMode) String() string {
+	if m.Mirror {
+		return m.Reference + "," + modeMirror
+	}
+	return m.Reference
+}
+
+func (m *runMode) Set(value string) error {
+	var mode runMode
+	for _, part := range strings.Split(value, ",") {
+		switch part {
+		case modeStandalone, modeKCP, modeAuto:
+			if mode.Reference != "" {
+				return fmt.Errorf("modes %s and %s are exclusive", mode.Reference, part)
+			}
+			mode.Reference = part
+		case modeMirror:
+			mode.Mirror = true
+		default:
+			return fmt.Errorf("unknown mode %q, expected %s, %s, %s or %s", part, modeStandalone, modeKCP, modeAuto, modeMirror)
+		}
+	}
+	if mode.Reference == "" {
+		mode.Reference = modeStandalone
+	}
+	*m = mode
+	return nil
+}
+
+// mirrorFlagsSet returns the flags set on fs that only apply in mirror mode.
+func mirrorFlagsSet(fs *flag.FlagSet) []string {
+	var names []string
+	fs.Visit(func(f *flag.Flag) {
+		if strings.HasPrefix(f.Name, "mirror-") || f.Name == "config2" {
+			names = append(names, "--"+f.Name)
+		}
+	})
+	sort.Strings(names)
+	return names
+}
+
+// newManager creates the manager of the reference cluster reached with
+// restConfig, according to mode. In kcp mode the manager is cluster aware and
+// talks to the virtual workspaces of the APIExport named apiExportName, one per
+// shard, while leader election stays in the workspace of restConfig. APIs are
+// discovered, and events written, through the virtual workspaces as well.
+func newManager(ctx context.Context, restConfig *rest.Config, mode runMode, apiExportName string, options ctrl.Options) (ctrl.Manager, error) {
+	reference := mode.Reference
+	if reference == modeAuto {
+		present, err := kcpAPIsGroupPresent(restConfig)
+		if err != nil {
+			return nil, err
+		}
+		reference = modeStandalone
+		if present {
+			reference = modeKCP
+		}
+		setupLog.Info("Detected mode", "mode", reference)
+	}
+
+	if reference == modeStandalone {
+		return ctrl.NewManager(restConfig, options)
+	}
+
+	setupLog.Info("Looking up virtual workspace URLs")
+	apiExport, err := getAPIExport(ctx, restConfig, apiExportName)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up virtual workspace URLs: %w", err)
+	}
+	urls, err := virtualWorkspaceURLs(apiExport)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up virtual workspace URLs: %w", err)
+	}
+	setupLog.Info("Using virtual workspace URLs", "urls", urls)
+
+	// Each shard of kcp serves the workspaces on it through its own virtual
+	// workspace, so a cluster-aware cache per virtual workspace feeds the
+	// controllers, and the client talks to the shard of the workspace it is
+	// given.
+	// APIs are discovered through any shard, as every shard serves those of
+	// the APIExport.
+	mapper := controllers.NewShardedRESTMapper(func(url string) (meta.RESTMapper, error) {
+		return kcp.NewClusterAwareMapperProvider(restConfigForVirtualWorkspace(restConfig, url))
+	})
+	if err := mapper.SetShards(urls); err != nil {
+		return nil, err
+	}
+	options.MapperProvider = func(*rest.Config) (meta.RESTMapper, error) {
+		return mapper, nil
+	}
+	var shards *controllers.ShardedCache
+	options.NewCache = func(config *rest.Config, opts cache.Options) (cache.Cache, error) {
+		shards = controllers.NewShardedCache(opts, func(url string) (cache.Cache, client.Client, error) {
+			shardConfig := restConfigForVirtualWorkspace(config, url)
+			shardCache, err := kcp.NewClusterAwareCache(shardConfig, opts)
+			if err != nil {
+				return nil, nil, err
+			}
+			shardClient, err := kcp.NewClusterAwareClient(shardCache, shardConfig, client.Options{Scheme: opts.Scheme, Mapper: opts.Mapper})
+			if err != nil {
+				return nil, nil, err
+			}
+			return shardCache, shardClient, nil
+		})
+		return shards, shards.SetShards(urls)
+	}
+	options.NewClient = func(cache.Cache, *rest.Config, client.Options, ...client.Object) (client.Client, error) {
+		if shards == nil {
+			return nil, fmt.Errorf("the sharded cache was not created")
+		}
+		return shards.Client(), nil
+	}
+	options.LeaderElectionConfig = restConfig
+	mgr, err := kcp.NewClusterAwareManager(restConfigForVirtualWorkspace(restConfig, urls[0]), options)
+	if err != nil {
+		return nil, err
+	}
+	// Shards are added and removed as the APIExport reports them.
+	if err := mgr.Add(&apiExportShards{config: restConfig, name: apiExport.Name, shards: shards, mapper: mapper}); err != nil {
+		return nil, err
+	}
+	// The recorders of the manager write to the first shard only, so events
+	// go through the sharded client to the shard of their object instead.
+	events := controllers.NewShardedEventRecorders(mgr.GetClient(), mgr.GetScheme())
+	if err := mgr.Add(events); err != nil {
+		return nil, err
+	}
+	return shardedManager{Manager: mgr, events: events}, nil
+}
+
+// shardedManager is a manager whose event recorders write to the shard of the
+// object of each event.
+type shardedManager struct {
+	ctrl.Manager
+	events *controllers.ShardedEventRecorders
+}
+
+func (m shardedManager) GetEventRecorderFor(name string) record.EventRecorder {
+	return m.events.GetEventRecorderFor(name)
+}
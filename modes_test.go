@@ -0,0 +1,117 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRunModeSet(t *testing.T) {
+	tests := []struct {
+		value string
+		want  runMode
+		// wantString is the value String returns after Set.
+		wantString string
+		// wantErr is a substring of the expected error, none if empty.
+		wantErr string
+	}{
+		{value: "standalone", want: runMode{Reference: modeStandalone}, wantString: "standalone"},
+		{value: "kcp", want: runMode{Reference: modeKCP}, wantString: "kcp"},
+		{value: "auto", want: runMode{Reference: modeAuto}, wantString: "auto"},
+		{value: "mirror", want: runMode{Reference: modeStandalone, Mirror: true}, wantString: "standalone,mirror"},
+		{value: defaultMode, want: runMode{Reference: modeStandalone, Mirror: true}, wantString: "standalone,mirror"},
+		{value: "kcp,mirror", want: runMode{Reference: modeKCP, Mirror: true}, wantString: "kcp,mirror"},
+		{value: "mirror,auto", want: runMode{Reference: modeAuto, Mirror: true}, wantString: "auto,mirror"},
+		{value: "mirror,mirror", want: runMode{Reference: modeStandalone, Mirror: true}, wantString: "standalone,mirror"},
+		{value: "kcp,standalone", wantErr: "modes kcp and standalone are exclusive"},
+		{value: "auto,kcp,mirror", wantErr: "modes auto and kcp are exclusive"},
+		{value: "KCP", wantErr: `unknown mode "KCP"`},
+		{value: "kcp mirror", wantErr: `unknown mode "kcp mirror"`},
+		{value: "kcp,", wantErr: `unknown mode ""`},
+		{value: "", wantErr: `unknown mode ""`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			previous := runMode{Reference: modeKCP}
+			mode := previous
+			err := mode.Set(tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Set(%q) error = %v, want it to contain %q", tt.value, err, tt.wantErr)
+				}
+				if mode != previous {
+					t.Errorf("Set(%q) changed the mode to %+v on error", tt.value, mode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Set(%q) error = %v", tt.value, err)
+			}
+			if mode != tt.want {
+				t.Errorf("Set(%q) = %+v, want %+v", tt.value, mode, tt.want)
+			}
+			if got := mode.String(); got != tt.wantString {
+				t.Errorf("String() = %q, want %q", got, tt.wantString)
+			}
+
+			var again runMode
+			if err := again.Set(mode.String()); err != nil {
+				t.Fatalf("Set(%q) of String() error = %v", mode.String(), err)
+			}
+			if again != mode {
+				t.Errorf("Set(String()) = %+v, want %+v", again, mode)
+			}
+		})
+	}
+}
+
+func TestMirrorFlagsSet(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{name: "none", want: nil},
+		{name: "defaults only", args: []string{"--mode=kcp", "--config=manager.yaml"}, want: nil},
+		{
+			name: "mirror flags",
+			args: []string{"--mode=kcp", "--mirror-target=name=east", "--config2=mirror.yaml", "--mirror-drift-policy=Adopt"},
+			want: []string{"--config2", "--mirror-drift-policy", "--mirror-target"},
+		},
+		{name: "set to their defaults", args: []string{"--mirror-drift-policy=Revert"}, want: []string{"--mirror-drift-policy"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			var mode runMode
+			fs.Var(&mode, "mode", "")
+			fs.String("config", "", "")
+			fs.String("config2", "", "")
+			fs.String("mirror-target", "", "")
+			fs.String("mirror-drift-policy", "Revert", "")
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if got := mirrorFlagsSet(fs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mirrorFlagsSet() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}